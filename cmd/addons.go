@@ -0,0 +1,183 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/joshisumit/eks-dns-troubleshooter/pkg/aws"
+	"github.com/joshisumit/eks-dns-troubleshooter/pkg/findings"
+	log "github.com/sirupsen/logrus"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+//eksFieldManager is the field manager used by EKS when it applies a managed add-on
+const eksFieldManager = "eks"
+
+//ignoredFieldManagers are controllers which update objects of an add-on without being a manual edit
+var ignoredFieldManagers = map[string]bool{
+	"kube-controller-manager": true,
+}
+
+//addonWorkload is the live workload (and its configmap) deployed by an add-on
+type addonWorkload struct {
+	kind      string
+	name      string
+	container string
+	configMap string
+}
+
+var addonWorkloads = map[string]addonWorkload{
+	"coredns":    {kind: "Deployment", name: "coredns", container: "coredns", configMap: "coredns"},
+	"kube-proxy": {kind: "DaemonSet", name: "kube-proxy", container: "kube-proxy", configMap: "kube-proxy-config"},
+	"vpc-cni":    {kind: "DaemonSet", name: "aws-node", container: "aws-node"},
+}
+
+//AddonDrift compares a managed add-on with the live objects running in the cluster
+type AddonDrift struct {
+	Addon          string   `json:"addon"`
+	Workload       string   `json:"workload"`
+	AddonVersion   string   `json:"addonVersion"`
+	LiveImage      string   `json:"liveImage,omitempty"`
+	LiveVersion    string   `json:"liveVersion,omitempty"`
+	VersionMatches bool     `json:"versionMatches"`
+	ManualEditors  []string `json:"manualEditors,omitempty"`
+}
+
+//manualFieldManagers returns the field managers (other than EKS and controllers) which changed an object
+func manualFieldManagers(meta metav1.ObjectMeta) []string {
+	managers := make([]string, 0)
+	seen := make(map[string]bool)
+	for _, entry := range meta.ManagedFields {
+		if strings.HasPrefix(entry.Manager, eksFieldManager) || ignoredFieldManagers[entry.Manager] || seen[entry.Manager] {
+			continue
+		}
+		seen[entry.Manager] = true
+		managers = append(managers, fmt.Sprintf("%s (%s)", entry.Manager, entry.Operation))
+	}
+	return managers
+}
+
+//containerImage returns the image of the named container, or of the first container
+func containerImage(spec v1.PodSpec, name string) string {
+	for _, c := range spec.Containers {
+		if c.Name == name {
+			return c.Image
+		}
+	}
+	if len(spec.Containers) > 0 {
+		return spec.Containers[0].Image
+	}
+	return ""
+}
+
+//normaliseImageVersion removes the image variant from a tag so that it matches the add-on version,
+//e.g. kube-proxy v1.29.0-minimal-eksbuild.1 is add-on version v1.29.0-eksbuild.1
+func normaliseImageVersion(tag string) string {
+	return strings.Replace(tag, "-minimal-", "-", 1)
+}
+
+//compareAddons compares every managed add-on with its live Deployment/DaemonSet to catch manual edits
+func compareAddons(ns string, addons []aws.AddonInfo) []AddonDrift {
+	drifts := make([]AddonDrift, 0)
+
+	for _, addon := range addons {
+		wl, ok := addonWorkloads[addon.Name]
+		if !addon.IsManaged || addon.Error != "" || !ok {
+			continue
+		}
+		drift := AddonDrift{
+			Addon:        addon.Name,
+			Workload:     fmt.Sprintf("%s/%s", wl.kind, wl.name),
+			AddonVersion: addon.Version,
+		}
+
+		var meta metav1.ObjectMeta
+		var spec v1.PodSpec
+		if wl.kind == "Deployment" {
+			dep, err := Clientset.AppsV1().Deployments(ns).Get(wl.name, metav1.GetOptions{})
+			if err != nil {
+				log.Warnf("Failed to get %s of add-on %s: %v", drift.Workload, addon.Name, err)
+				continue
+			}
+			meta, spec = dep.ObjectMeta, dep.Spec.Template.Spec
+		} else {
+			ds, err := Clientset.AppsV1().DaemonSets(ns).Get(wl.name, metav1.GetOptions{})
+			if err != nil {
+				log.Warnf("Failed to get %s of add-on %s: %v", drift.Workload, addon.Name, err)
+				continue
+			}
+			meta, spec = ds.ObjectMeta, ds.Spec.Template.Spec
+		}
+
+		drift.LiveImage = containerImage(spec, wl.container)
+		if ref, err := parseImageRef(drift.LiveImage); err == nil {
+			drift.LiveVersion = ref.Tag
+		}
+		drift.VersionMatches = normaliseImageVersion(drift.LiveVersion) == addon.Version
+
+		drift.ManualEditors = manualFieldManagers(meta)
+		if wl.configMap != "" {
+			cm, err := Clientset.CoreV1().ConfigMaps(ns).Get(wl.configMap, metav1.GetOptions{})
+			if err != nil {
+				log.Warnf("Failed to get configmap %s of add-on %s: %v", wl.configMap, addon.Name, err)
+			} else {
+				for _, m := range manualFieldManagers(cm.ObjectMeta) {
+					drift.ManualEditors = append(drift.ManualEditors, fmt.Sprintf("ConfigMap/%s: %s", wl.configMap, m))
+				}
+			}
+		}
+		drifts = append(drifts, drift)
+	}
+
+	log.Infof("Managed add-on drift: %+v", drifts)
+	return drifts
+}
+
+//addonFindings reports unhealthy managed add-ons and manual edits which the add-on will overwrite
+func addonFindings(addons []aws.AddonInfo, addonsError string, drifts []AddonDrift) []findings.Finding {
+	res := make([]findings.Finding, 0)
+	const source = "eksAddons"
+
+	//without the list of add-ons, self-managed and managed add-ons cannot be told apart
+	if addonsError != "" {
+		res = append(res, findings.New("ADDON-EVALUATION-INCOMPLETE", findings.SeverityInfo, source,
+			"EKS add-ons could not be listed, whether coredns, kube-proxy and vpc-cni are managed add-ons, their status and drift were not checked",
+			"Grant eks:ListAddons and eks:DescribeAddon to the troubleshooter.",
+			addonsError))
+	}
+
+	for _, addon := range addons {
+		if !addon.IsManaged {
+			continue
+		}
+		if addon.Error != "" {
+			res = append(res, findings.New("ADDON-DESCRIBE-FAILED", findings.SeverityInfo, source,
+				fmt.Sprintf("EKS managed add-on %s could not be described, its status and drift were not checked", addon.Name),
+				"Grant eks:DescribeAddon to the troubleshooter.",
+				addon.Error))
+			continue
+		}
+		if addon.Status != "ACTIVE" || len(addon.HealthIssues) != 0 {
+			res = append(res, findings.New("ADDON-UNHEALTHY", findings.SeverityCritical, source,
+				fmt.Sprintf("EKS managed add-on %s is in %s status with %d health issue(s)", addon.Name, addon.Status, len(addon.HealthIssues)),
+				fmt.Sprintf("Check the add-on with `aws eks describe-addon --addon-name %s` and resolve the reported issues.", addon.Name),
+				addon.HealthIssues...))
+		}
+	}
+
+	for _, d := range drifts {
+		if !d.VersionMatches {
+			res = append(res, findings.New("ADDON-VERSION-DRIFT", findings.SeverityWarning, source,
+				fmt.Sprintf("%s runs image %s while the EKS managed add-on %s is at version %s, the image was changed outside of the add-on and will be reverted on the next add-on update", d.Workload, d.LiveImage, d.Addon, d.AddonVersion),
+				fmt.Sprintf("Change the version with `aws eks update-addon --addon-name %s` instead of editing %s.", d.Addon, d.Workload)))
+		}
+		if len(d.ManualEditors) != 0 {
+			res = append(res, findings.New("ADDON-MANUAL-EDIT", findings.SeverityWarning, source,
+				fmt.Sprintf("Objects of the EKS managed add-on %s were modified outside of EKS, these changes are overwritten when the add-on is updated", d.Addon),
+				"Move the changes into the add-on configuration values (aws eks update-addon --configuration-values) or use --resolve-conflicts PRESERVE.",
+				d.ManualEditors...))
+		}
+	}
+	return res
+}
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/joshisumit/eks-dns-troubleshooter/pkg/aws"
+	appsv1 "k8s.io/api/apps/v1"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func podTemplate(container, image string) v1.PodTemplateSpec {
+	return v1.PodTemplateSpec{Spec: v1.PodSpec{Containers: []v1.Container{{Name: container, Image: image}}}}
+}
+
+func TestCompareAddons(t *testing.T) {
+	const ns = "kube-system"
+	const registry = "602401143452.dkr.ecr.us-west-2.amazonaws.com/eks/"
+	Clientset = fake.NewSimpleClientset(
+		&appsv1.Deployment{
+			ObjectMeta: metav1.ObjectMeta{Name: "coredns", Namespace: ns, ManagedFields: []metav1.ManagedFieldsEntry{
+				{Manager: "eks", Operation: metav1.ManagedFieldsOperationApply},
+				{Manager: "kubectl-edit", Operation: metav1.ManagedFieldsOperationUpdate},
+			}},
+			Spec: appsv1.DeploymentSpec{Template: podTemplate("coredns", registry+"coredns:v1.10.1-eksbuild.1")},
+		},
+		&appsv1.DaemonSet{
+			ObjectMeta: metav1.ObjectMeta{Name: "kube-proxy", Namespace: ns},
+			Spec:       appsv1.DaemonSetSpec{Template: podTemplate("kube-proxy", registry+"kube-proxy:v1.29.0-minimal-eksbuild.1")},
+		},
+		&v1.ConfigMap{ObjectMeta: metav1.ObjectMeta{Name: "coredns", Namespace: ns}},
+		&v1.ConfigMap{ObjectMeta: metav1.ObjectMeta{Name: "kube-proxy-config", Namespace: ns}},
+	)
+
+	drifts := compareAddons(ns, []aws.AddonInfo{
+		{Name: "coredns", IsManaged: true, Version: "v1.11.1-eksbuild.4"},
+		{Name: "kube-proxy", IsManaged: true, Version: "v1.29.0-eksbuild.1"},
+		{Name: "vpc-cni", IsManaged: true, Error: "Failed to describe add-on vpc-cni: AccessDenied"},
+	})
+	if len(drifts) != 2 {
+		t.Fatalf("got %d drifts, want 2 (add-ons with errors are skipped): %+v", len(drifts), drifts)
+	}
+
+	coredns, kubeProxy := drifts[0], drifts[1]
+	if coredns.VersionMatches || coredns.LiveVersion != "v1.10.1-eksbuild.1" {
+		t.Errorf("expected coredns version drift: %+v", coredns)
+	}
+	if len(coredns.ManualEditors) != 1 || coredns.ManualEditors[0] != "kubectl-edit (Update)" {
+		t.Errorf("unexpected coredns manual editors: %v", coredns.ManualEditors)
+	}
+	//the -minimal image variant of kube-proxy is the same version as the add-on
+	if !kubeProxy.VersionMatches {
+		t.Errorf("expected kube-proxy minimal image to match the add-on version: %+v", kubeProxy)
+	}
+}
+
+func TestNormaliseImageVersion(t *testing.T) {
+	tests := map[string]string{
+		"v1.29.0-minimal-eksbuild.1": "v1.29.0-eksbuild.1",
+		"v1.29.0-eksbuild.1":         "v1.29.0-eksbuild.1",
+		"v1.11.1-eksbuild.4":         "v1.11.1-eksbuild.4",
+		"":                           "",
+	}
+	for tag, want := range tests {
+		if got := normaliseImageVersion(tag); got != want {
+			t.Errorf("normaliseImageVersion(%q) = %q, want %q", tag, got, want)
+		}
+	}
+}
+
+func TestAddonFindingsListError(t *testing.T) {
+	res := addonFindings(nil, "Failed to list add-ons of cluster dev: AccessDeniedException", nil)
+	if len(res) != 1 || res[0].ID != "ADDON-EVALUATION-INCOMPLETE" {
+		t.Fatalf("got findings %+v, want ADDON-EVALUATION-INCOMPLETE", res)
+	}
+	if len(addonFindings(nil, "", nil)) != 0 {
+		t.Errorf("got findings without a list error")
+	}
+}
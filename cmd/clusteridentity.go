@@ -0,0 +1,153 @@
+package main
+
+import (
+	"flag"
+	"os"
+	"regexp"
+	"strings"
+
+	"github.com/joshisumit/eks-dns-troubleshooter/pkg/aws"
+	log "github.com/sirupsen/logrus"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+const (
+	//envClusterName is the EKS cluster name, required where neither tags nor instance metadata are available (e.g. Fargate)
+	envClusterName = "EKS_DNS_CLUSTER_NAME"
+	envRegion      = "AWS_REGION"
+	envRegionAlt   = "AWS_DEFAULT_REGION"
+
+	labelRegion        = "topology.kubernetes.io/region"
+	labelRegionBeta    = "failure-domain.beta.kubernetes.io/region"
+	labelEksctlCluster = "alpha.eksctl.io/cluster-name"
+	//kubeProxyKubeconfigMap holds the kubeconfig of kube-proxy with the API server endpoint
+	kubeProxyKubeconfigMap = "kube-proxy"
+	awsAuthConfigMap       = "aws-auth"
+	kubeProxyKubeconfig    = "kubeconfig"
+	awsAuthMapRoles        = "mapRoles"
+	sourceFlags            = "command line flags"
+	sourceEnv              = "environment variables"
+	sourceNode             = "node"
+	sourceKubeProxyConfig  = "kube-proxy configmap"
+	sourceAwsAuthConfig    = "aws-auth configmap"
+)
+
+var (
+	clusterNameFlag = flag.String("cluster-name", "", "EKS cluster name, overrides "+envClusterName)
+	regionFlag      = flag.String("region", "", "AWS region of the cluster, overrides "+envRegion)
+
+	//providerID of EC2 nodes: aws:///us-west-2a/i-0123456789abcdef0
+	providerIDRegex = regexp.MustCompile(`^aws:///([^/]+)/(i-[0-9a-f]+)$`)
+	//region prefix of an availability zone, also for local zones like us-west-2-lax-1a
+	zoneRegionRegex = regexp.MustCompile(`^([a-z]{2}(-gov|-iso[a-z]?)?-[a-z]+-\d+)`)
+	//API server endpoint in the kubeconfig of kube-proxy: https://ABCD.gr7.us-west-2.eks.amazonaws.com
+	endpointRegex = regexp.MustCompile(`(https://[a-zA-Z0-9.-]+\.([a-z0-9-]+)\.eks\.amazonaws\.com(\.cn)?)`)
+	//node role names created by eksctl and the Karpenter getting started guide
+	nodeRoleClusterRegexes = []*regexp.Regexp{
+		regexp.MustCompile(`role/eksctl-(.+)-nodegroup-`),
+		regexp.MustCompile(`role/KarpenterNodeRole-(.+)$`),
+	}
+)
+
+//regionFromZone returns the region of an availability zone
+func regionFromZone(zone string) string {
+	if m := zoneRegionRegex.FindStringSubmatch(zone); m != nil {
+		return m[1]
+	}
+	return ""
+}
+
+//resolveFromNode reads region and instance ID from the labels and providerID of the node
+func resolveFromNode(id *aws.ClusterIdentity, node *v1.Node) {
+	if node == nil {
+		id.Attempt(sourceNode, "skipped, node of the troubleshooter is unknown")
+		return
+	}
+	for _, label := range []string{labelRegion, labelRegionBeta} {
+		id.SetRegion(node.Labels[label], sourceNode+" label "+label)
+	}
+	if m := providerIDRegex.FindStringSubmatch(node.Spec.ProviderID); m != nil {
+		id.SetRegion(regionFromZone(m[1]), sourceNode+" providerID")
+		id.SetInstanceID(m[2], sourceNode+" providerID")
+	}
+}
+
+//resolveFromConfigMaps reads the API server endpoint from the kube-proxy kubeconfig and
+//guesses the cluster name from node role names in aws-auth
+func resolveFromConfigMaps(id *aws.ClusterIdentity) {
+	cm, err := Clientset.CoreV1().ConfigMaps(metav1.NamespaceSystem).Get(kubeProxyKubeconfigMap, metav1.GetOptions{})
+	if err != nil {
+		id.Attempt(sourceKubeProxyConfig, err.Error())
+	} else if m := endpointRegex.FindStringSubmatch(cm.Data[kubeProxyKubeconfig]); m != nil {
+		id.APIServerEndpoint = m[1]
+		id.SetRegion(m[2], sourceKubeProxyConfig+" API server endpoint")
+		id.ResolveFromEndpoint()
+	} else {
+		id.Attempt(sourceKubeProxyConfig, "no EKS API server endpoint found")
+	}
+	if id.ClusterName != "" {
+		return
+	}
+
+	cm, err = Clientset.CoreV1().ConfigMaps(metav1.NamespaceSystem).Get(awsAuthConfigMap, metav1.GetOptions{})
+	if err != nil {
+		id.Attempt(sourceAwsAuthConfig, err.Error())
+		return
+	}
+	for _, line := range strings.Split(cm.Data[awsAuthMapRoles], "\n") {
+		for _, re := range nodeRoleClusterRegexes {
+			if m := re.FindStringSubmatch(strings.TrimSpace(line)); m != nil {
+				id.SetClusterName(m[1], sourceAwsAuthConfig+" node role name")
+				return
+			}
+		}
+	}
+	id.Attempt(sourceAwsAuthConfig, "no node role name containing the cluster name found")
+}
+
+//discoverClusterIdentity runs the chain of cluster name and region discovery sources,
+//the first source which returns a value wins
+func discoverClusterIdentity(selfNode string) *aws.ClusterIdentity {
+	id := &aws.ClusterIdentity{}
+
+	//1. explicit configuration
+	id.SetClusterName(*clusterNameFlag, sourceFlags+" -cluster-name")
+	id.SetRegion(*regionFlag, sourceFlags+" -region")
+	id.SetClusterName(os.Getenv(envClusterName), sourceEnv+" "+envClusterName)
+	id.SetRegion(os.Getenv(envRegion), sourceEnv+" "+envRegion)
+	id.SetRegion(os.Getenv(envRegionAlt), sourceEnv+" "+envRegionAlt)
+
+	//2. node labels and providerID give region and instance ID, tags of the instance give the cluster name
+	var node *v1.Node
+	if selfNode != "" {
+		n, err := Clientset.CoreV1().Nodes().Get(selfNode, metav1.GetOptions{})
+		if err != nil {
+			log.Warnf("Failed to get node %s: %v", selfNode, err)
+		} else {
+			node = n
+		}
+	}
+	if !id.Complete() {
+		resolveFromNode(id, node)
+		id.ResolveFromTags()
+	}
+	if node != nil {
+		id.SetClusterName(node.Labels[labelEksctlCluster], sourceNode+" label "+labelEksctlCluster)
+	}
+
+	//3. kube-system configmaps
+	if !id.Complete() {
+		resolveFromConfigMaps(id)
+	}
+
+	//4. instance metadata (IMDSv2), then retry the sources which need region or instance ID
+	if !id.Complete() || id.InstanceID == "" {
+		id.ResolveFromIMDS()
+		id.ResolveFromTags()
+		id.ResolveFromEndpoint()
+	}
+
+	log.Infof("Cluster identity: %+v", id)
+	return id
+}
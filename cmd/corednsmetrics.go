@@ -0,0 +1,357 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+
+	"github.com/joshisumit/eks-dns-troubleshooter/pkg/findings"
+)
+
+const (
+	//minRequestsForRatios avoids findings on ratios computed from a handful of queries
+	minRequestsForRatios = 100
+	//minWindowRequestsForRatios is the same for the queries received during the scrape window
+	minWindowRequestsForRatios = 20
+
+	servfailWarnRatio     = 0.05
+	servfailCriticalRatio = 0.20
+	refusedWarnRatio      = 0.01
+	nxdomainInfoRatio     = 0.5
+	cacheHitInfoRatio     = 0.3
+	requestP99WarnSeconds = 1.0
+	forwardAvgWarnSeconds = 0.5
+)
+
+//metric names changed in coredns 1.7.0, the first name of each list is the current one
+var (
+	requestsMetrics           = []string{"coredns_dns_requests_total", "coredns_dns_request_count_total"}
+	responsesMetrics          = []string{"coredns_dns_responses_total", "coredns_dns_response_rcode_count_total"}
+	cacheHitsMetrics          = []string{"coredns_cache_hits_total"}
+	cacheMissesMetrics        = []string{"coredns_cache_misses_total"}
+	panicsMetrics             = []string{"coredns_panics_total", "coredns_panic_count_total"}
+	forwardResponsesMetrics   = []string{"coredns_forward_responses_total", "coredns_forward_response_rcode_count_total"}
+	healthcheckFailureMetrics = []string{"coredns_forward_healthcheck_failures_total", "coredns_forward_healthcheck_failure_count_total"}
+	healthcheckBrokenMetrics  = []string{"coredns_forward_healthcheck_broken_total", "coredns_forward_healthcheck_broken_count_total"}
+	maxConcurrentMetrics      = []string{"coredns_forward_max_concurrent_rejects_total", "coredns_forward_max_concurrent_reject_count_total"}
+	requestDurationMetric     = "coredns_dns_request_duration_seconds"
+	forwardDurationMetric     = "coredns_forward_request_duration_seconds"
+)
+
+//DurationPercentiles are percentiles (in seconds) estimated from a histogram like histogram_quantile does
+type DurationPercentiles struct {
+	P50 float64 `json:"p50"`
+	P90 float64 `json:"p90"`
+	P99 float64 `json:"p99"`
+}
+
+//MetricsWindow holds the increase of the error counters of a coredns pod between the two scrapes
+type MetricsWindow struct {
+	Seconds              float64            `json:"seconds"`
+	Requests             float64            `json:"requests"`
+	Rcodes               map[string]float64 `json:"responseRcodes,omitempty"`
+	ServfailRatio        float64            `json:"servfailRatio"`
+	RefusedRatio         float64            `json:"refusedRatio"`
+	HealthcheckFailures  map[string]float64 `json:"forwardHealthcheckFailures,omitempty"`
+	HealthcheckBroken    float64            `json:"forwardHealthcheckBroken"`
+	MaxConcurrentRejects float64            `json:"forwardMaxConcurrentRejects"`
+	Panics               float64            `json:"panics"`
+}
+
+//CorednsPodMetrics summarises the prometheus metrics of a coredns pod.
+//Totals, ratios and percentiles are cumulative since the pod started, rates and Window cover the scrape window.
+//Errors are judged on Window only, a pod which failed long ago would otherwise be reported forever.
+type CorednsPodMetrics struct {
+	Pod                  string               `json:"pod"`
+	IP                   string               `json:"ip"`
+	Node                 string               `json:"node,omitempty"`
+	Error                string               `json:"error,omitempty"`
+	RequestsPerSecond    float64              `json:"requestsPerSecond"`
+	Requests             float64              `json:"requests"`
+	Rcodes               map[string]float64   `json:"responseRcodes,omitempty"`
+	ServfailRatio        float64              `json:"servfailRatio"`
+	NxdomainRatio        float64              `json:"nxdomainRatio"`
+	RefusedRatio         float64              `json:"refusedRatio"`
+	CacheHits            float64              `json:"cacheHits"`
+	CacheMisses          float64              `json:"cacheMisses"`
+	CacheHitRatio        float64              `json:"cacheHitRatio"`
+	ForwardsPerSecond    float64              `json:"forwardsPerSecond"`
+	ForwardRcodes        map[string]float64   `json:"forwardRcodes,omitempty"`
+	ForwardAvgSeconds    float64              `json:"forwardAvgSeconds"`
+	ForwardDuration      *DurationPercentiles `json:"forwardDuration,omitempty"`
+	HealthcheckFailures  map[string]float64   `json:"forwardHealthcheckFailures,omitempty"`
+	HealthcheckBroken    float64              `json:"forwardHealthcheckBroken"`
+	MaxConcurrentRejects float64              `json:"forwardMaxConcurrentRejects"`
+	Panics               float64              `json:"panics"`
+	RequestDuration      *DurationPercentiles `json:"requestDuration,omitempty"`
+	Window               *MetricsWindow       `json:"window,omitempty"`
+	UnknownLayout        bool                 `json:"unknownMetricsLayout,omitempty"`
+}
+
+//sumFirst returns the sum of the first metric of names exposed in the samples
+func sumFirst(samples []metricSample, names []string) (float64, bool) {
+	for _, name := range names {
+		if v, ok := sumSamples(samples, name, nil); ok {
+			return v, true
+		}
+	}
+	return 0, false
+}
+
+//sumByLabel returns the sums of the first metric of names exposed in the samples, grouped by a label
+func sumByLabel(samples []metricSample, names []string, label string) map[string]float64 {
+	for _, name := range names {
+		res := make(map[string]float64)
+		for _, s := range samples {
+			if s.name == name && !math.IsNaN(s.value) {
+				res[s.labels[label]] += s.value
+			}
+		}
+		if len(res) > 0 {
+			return res
+		}
+	}
+	return nil
+}
+
+//histogramQuantiles estimates percentiles of a histogram, summing the buckets of every series by upper bound
+func histogramQuantiles(samples []metricSample, name string) *DurationPercentiles {
+	counts := make(map[float64]float64)
+	for _, s := range samples {
+		if s.name != name+"_bucket" {
+			continue
+		}
+		le, err := strconv.ParseFloat(s.labels["le"], 64)
+		if err != nil {
+			continue
+		}
+		counts[le] += s.value
+	}
+	bounds := make([]float64, 0, len(counts))
+	for le := range counts {
+		bounds = append(bounds, le)
+	}
+	sort.Float64s(bounds)
+	if len(bounds) == 0 || counts[bounds[len(bounds)-1]] == 0 {
+		return nil
+	}
+	total := counts[bounds[len(bounds)-1]]
+
+	quantile := func(q float64) float64 {
+		rank := q * total
+		lower, lowerCount := 0.0, 0.0
+		for _, le := range bounds {
+			if counts[le] >= rank {
+				//the +Inf bucket has no upper bound, report the highest finite bound
+				if math.IsInf(le, 1) {
+					return lower
+				}
+				if counts[le] == lowerCount {
+					return le
+				}
+				return lower + (le-lower)*(rank-lowerCount)/(counts[le]-lowerCount)
+			}
+			lower, lowerCount = le, counts[le]
+		}
+		return lower
+	}
+	return &DurationPercentiles{P50: quantile(0.5), P90: quantile(0.9), P99: quantile(0.99)}
+}
+
+//ratio returns part/total, or 0 without a total
+func ratio(part, total float64) float64 {
+	if total == 0 {
+		return 0
+	}
+	return part / total
+}
+
+//analyseWindow computes the increase of the error counters between the scrapes, nil without a second scrape
+func analyseWindow(sc *podScrape) *MetricsWindow {
+	if sc.err != nil || sc.window <= 0 {
+		return nil
+	}
+	w := &MetricsWindow{Seconds: sc.window}
+	w.Requests, _, _ = sc.counterIncrease(requestsMetrics, nil)
+	w.Rcodes = sc.increaseByLabel(responsesMetrics, "rcode")
+	responses := 0.0
+	for _, v := range w.Rcodes {
+		responses += v
+	}
+	w.ServfailRatio = ratio(w.Rcodes["SERVFAIL"], responses)
+	w.RefusedRatio = ratio(w.Rcodes["REFUSED"], responses)
+
+	w.HealthcheckFailures = sc.increaseByLabel(healthcheckFailureMetrics, "to")
+	for to, failures := range w.HealthcheckFailures {
+		if failures == 0 {
+			delete(w.HealthcheckFailures, to)
+		}
+	}
+	w.HealthcheckBroken, _, _ = sc.counterIncrease(healthcheckBrokenMetrics, nil)
+	w.MaxConcurrentRejects, _, _ = sc.counterIncrease(maxConcurrentMetrics, nil)
+	w.Panics, _, _ = sc.counterIncrease(panicsMetrics, nil)
+	return w
+}
+
+//analyseMetrics summarises request rate, rcodes, cache, forward and panic metrics of every scraped coredns pod
+func analyseMetrics(scrapes []podScrape) []CorednsPodMetrics {
+	res := make([]CorednsPodMetrics, 0, len(scrapes))
+	for i := range scrapes {
+		sc := &scrapes[i]
+		m := CorednsPodMetrics{Pod: sc.pod, IP: sc.ip, Node: sc.node}
+		//the latest scrape holds the totals, the first one is enough when the second failed
+		latest := sc.second
+		if len(latest) == 0 {
+			latest = sc.first
+		}
+		if len(latest) == 0 {
+			if sc.err != nil {
+				m.Error = sc.err.Error()
+			}
+			res = append(res, m)
+			continue
+		}
+
+		var ok bool
+		if m.Requests, ok = sumFirst(latest, requestsMetrics); !ok {
+			m.UnknownLayout = true
+		}
+		m.RequestsPerSecond, _, _ = sc.counterRate(requestsMetrics, nil)
+		m.Rcodes = sumByLabel(latest, responsesMetrics, "rcode")
+		responses := 0.0
+		for _, v := range m.Rcodes {
+			responses += v
+		}
+		m.ServfailRatio = ratio(m.Rcodes["SERVFAIL"], responses)
+		m.NxdomainRatio = ratio(m.Rcodes["NXDOMAIN"], responses)
+		m.RefusedRatio = ratio(m.Rcodes["REFUSED"], responses)
+
+		m.CacheHits, _ = sumFirst(latest, cacheHitsMetrics)
+		m.CacheMisses, _ = sumFirst(latest, cacheMissesMetrics)
+		m.CacheHitRatio = ratio(m.CacheHits, m.CacheHits+m.CacheMisses)
+
+		m.ForwardsPerSecond, _, _ = sc.counterRate(forwardRequestMetrics, nil)
+		m.ForwardRcodes = sumByLabel(latest, forwardResponsesMetrics, "rcode")
+		fwdSum, _ := sumSamples(latest, forwardDurationMetric+"_sum", nil)
+		fwdCount, _ := sumSamples(latest, forwardDurationMetric+"_count", nil)
+		m.ForwardAvgSeconds = ratio(fwdSum, fwdCount)
+		m.ForwardDuration = histogramQuantiles(latest, forwardDurationMetric)
+		m.HealthcheckFailures = sumByLabel(latest, healthcheckFailureMetrics, "to")
+		m.HealthcheckBroken, _ = sumFirst(latest, healthcheckBrokenMetrics)
+		m.MaxConcurrentRejects, _ = sumFirst(latest, maxConcurrentMetrics)
+
+		m.Panics, _ = sumFirst(latest, panicsMetrics)
+		m.RequestDuration = histogramQuantiles(latest, requestDurationMetric)
+		m.Window = analyseWindow(sc)
+		res = append(res, m)
+	}
+	return res
+}
+
+//metricsFindings reports SERVFAIL/REFUSED ratios, panics, forward healthcheck failures and slow responses of coredns pods
+func metricsFindings(metrics []CorednsPodMetrics) []findings.Finding {
+	res := make([]findings.Finding, 0)
+	const source = "corednsMetrics"
+
+	unavailable := make([]string, 0)
+	for _, m := range metrics {
+		pod := fmt.Sprintf("%s (%s)", m.Pod, m.IP)
+		if m.Error != "" {
+			unavailable = append(unavailable, fmt.Sprintf("%s: %s", pod, m.Error))
+			continue
+		}
+		if m.UnknownLayout {
+			unavailable = append(unavailable, fmt.Sprintf("%s: no request metrics found, unknown coredns metrics layout", pod))
+		}
+		rcodes := fmt.Sprintf("responses by rcode since start: %v", m.Rcodes)
+
+		if m.Requests >= minRequestsForRatios {
+			if m.NxdomainRatio >= nxdomainInfoRatio {
+				res = append(res, findings.New("METRICS-HIGH-NXDOMAIN", findings.SeverityInfo, source,
+					fmt.Sprintf("%.1f%% of the responses of coredns %s are NXDOMAIN", m.NxdomainRatio*100, pod),
+					"Most NXDOMAIN answers come from search path expansion with ndots:5. Use fully qualified names (trailing dot) or lower ndots in dnsConfig of chatty workloads, or enable the autopath plugin.",
+					rcodes))
+			}
+			if m.CacheHits+m.CacheMisses > 0 && m.CacheHitRatio < cacheHitInfoRatio {
+				res = append(res, findings.New("METRICS-LOW-CACHE-HIT-RATIO", findings.SeverityInfo, source,
+					fmt.Sprintf("The cache hit ratio of coredns %s is %.1f%%", pod, m.CacheHitRatio*100),
+					"Most queries are forwarded upstream. Consider a larger cache TTL/size, NodeLocal DNSCache, or reducing search path queries.",
+					fmt.Sprintf("cache hits: %.0f, misses: %.0f", m.CacheHits, m.CacheMisses)))
+			}
+		}
+
+		//errors are only reported when they happened during the scrape window, the totals since start are evidence
+		if w := m.Window; w != nil {
+			windowRcodes := fmt.Sprintf("responses by rcode in the last %.0fs: %v", w.Seconds, w.Rcodes)
+			if w.Panics > 0 {
+				res = append(res, findings.New("METRICS-COREDNS-PANICS", findings.SeverityCritical, source,
+					fmt.Sprintf("coredns %s recovered from %.0f panic(s) in the last %.0fs", pod, w.Panics, w.Seconds),
+					"Queries hitting a panic get no answer. Check the coredns logs for the stack trace and upgrade coredns to a version which fixes it.",
+					fmt.Sprintf("coredns_panics_total since start: %.0f", m.Panics)))
+			}
+			if w.Requests >= minWindowRequestsForRatios {
+				switch {
+				case w.ServfailRatio >= servfailCriticalRatio:
+					res = append(res, findings.New("METRICS-HIGH-SERVFAIL", findings.SeverityCritical, source,
+						fmt.Sprintf("%.1f%% of the responses of coredns %s in the last %.0fs are SERVFAIL", w.ServfailRatio*100, pod, w.Seconds),
+						"SERVFAIL usually means the upstream resolvers fail or time out. Check the forward healthcheck failures, the upstream checks and the link-local allowance of the node.",
+						windowRcodes, rcodes))
+				case w.ServfailRatio >= servfailWarnRatio:
+					res = append(res, findings.New("METRICS-HIGH-SERVFAIL", findings.SeverityWarning, source,
+						fmt.Sprintf("%.1f%% of the responses of coredns %s in the last %.0fs are SERVFAIL", w.ServfailRatio*100, pod, w.Seconds),
+						"SERVFAIL usually means the upstream resolvers fail or time out. Check the forward healthcheck failures, the upstream checks and the link-local allowance of the node.",
+						windowRcodes, rcodes))
+				}
+				if w.RefusedRatio >= refusedWarnRatio {
+					res = append(res, findings.New("METRICS-REFUSED-RESPONSES", findings.SeverityWarning, source,
+						fmt.Sprintf("%.1f%% of the responses of coredns %s in the last %.0fs are REFUSED", w.RefusedRatio*100, pod, w.Seconds),
+						"Queries for zones which no server block of the Corefile serves are refused. Add the zone or a forward for it to the Corefile.",
+						windowRcodes, rcodes))
+				}
+			}
+			if w.HealthcheckBroken > 0 {
+				res = append(res, findings.New("METRICS-FORWARD-ALL-UPSTREAMS-DOWN", findings.SeverityCritical, source,
+					fmt.Sprintf("coredns %s found every upstream of a forward plugin unhealthy %.0f time(s) in the last %.0fs", pod, w.HealthcheckBroken, w.Seconds),
+					"All forward targets failed their healthchecks at the same time. Check routing, security groups and NACLs towards the upstream resolvers.",
+					fmt.Sprintf("coredns_forward_healthcheck_broken_total since start: %.0f", m.HealthcheckBroken)))
+			}
+			if len(w.HealthcheckFailures) > 0 {
+				evidence := make([]string, 0, len(w.HealthcheckFailures))
+				for to, failures := range w.HealthcheckFailures {
+					evidence = append(evidence, fmt.Sprintf("%s: %.0f failed healthchecks in the last %.0fs, %.0f since start", to, failures, w.Seconds, m.HealthcheckFailures[to]))
+				}
+				sort.Strings(evidence)
+				res = append(res, findings.New("METRICS-FORWARD-HEALTHCHECK-FAILURES", findings.SeverityWarning, source,
+					fmt.Sprintf("Upstream healthchecks of coredns %s failed in the last %.0fs", pod, w.Seconds),
+					"coredns marks upstreams which fail healthchecks as down. Check that the upstream servers answer and are reachable from the coredns pods.",
+					evidence...))
+			}
+			if w.MaxConcurrentRejects > 0 {
+				res = append(res, findings.New("METRICS-FORWARD-MAX-CONCURRENT", findings.SeverityCritical, source,
+					fmt.Sprintf("coredns %s rejected %.0f queries in the last %.0fs because the forward max_concurrent limit was reached", pod, w.MaxConcurrentRejects, w.Seconds),
+					"The upstream answers too slowly for the query rate. Raise max_concurrent of the forward plugin, add cache, or fix the slow upstream.",
+					fmt.Sprintf("coredns_forward_max_concurrent_rejects_total since start: %.0f", m.MaxConcurrentRejects)))
+			}
+		}
+		if m.RequestDuration != nil && m.RequestDuration.P99 >= requestP99WarnSeconds {
+			res = append(res, findings.New("METRICS-SLOW-RESPONSES", findings.SeverityWarning, source,
+				fmt.Sprintf("The p99 response time of coredns %s is %.2fs", pod, m.RequestDuration.P99),
+				"Clients time out after a few seconds and retry. Check the upstream response times and the CPU limits of coredns.",
+				fmt.Sprintf("request duration p50: %.3fs, p90: %.3fs, p99: %.3fs", m.RequestDuration.P50, m.RequestDuration.P90, m.RequestDuration.P99),
+				fmt.Sprintf("average forward duration: %.3fs", m.ForwardAvgSeconds)))
+		} else if m.ForwardAvgSeconds >= forwardAvgWarnSeconds {
+			res = append(res, findings.New("METRICS-SLOW-UPSTREAM", findings.SeverityWarning, source,
+				fmt.Sprintf("Forwarded queries of coredns %s take %.2fs on average", pod, m.ForwardAvgSeconds),
+				"The upstream resolvers answer slowly. Check the upstream checks, the link-local allowance and routes towards the upstream resolvers.",
+				fmt.Sprintf("forward responses by rcode: %v", m.ForwardRcodes)))
+		}
+	}
+	if len(unavailable) > 0 {
+		res = append(res, findings.New("METRICS-UNAVAILABLE", findings.SeverityInfo, source,
+			"The prometheus metrics of some coredns pods could not be analysed",
+			"Make sure the prometheus plugin is enabled (prometheus :9153) and that NetworkPolicies or security groups allow the troubleshooter to reach port 9153 of the coredns pods.",
+			unavailable...))
+	}
+	return res
+}
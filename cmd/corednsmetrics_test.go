@@ -0,0 +1,66 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func mustParseMetrics(t *testing.T, text string) []metricSample {
+	samples, err := parseMetrics(strings.NewReader(text))
+	if err != nil {
+		t.Fatalf("parseMetrics returned error: %v", err)
+	}
+	return samples
+}
+
+func findingIDs(metrics []CorednsPodMetrics) map[string]bool {
+	ids := make(map[string]bool)
+	for _, f := range metricsFindings(metrics) {
+		ids[f.ID] = true
+	}
+	return ids
+}
+
+func TestMetricsFindingsUseWindow(t *testing.T) {
+	//old errors: the counters are high but do not increase during the window
+	before := `coredns_dns_requests_total{server="dns://:53"} 1000
+coredns_dns_responses_total{rcode="NOERROR"} 500
+coredns_dns_responses_total{rcode="SERVFAIL"} 500
+coredns_panics_total 3
+coredns_forward_healthcheck_failures_total{to="10.0.0.2:53"} 7
+coredns_forward_max_concurrent_rejects_total 12
+`
+	after := `coredns_dns_requests_total{server="dns://:53"} 1100
+coredns_dns_responses_total{rcode="NOERROR"} 600
+coredns_dns_responses_total{rcode="SERVFAIL"} 500
+coredns_panics_total 3
+coredns_forward_healthcheck_failures_total{to="10.0.0.2:53"} 7
+coredns_forward_max_concurrent_rejects_total 12
+`
+	sc := podScrape{pod: "coredns-a", ip: "10.0.1.10", first: mustParseMetrics(t, before), second: mustParseMetrics(t, after), window: 10}
+	metrics := analyseMetrics([]podScrape{sc})
+	if w := metrics[0].Window; w == nil || w.Requests != 100 || w.ServfailRatio != 0 || w.Panics != 0 {
+		t.Fatalf("unexpected window: %+v", metrics[0].Window)
+	}
+	for _, id := range []string{"METRICS-COREDNS-PANICS", "METRICS-HIGH-SERVFAIL", "METRICS-FORWARD-HEALTHCHECK-FAILURES", "METRICS-FORWARD-MAX-CONCURRENT"} {
+		if findingIDs(metrics)[id] {
+			t.Errorf("%s reported for errors which did not happen during the window", id)
+		}
+	}
+
+	//new errors during the window
+	after = `coredns_dns_requests_total{server="dns://:53"} 1100
+coredns_dns_responses_total{rcode="NOERROR"} 550
+coredns_dns_responses_total{rcode="SERVFAIL"} 550
+coredns_panics_total 4
+coredns_forward_healthcheck_failures_total{to="10.0.0.2:53"} 9
+coredns_forward_max_concurrent_rejects_total 20
+`
+	sc.second = mustParseMetrics(t, after)
+	ids := findingIDs(analyseMetrics([]podScrape{sc}))
+	for _, id := range []string{"METRICS-COREDNS-PANICS", "METRICS-HIGH-SERVFAIL", "METRICS-FORWARD-HEALTHCHECK-FAILURES", "METRICS-FORWARD-MAX-CONCURRENT"} {
+		if !ids[id] {
+			t.Errorf("%s not reported for errors during the window", id)
+		}
+	}
+}
@@ -65,32 +65,73 @@ func parseCorefile(corefile string) (bool, error) {
 	return status, nil
 }
 
-//checkLogs - Check for Errors in the DNS pod  -> fetch logs of coredns pod
-func checkLogs(podNames []string) (map[string]interface{}, error) {
-	//example: for p in $(kubectl get pods --namespace=kube-system -l k8s-app=kube-dns -o name); do kubectl logs --namespace=kube-system $p; done
-	//0. List all the pods running with kube-dns label in kube-system namespace
-	//https://127.0.0.1:32768/api/v1/namespaces/kube-system/pods?labelSelector=k8s-app%3Dkube-dns&limit=500
-	//kubectl logs -n kube-system --selector 'k8s-app=kube-dns' -> api/v1/namespaces/kube-system/pods?labelSelector=k8s-app=kube-dns
+//parseServerBlocks parses a Corefile into its server blocks (e.g. ".:53", "cluster.local:53")
+func parseServerBlocks(name string, corefile string) ([]caddyfile.ServerBlock, error) {
+	serverBlocks, err := caddyfile.Parse(name, bytes.NewReader([]byte(corefile)), nil)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to parse %s: %v", name, err)
+	}
+	return serverBlocks, nil
+}
 
-	//1. Get pods logs
+//directiveArgs returns the arguments of every occurrence of a plugin in a server block
+//e.g. "forward . 10.0.0.2 {" => [".", "10.0.0.2"]
+func directiveArgs(block caddyfile.ServerBlock, directive string) [][]string {
+	res := make([][]string, 0)
+	tokens := block.Tokens[directive]
+	for i := 0; i < len(tokens); i++ {
+		if tokens[i].Text != directive || (i > 0 && tokens[i-1].Line == tokens[i].Line) {
+			continue
+		}
+		args := make([]string, 0)
+		for j := i + 1; j < len(tokens) && tokens[j].Line == tokens[i].Line; j++ {
+			if tokens[j].Text != "{" {
+				args = append(args, tokens[j].Text)
+			}
+		}
+		res = append(res, args)
+	}
+	return res
+}
+
+//getPodLogs returns the logs of a pod, only the last tailLines lines if tailLines is greater than 0
+func getPodLogs(ns string, podName string, tailLines int64) (string, error) {
 	api := Clientset.CoreV1()
-	req := api.Pods("kube-system").GetLogs(podNames[0], &v1.PodLogOptions{})
+	opts := &v1.PodLogOptions{}
+	if tailLines > 0 {
+		opts.TailLines = &tailLines
+	}
+	req := api.Pods(ns).GetLogs(podName, opts)
 
 	log.Debugf("pod request object: %v", req)
 
 	podLogs, err := req.Stream()
 	if err != nil {
 		log.Errorf("error in opening stream: %v", err)
-		return nil, fmt.Errorf("error in opening stream: %v", err)
+		return "", fmt.Errorf("error in opening stream: %v", err)
 	}
 	defer podLogs.Close()
 
 	buf := new(bytes.Buffer)
 	_, err = io.Copy(buf, podLogs)
 	if err != nil {
-		return nil, fmt.Errorf("error in copy information from podLogs to buf")
+		return "", fmt.Errorf("error in copy information from podLogs to buf")
+	}
+	return buf.String(), nil
+}
+
+//checkLogs - Check for Errors in the DNS pod  -> fetch logs of coredns pod
+func checkLogs(podNames []string) (map[string]interface{}, error) {
+	//example: for p in $(kubectl get pods --namespace=kube-system -l k8s-app=kube-dns -o name); do kubectl logs --namespace=kube-system $p; done
+	//0. List all the pods running with kube-dns label in kube-system namespace
+	//https://127.0.0.1:32768/api/v1/namespaces/kube-system/pods?labelSelector=k8s-app%3Dkube-dns&limit=500
+	//kubectl logs -n kube-system --selector 'k8s-app=kube-dns' -> api/v1/namespaces/kube-system/pods?labelSelector=k8s-app=kube-dns
+
+	//1. Get pods logs
+	logContent, err := getPodLogs("kube-system", podNames[0], 0)
+	if err != nil {
+		return nil, err
 	}
-	logContent := buf.String()
 	//log.Debugf("Pod logs are %v", logContent)
 
 	//2. Check if seeing any errors in the logs
@@ -128,7 +169,25 @@ func checkForErrorsInLogs(ns string, cd *Coredns) error {
 		return fmt.Errorf("Failed to parse corefile: %s", err)
 	}
 
-	//3. If log plugin is not enabled, enable it by updating/patching Configmap
+	//3. Match the logs of every coredns pod against the catalogue of known log signatures
+	//errors plugin logs these lines even if log plugin is not enabled
+	signatures, err := loadLogSignatures()
+	if err != nil {
+		log.Errorf("Failed to load log signatures: %v", err)
+		return fmt.Errorf("Failed to load log signatures: %v", err)
+	}
+	cd.LogSignatureMatches = make([]LogSignatureMatch, 0)
+	for _, podName := range cd.PodNamesList {
+		logContent, err := getPodLogs(ns, podName, 0)
+		if err != nil {
+			log.Warnf("Skipping log signature checks for pod %s: %v", podName, err)
+			continue
+		}
+		cd.LogSignatureMatches = append(cd.LogSignatureMatches, matchLogSignatures(signatures, podName, logContent)...)
+	}
+	log.Infof("Log signature matches: %+v", cd.LogSignatureMatches)
+
+	//4. If log plugin is not enabled, enable it by updating/patching Configmap
 	if !isLogPluginEnabled {
 		log.Infof("Log Plugin is not enabled, skipping coredns logs checking...")
 		return nil
@@ -0,0 +1,292 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/joshisumit/eks-dns-troubleshooter/pkg/findings"
+	log "github.com/sirupsen/logrus"
+	appsv1 "k8s.io/api/apps/v1"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+const (
+	//restartCountThreshold is the restart count of a coredns container above which it is reported
+	restartCountThreshold = 3
+	reasonOOMKilled       = "OOMKilled"
+	reasonCrashLoop       = "CrashLoopBackOff"
+)
+
+//DeploymentHealth stores health details of the coredns deployment and its pods
+type DeploymentHealth struct {
+	DesiredReplicas   int32                 `json:"desiredReplicas"`
+	UpdatedReplicas   int32                 `json:"updatedReplicas"`
+	ReadyReplicas     int32                 `json:"readyReplicas"`
+	AvailableReplicas int32                 `json:"availableReplicas"`
+	Conditions        []DeploymentCondition `json:"conditions,omitempty"`
+	Containers        []ContainerSpec       `json:"containers"`
+	Pods              []PodHealth           `json:"pods"`
+}
+
+//DeploymentCondition is a condition of the deployment (e.g. Available, Progressing)
+type DeploymentCondition struct {
+	Type    string `json:"type"`
+	Status  string `json:"status"`
+	Reason  string `json:"reason,omitempty"`
+	Message string `json:"message,omitempty"`
+}
+
+//ContainerSpec stores probe and resource configuration of a container in the pod template
+type ContainerSpec struct {
+	Name           string            `json:"name"`
+	Image          string            `json:"image"`
+	LivenessProbe  string            `json:"livenessProbe,omitempty"`
+	ReadinessProbe string            `json:"readinessProbe,omitempty"`
+	Requests       map[string]string `json:"requests,omitempty"`
+	Limits         map[string]string `json:"limits,omitempty"`
+}
+
+//PodHealth stores the status of a coredns pod
+type PodHealth struct {
+	Name       string            `json:"name"`
+	Phase      string            `json:"phase"`
+	Ready      bool              `json:"ready"`
+	Node       string            `json:"node"`
+	NodeReady  bool              `json:"nodeReady"`
+	Containers []ContainerHealth `json:"containers"`
+}
+
+//ContainerHealth stores the restart count and last termination state of a container
+type ContainerHealth struct {
+	Name                  string `json:"name"`
+	Ready                 bool   `json:"ready"`
+	RestartCount          int32  `json:"restartCount"`
+	WaitingReason         string `json:"waitingReason,omitempty"`
+	LastTerminationReason string `json:"lastTerminationReason,omitempty"`
+	LastTerminationExit   int32  `json:"lastTerminationExitCode,omitempty"`
+}
+
+//describeProbe returns a short human readable description of a probe
+func describeProbe(p *v1.Probe) string {
+	if p == nil {
+		return ""
+	}
+	var handler string
+	switch {
+	case p.HTTPGet != nil:
+		handler = fmt.Sprintf("httpGet %s:%s", p.HTTPGet.Path, p.HTTPGet.Port.String())
+	case p.TCPSocket != nil:
+		handler = fmt.Sprintf("tcpSocket %s", p.TCPSocket.Port.String())
+	case p.Exec != nil:
+		handler = fmt.Sprintf("exec %s", strings.Join(p.Exec.Command, " "))
+	}
+	return fmt.Sprintf("%s initialDelay=%ds timeout=%ds period=%ds failureThreshold=%d", handler, p.InitialDelaySeconds, p.TimeoutSeconds, p.PeriodSeconds, p.FailureThreshold)
+}
+
+//resourceListToMap converts a ResourceList (e.g. requests) into a map of strings
+func resourceListToMap(rl v1.ResourceList) map[string]string {
+	if len(rl) == 0 {
+		return nil
+	}
+	res := make(map[string]string)
+	for name, qty := range rl {
+		res[string(name)] = qty.String()
+	}
+	return res
+}
+
+//getDeploymentPods returns the pods selected by the deployment selector
+func getDeploymentPods(dep *appsv1.Deployment) ([]v1.Pod, error) {
+	selector, err := metav1.LabelSelectorAsSelector(dep.Spec.Selector)
+	if err != nil {
+		return nil, fmt.Errorf("invalid selector of deployment %s: %v", dep.Name, err)
+	}
+
+	podList, err := Clientset.CoreV1().Pods(dep.Namespace).List(metav1.ListOptions{LabelSelector: selector.String()})
+	if err != nil {
+		return nil, fmt.Errorf("Failed to list pods of deployment %s: %v", dep.Name, err)
+	}
+	return podList.Items, nil
+}
+
+//isNodeReady checks the Ready condition of a node
+func isNodeReady(node *v1.Node) bool {
+	for _, cond := range node.Status.Conditions {
+		if cond.Type == v1.NodeReady {
+			return cond.Status == v1.ConditionTrue
+		}
+	}
+	return false
+}
+
+//checkDeploymentHealth inspects the coredns deployment: conditions, replicas, pod/container status, probes and resources
+func checkDeploymentHealth(ns string) (*DeploymentHealth, error) {
+	dep, err := Clientset.AppsV1().Deployments(ns).Get("coredns", metav1.GetOptions{})
+	if err != nil {
+		log.Errorf("Failed to check coredns deployment %s", err)
+		return nil, fmt.Errorf("Failed to check coredns deployment: %v", err)
+	}
+
+	health := &DeploymentHealth{
+		DesiredReplicas:   Int32Value(dep.Spec.Replicas),
+		UpdatedReplicas:   dep.Status.UpdatedReplicas,
+		ReadyReplicas:     dep.Status.ReadyReplicas,
+		AvailableReplicas: dep.Status.AvailableReplicas,
+		Containers:        make([]ContainerSpec, 0),
+		Pods:              make([]PodHealth, 0),
+	}
+
+	for _, cond := range dep.Status.Conditions {
+		health.Conditions = append(health.Conditions, DeploymentCondition{
+			Type:    string(cond.Type),
+			Status:  string(cond.Status),
+			Reason:  cond.Reason,
+			Message: cond.Message,
+		})
+	}
+
+	for _, c := range dep.Spec.Template.Spec.Containers {
+		health.Containers = append(health.Containers, ContainerSpec{
+			Name:           c.Name,
+			Image:          c.Image,
+			LivenessProbe:  describeProbe(c.LivenessProbe),
+			ReadinessProbe: describeProbe(c.ReadinessProbe),
+			Requests:       resourceListToMap(c.Resources.Requests),
+			Limits:         resourceListToMap(c.Resources.Limits),
+		})
+	}
+
+	pods, err := getDeploymentPods(dep)
+	if err != nil {
+		log.Errorf("%v", err)
+		return health, err
+	}
+
+	nodeReady := make(map[string]bool)
+	for _, pod := range pods {
+		ph := PodHealth{
+			Name:       pod.Name,
+			Phase:      string(pod.Status.Phase),
+			Node:       pod.Spec.NodeName,
+			Containers: make([]ContainerHealth, 0),
+		}
+		for _, cond := range pod.Status.Conditions {
+			if cond.Type == v1.PodReady {
+				ph.Ready = cond.Status == v1.ConditionTrue
+			}
+		}
+
+		if ph.Node != "" {
+			ready, ok := nodeReady[ph.Node]
+			if !ok {
+				node, err := Clientset.CoreV1().Nodes().Get(ph.Node, metav1.GetOptions{})
+				if err != nil {
+					log.Warnf("Failed to get node %s of pod %s: %v", ph.Node, pod.Name, err)
+				} else {
+					ready = isNodeReady(node)
+				}
+				nodeReady[ph.Node] = ready
+			}
+			ph.NodeReady = ready
+		}
+
+		for _, cs := range pod.Status.ContainerStatuses {
+			ch := ContainerHealth{
+				Name:         cs.Name,
+				Ready:        cs.Ready,
+				RestartCount: cs.RestartCount,
+			}
+			if cs.State.Waiting != nil {
+				ch.WaitingReason = cs.State.Waiting.Reason
+			}
+			if cs.LastTerminationState.Terminated != nil {
+				ch.LastTerminationReason = cs.LastTerminationState.Terminated.Reason
+				ch.LastTerminationExit = cs.LastTerminationState.Terminated.ExitCode
+			}
+			ph.Containers = append(ph.Containers, ch)
+		}
+		health.Pods = append(health.Pods, ph)
+	}
+
+	log.Infof("coredns deployment health: %+v", health)
+	return health, nil
+}
+
+//deploymentHealthFindings converts problems of the coredns deployment into findings
+func deploymentHealthFindings(h *DeploymentHealth) []findings.Finding {
+	res := make([]findings.Finding, 0)
+	if h == nil {
+		return res
+	}
+	const source = "corednsDeployment"
+
+	for _, cond := range h.Conditions {
+		failed := (cond.Type == string(appsv1.DeploymentAvailable) || cond.Type == string(appsv1.DeploymentProgressing)) && cond.Status != string(v1.ConditionTrue)
+		failed = failed || (cond.Type == string(appsv1.DeploymentReplicaFailure) && cond.Status == string(v1.ConditionTrue))
+		if failed {
+			res = append(res, findings.New("COREDNS-DEPLOYMENT-CONDITION", findings.SeverityCritical, source,
+				fmt.Sprintf("coredns deployment condition %s is %s", cond.Type, cond.Status),
+				"Check the events of the coredns deployment and its ReplicaSet (kubectl describe deployment coredns -n kube-system).",
+				fmt.Sprintf("%s=%s reason=%s message=%s", cond.Type, cond.Status, cond.Reason, cond.Message)))
+		}
+	}
+
+	if h.AvailableReplicas < h.DesiredReplicas {
+		res = append(res, findings.New("COREDNS-REPLICAS-UNAVAILABLE", findings.SeverityCritical, source,
+			fmt.Sprintf("Only %d of %d desired coredns replicas are available", h.AvailableReplicas, h.DesiredReplicas),
+			"Check why the remaining coredns pods are not running or not ready (scheduling, image pull, crashes).",
+			fmt.Sprintf("desired=%d updated=%d ready=%d available=%d", h.DesiredReplicas, h.UpdatedReplicas, h.ReadyReplicas, h.AvailableReplicas)))
+	}
+
+	for _, c := range h.Containers {
+		if c.LivenessProbe == "" || c.ReadinessProbe == "" {
+			res = append(res, findings.New("COREDNS-PROBES-MISSING", findings.SeverityWarning, source,
+				fmt.Sprintf("Container %s of coredns deployment does not have both liveness and readiness probes, so unhealthy pods keep receiving DNS traffic", c.Name),
+				"Configure a livenessProbe on the health plugin (:8080/health) and a readinessProbe on the ready plugin (:8181/ready).",
+				fmt.Sprintf("livenessProbe=%q readinessProbe=%q", c.LivenessProbe, c.ReadinessProbe)))
+		}
+		if c.Limits["memory"] == "" {
+			res = append(res, findings.New("COREDNS-MEMORY-LIMIT-MISSING", findings.SeverityWarning, source,
+				fmt.Sprintf("Container %s of coredns deployment has no memory limit, so a coredns pod can use all the memory of the node under heavy load", c.Name),
+				"Set memory requests and limits on the coredns container (the EKS default is 70Mi request and 170Mi limit, increase it for large clusters).",
+				fmt.Sprintf("requests=%v limits=%v", c.Requests, c.Limits)))
+		}
+	}
+
+	for _, p := range h.Pods {
+		if p.Phase != string(v1.PodRunning) || !p.Ready {
+			res = append(res, findings.New("COREDNS-POD-NOT-READY", findings.SeverityCritical, source,
+				fmt.Sprintf("coredns pod %s is not serving DNS traffic (phase %s, ready %t)", p.Name, p.Phase, p.Ready),
+				"Describe the pod and check its events and logs.",
+				fmt.Sprintf("pod=%s node=%s", p.Name, p.Node)))
+		}
+		if p.Node != "" && !p.NodeReady {
+			res = append(res, findings.New("COREDNS-NODE-NOT-READY", findings.SeverityCritical, source,
+				fmt.Sprintf("coredns pod %s is scheduled on node %s which is not Ready", p.Name, p.Node),
+				"Check the node conditions and kubelet logs of the node, or drain the node so coredns is rescheduled.",
+				fmt.Sprintf("pod=%s node=%s", p.Name, p.Node)))
+		}
+		for _, c := range p.Containers {
+			evidence := fmt.Sprintf("pod=%s container=%s restarts=%d waiting=%q lastTermination=%q exitCode=%d", p.Name, c.Name, c.RestartCount, c.WaitingReason, c.LastTerminationReason, c.LastTerminationExit)
+			switch {
+			case c.LastTerminationReason == reasonOOMKilled:
+				res = append(res, findings.New("COREDNS-OOMKILLED", findings.SeverityCritical, source,
+					fmt.Sprintf("Container %s of coredns pod %s was OOMKilled", c.Name, p.Name),
+					"Increase the memory limit of coredns, and check the cache size and the number of queries received by coredns.",
+					evidence))
+			case c.WaitingReason == reasonCrashLoop:
+				res = append(res, findings.New("COREDNS-CRASHLOOP", findings.SeverityCritical, source,
+					fmt.Sprintf("Container %s of coredns pod %s is in CrashLoopBackOff", c.Name, p.Name),
+					"Check the logs of the previous container (kubectl logs --previous) for Corefile errors or loop detection.",
+					evidence))
+			case c.RestartCount > restartCountThreshold:
+				res = append(res, findings.New("COREDNS-CONTAINER-RESTARTS", findings.SeverityWarning, source,
+					fmt.Sprintf("Container %s of coredns pod %s restarted %d times", c.Name, p.Name, c.RestartCount),
+					"Check the last termination reason and the logs of the previous container.",
+					evidence))
+			}
+		}
+	}
+	return res
+}
@@ -0,0 +1,212 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/joshisumit/eks-dns-troubleshooter/pkg/findings"
+	log "github.com/sirupsen/logrus"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+const (
+	auditHostNetworkPolicy = "HOSTNETWORK-WITHOUT-CLUSTERFIRSTWITHHOSTNET"
+	auditDefaultPolicy     = "DNSPOLICY-DEFAULT"
+	auditCustomNameservers = "DNSCONFIG-BYPASSES-COREDNS"
+	auditHighNdots         = "DNSCONFIG-HIGH-NDOTS"
+
+	//maxNdots is the highest ndots value which is not reported, Kubernetes default is 5
+	maxNdots = 5
+	//maxAuditEvidence limits the number of workloads listed in a finding
+	maxAuditEvidence     = 20
+	labelPodTemplateHash = "pod-template-hash"
+	//auditPageSize is the number of pods fetched per list call, large clusters have too many pods for a single response
+	auditPageSize = 500
+)
+
+//auditSkippedNamespaces contains system namespaces whose pods intentionally use node DNS (e.g. coredns, aws-node)
+var auditSkippedNamespaces = map[string]bool{
+	"kube-system": true,
+}
+
+//auditRules describes each risky DNS configuration
+var auditRules = map[string]struct {
+	severity    findings.Severity
+	explanation string
+	remediation string
+}{
+	auditHostNetworkPolicy: {findings.SeverityWarning,
+		"Pods with hostNetwork: true and dnsPolicy ClusterFirst fall back to the DNS configuration of the node, they cannot resolve cluster names (services)",
+		"Set dnsPolicy: ClusterFirstWithHostNet on pods which use hostNetwork and need to resolve cluster names."},
+	auditDefaultPolicy: {findings.SeverityWarning,
+		"Pods with dnsPolicy: Default use the DNS configuration of the node (VPC resolver) instead of CoreDNS, they cannot resolve cluster names",
+		"Use dnsPolicy: ClusterFirst (the default) unless the pod only resolves external names on purpose."},
+	auditCustomNameservers: {findings.SeverityWarning,
+		"Pods with custom dnsConfig nameservers send queries to resolvers other than CoreDNS (or NodeLocal DNSCache), cluster names do not resolve and CoreDNS troubleshooting does not apply to them",
+		"Remove the custom nameservers, or make sure the configured resolvers can resolve the names the pod needs."},
+	auditHighNdots: {findings.SeverityInfo,
+		fmt.Sprintf("Pods with ndots higher than %d try every search domain before the absolute name, multiplying the number of queries sent to CoreDNS for external names", maxNdots),
+		"Lower ndots (e.g. 2) in dnsConfig.options, or use fully qualified names ending with a dot."},
+}
+
+//WorkloadDNSIssues stores the risky DNS configurations of a workload
+type WorkloadDNSIssues struct {
+	Workload  string   `json:"workload"`
+	DNSPolicy string   `json:"dnsPolicy"`
+	Pods      int      `json:"pods"`
+	Issues    []string `json:"issues"`
+	Details   []string `json:"details,omitempty"`
+}
+
+//DNSPolicyAudit stores the result of the cluster-wide audit of dnsPolicy and dnsConfig, grouped by namespace
+type DNSPolicyAudit struct {
+	PodsScanned int                            `json:"podsScanned"`
+	Namespaces  map[string][]WorkloadDNSIssues `json:"namespaces,omitempty"`
+}
+
+//podOwner returns the workload owning the pod, e.g. Deployment/web, DaemonSet/agent or Pod/standalone
+func podOwner(pod *v1.Pod) string {
+	owner := metav1.GetControllerOf(pod)
+	if owner == nil {
+		return "Pod/" + pod.Name
+	}
+	//pods of a Deployment are owned by a ReplicaSet named <deployment>-<pod-template-hash>
+	if owner.Kind == "ReplicaSet" {
+		if hash, ok := pod.Labels[labelPodTemplateHash]; ok && strings.HasSuffix(owner.Name, "-"+hash) {
+			return "Deployment/" + strings.TrimSuffix(owner.Name, "-"+hash)
+		}
+	}
+	return owner.Kind + "/" + owner.Name
+}
+
+//auditPod returns the risky DNS configurations of a pod along with details
+func auditPod(pod *v1.Pod, clusterIP string) ([]string, []string) {
+	issues, details := make([]string, 0), make([]string, 0)
+	spec := pod.Spec
+
+	//dnsPolicy Default on hostNetwork pods deliberately uses the DNS configuration of the node
+	if spec.HostNetwork && (spec.DNSPolicy == v1.DNSClusterFirst || spec.DNSPolicy == "") {
+		issues = append(issues, auditHostNetworkPolicy)
+	}
+	if !spec.HostNetwork && spec.DNSPolicy == v1.DNSDefault {
+		issues = append(issues, auditDefaultPolicy)
+	}
+	if spec.DNSConfig != nil {
+		for _, ns := range spec.DNSConfig.Nameservers {
+			if ns != clusterIP && ns != nodeLocalDNSIP {
+				issues = append(issues, auditCustomNameservers)
+				details = append(details, fmt.Sprintf("nameservers=%v", spec.DNSConfig.Nameservers))
+				break
+			}
+		}
+		for _, opt := range spec.DNSConfig.Options {
+			if opt.Name != "ndots" || opt.Value == nil {
+				continue
+			}
+			if ndots, err := strconv.Atoi(*opt.Value); err == nil && ndots > maxNdots {
+				issues = append(issues, auditHighNdots)
+				details = append(details, fmt.Sprintf("ndots=%d", ndots))
+			}
+		}
+	}
+	return issues, details
+}
+
+//auditDNSPolicies lists pods across all namespaces and reports risky dnsPolicy/dnsConfig settings grouped by namespace and owner
+func auditDNSPolicies(clusterIP string) (*DNSPolicyAudit, error) {
+	audit := &DNSPolicyAudit{Namespaces: make(map[string][]WorkloadDNSIssues)}
+	workloads := make(map[string]map[string]*WorkloadDNSIssues)
+
+	opts := metav1.ListOptions{Limit: auditPageSize}
+	for {
+		podList, err := Clientset.CoreV1().Pods(metav1.NamespaceAll).List(opts)
+		if err != nil {
+			log.Errorf("Failed to list pods: %v", err)
+			return nil, fmt.Errorf("Failed to list pods: %v", err)
+		}
+
+		for i := range podList.Items {
+			pod := &podList.Items[i]
+			if auditSkippedNamespaces[pod.Namespace] || pod.Status.Phase == v1.PodSucceeded || pod.Status.Phase == v1.PodFailed {
+				continue
+			}
+			audit.PodsScanned++
+
+			issues, details := auditPod(pod, clusterIP)
+			if len(issues) == 0 {
+				continue
+			}
+
+			owner := podOwner(pod)
+			if workloads[pod.Namespace] == nil {
+				workloads[pod.Namespace] = make(map[string]*WorkloadDNSIssues)
+			}
+			w, ok := workloads[pod.Namespace][owner]
+			if !ok {
+				w = &WorkloadDNSIssues{Workload: owner, DNSPolicy: string(pod.Spec.DNSPolicy)}
+				workloads[pod.Namespace][owner] = w
+			}
+			w.Pods++
+			w.Issues = appendUnique(w.Issues, issues...)
+			w.Details = appendUnique(w.Details, details...)
+		}
+		if podList.Continue == "" {
+			break
+		}
+		opts.Continue = podList.Continue
+	}
+
+	for ns, byOwner := range workloads {
+		list := make([]WorkloadDNSIssues, 0, len(byOwner))
+		for _, w := range byOwner {
+			list = append(list, *w)
+		}
+		sort.Slice(list, func(i, j int) bool { return list[i].Workload < list[j].Workload })
+		audit.Namespaces[ns] = list
+	}
+
+	log.Infof("dnsPolicy audit scanned %d pods, %d namespaces with risky DNS configuration", audit.PodsScanned, len(audit.Namespaces))
+	return audit, nil
+}
+
+//dnsPolicyAuditFindings creates one finding per risky configuration listing the affected workloads
+func dnsPolicyAuditFindings(audit *DNSPolicyAudit) []findings.Finding {
+	res := make([]findings.Finding, 0)
+	if audit == nil {
+		return res
+	}
+
+	affected := make(map[string][]string)
+	namespaces := make([]string, 0, len(audit.Namespaces))
+	for ns := range audit.Namespaces {
+		namespaces = append(namespaces, ns)
+	}
+	sort.Strings(namespaces)
+	for _, ns := range namespaces {
+		for _, w := range audit.Namespaces[ns] {
+			for _, issue := range w.Issues {
+				affected[issue] = append(affected[issue], fmt.Sprintf("%s/%s (%d pods, dnsPolicy=%s)", ns, w.Workload, w.Pods, w.DNSPolicy))
+			}
+		}
+	}
+
+	for _, id := range []string{auditHostNetworkPolicy, auditDefaultPolicy, auditCustomNameservers, auditHighNdots} {
+		workloads := affected[id]
+		if len(workloads) == 0 {
+			continue
+		}
+		if len(workloads) > maxAuditEvidence {
+			//copy the first workloads so that the backing array of affected[id] is not overwritten
+			truncated := make([]string, maxAuditEvidence, maxAuditEvidence+1)
+			copy(truncated, workloads)
+			workloads = append(truncated, fmt.Sprintf("... and %d more", len(affected[id])-maxAuditEvidence))
+		}
+		rule := auditRules[id]
+		res = append(res, findings.New(id, rule.severity, "dnsPolicyAudit",
+			fmt.Sprintf("%s (%d workloads)", rule.explanation, len(affected[id])), rule.remediation, workloads...))
+	}
+	return res
+}
@@ -0,0 +1,53 @@
+package main
+
+import (
+	"fmt"
+	"testing"
+
+	v1 "k8s.io/api/core/v1"
+)
+
+func TestAuditPodHostNetwork(t *testing.T) {
+	tests := []struct {
+		policy      v1.DNSPolicy
+		hostNetwork bool
+		want        bool
+	}{
+		{v1.DNSClusterFirst, true, true},
+		{"", true, true},
+		{v1.DNSClusterFirstWithHostNet, true, false},
+		{v1.DNSDefault, true, false},
+		{v1.DNSNone, true, false},
+		{v1.DNSClusterFirst, false, false},
+	}
+	for _, tt := range tests {
+		pod := &v1.Pod{Spec: v1.PodSpec{DNSPolicy: tt.policy, HostNetwork: tt.hostNetwork}}
+		issues, _ := auditPod(pod, "10.100.0.10")
+		if got := containsString(issues, auditHostNetworkPolicy); got != tt.want {
+			t.Errorf("dnsPolicy=%q hostNetwork=%t: %s reported=%t, want %t", tt.policy, tt.hostNetwork, auditHostNetworkPolicy, got, tt.want)
+		}
+	}
+}
+
+func TestDNSPolicyAuditFindingsTruncatesEvidence(t *testing.T) {
+	workloads := make([]WorkloadDNSIssues, 0)
+	for i := 0; i < maxAuditEvidence+5; i++ {
+		workloads = append(workloads, WorkloadDNSIssues{Workload: fmt.Sprintf("Deployment/web-%02d", i), DNSPolicy: "ClusterFirst", Pods: 1, Issues: []string{auditHighNdots}})
+	}
+	audit := &DNSPolicyAudit{Namespaces: map[string][]WorkloadDNSIssues{"app": workloads}}
+
+	res := dnsPolicyAuditFindings(audit)
+	if len(res) != 1 {
+		t.Fatalf("got %d findings, want 1", len(res))
+	}
+	evidence := res[0].Evidence
+	if len(evidence) != maxAuditEvidence+1 {
+		t.Fatalf("got %d evidence lines, want %d", len(evidence), maxAuditEvidence+1)
+	}
+	if want := "app/Deployment/web-19 (1 pods, dnsPolicy=ClusterFirst)"; evidence[maxAuditEvidence-1] != want {
+		t.Errorf("last workload = %q, want %q", evidence[maxAuditEvidence-1], want)
+	}
+	if want := "... and 5 more"; evidence[maxAuditEvidence] != want {
+		t.Errorf("summary line = %q, want %q", evidence[maxAuditEvidence], want)
+	}
+}
@@ -0,0 +1,151 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/joshisumit/eks-dns-troubleshooter/pkg/findings"
+)
+
+const (
+	serverTypeClusterIP  = "clusterIP"
+	serverTypeEndpoint   = "endpoint"
+	serverTypeNameserver = "nameserver"
+
+	layerNone             = "none"
+	layerAll              = "coredns-unreachable"
+	layerTransportTCP     = "tcp-transport"
+	layerService          = "service-kube-proxy"
+	layerReplica          = "coredns-replica"
+	layerUpstream         = "upstream-forwarding"
+	layerKubernetesPlugin = "kubernetes-plugin"
+	layerInconclusive     = "inconclusive"
+)
+
+//DNSDiagnosis is the verdict inferred from the server x domain x transport result matrix
+type DNSDiagnosis struct {
+	Layer           string   `json:"layer"`
+	Verdict         string   `json:"verdict"`
+	FailingServers  []string `json:"failingServers,omitempty"`
+	FailingDomains  []string `json:"failingDomains,omitempty"`
+	FailingReplicas []string `json:"failingReplicas,omitempty"`
+}
+
+//isClusterName returns true if the name belongs to the cluster domain (served by the kubernetes plugin)
+func isClusterName(name, clusterDomain string) bool {
+	name = strings.TrimSuffix(name, ".")
+	return name == clusterDomain || strings.HasSuffix(name, "."+clusterDomain)
+}
+
+//allFailed returns true if every result accepted by the filter failed (and at least one result was accepted)
+func allFailed(results []DnsTestResultForDomain, filter func(DnsTestResultForDomain) bool) bool {
+	n := 0
+	for _, r := range results {
+		if !filter(r) {
+			continue
+		}
+		n++
+		if r.Result == "success" {
+			return false
+		}
+	}
+	return n > 0
+}
+
+//noneFailed returns true if every result accepted by the filter succeeded
+func noneFailed(results []DnsTestResultForDomain, filter func(DnsTestResultForDomain) bool) bool {
+	for _, r := range results {
+		if filter(r) && r.Result != "success" {
+			return false
+		}
+	}
+	return true
+}
+
+//diagnoseDNSResults infers which layer is at fault from the results of the DNS tests
+func diagnoseDNSResults(results []DnsTestResultForDomain, clusterDomain string) *DNSDiagnosis {
+	diag := &DNSDiagnosis{}
+
+	servers, domains := make(map[string]bool), make(map[string]bool)
+	for _, r := range results {
+		if r.Result != "success" {
+			servers[fmt.Sprintf("%s (%s/%s)", r.Server, r.ServerType, r.Transport)] = true
+			domains[r.DomainName] = true
+		}
+	}
+	diag.FailingServers, diag.FailingDomains = sortedKeys(servers), sortedKeys(domains)
+
+	everyResult := func(DnsTestResultForDomain) bool { return true }
+	isTCP := func(r DnsTestResultForDomain) bool { return r.Transport == transportTCP }
+	isUDP := func(r DnsTestResultForDomain) bool { return r.Transport == transportUDP }
+	isClusterIP := func(r DnsTestResultForDomain) bool { return r.ServerType == serverTypeClusterIP }
+	isEndpoint := func(r DnsTestResultForDomain) bool { return r.ServerType == serverTypeEndpoint }
+	isCluster := func(r DnsTestResultForDomain) bool { return isClusterName(r.DomainName, clusterDomain) }
+	isExternal := func(r DnsTestResultForDomain) bool { return !isClusterName(r.DomainName, clusterDomain) }
+
+	//replicas which failed every query while other replicas answered
+	replicaOK := make(map[string]bool)
+	for _, r := range results {
+		if isEndpoint(r) {
+			replicaOK[r.Server] = replicaOK[r.Server] || r.Result == "success"
+		}
+	}
+	for server, ok := range replicaOK {
+		if !ok {
+			diag.FailingReplicas = append(diag.FailingReplicas, server)
+		}
+	}
+	sort.Strings(diag.FailingReplicas)
+
+	switch {
+	case len(results) == 0:
+		diag.Layer, diag.Verdict = layerInconclusive, "No DNS tests were executed"
+	case noneFailed(results, everyResult):
+		diag.Layer, diag.Verdict = layerNone, "All DNS queries succeeded against the ClusterIP and every CoreDNS replica over UDP and TCP"
+	case allFailed(results, everyResult):
+		diag.Layer = layerAll
+		diag.Verdict = "Every query failed, against the ClusterIP and every CoreDNS replica. CoreDNS is either down or unreachable from this node (check Security Groups, NACLs and the CoreDNS pods)"
+	case allFailed(results, isTCP) && noneFailed(results, isUDP):
+		diag.Layer = layerTransportTCP
+		diag.Verdict = "Queries over UDP succeed but every query over TCP fails. Security Groups or NACLs allow 53/UDP but not 53/TCP, large responses (truncated UDP answers) will fail"
+	case allFailed(results, isClusterIP) && noneFailed(results, isEndpoint):
+		diag.Layer = layerService
+		diag.Verdict = "Queries to the kube-dns ClusterIP fail while queries sent directly to every CoreDNS endpoint succeed. The service path is broken: kube-proxy rules on this node are missing or stale"
+	case len(diag.FailingReplicas) > 0 && len(diag.FailingReplicas) < len(replicaOK):
+		diag.Layer = layerReplica
+		diag.Verdict = fmt.Sprintf("CoreDNS replica(s) %s fail every query while other replicas answer. Queries through the ClusterIP fail intermittently depending on the selected replica (check the pod, its node and the Security Groups/NACLs of that node)", strings.Join(diag.FailingReplicas, ", "))
+	case allFailed(results, isExternal) && noneFailed(results, isCluster):
+		diag.Layer = layerUpstream
+		diag.Verdict = "Only external names fail while cluster names resolve. CoreDNS is reachable but forwarding to the upstream resolver fails (check the forward plugin, VPC DNS settings and the route/SG/NACL path to the VPC resolver)"
+	case allFailed(results, isCluster) && noneFailed(results, isExternal):
+		diag.Layer = layerKubernetesPlugin
+		diag.Verdict = "Only cluster names fail while external names resolve. The kubernetes plugin of CoreDNS is failing (check the Corefile, CoreDNS RBAC and connectivity from CoreDNS to the API server)"
+	default:
+		diag.Layer = layerInconclusive
+		diag.Verdict = "Some queries failed without a clear pattern, this usually points to intermittent problems (packet drops, throttling by the VPC resolver, overloaded CoreDNS)"
+	}
+	return diag
+}
+
+//dnsDiagnosisFindings converts the verdict of the DNS tests into a finding
+func dnsDiagnosisFindings(diag *DNSDiagnosis) []findings.Finding {
+	res := make([]findings.Finding, 0)
+	if diag == nil || diag.Layer == layerNone {
+		return res
+	}
+
+	severity := findings.SeverityCritical
+	if diag.Layer == layerInconclusive || diag.Layer == layerTransportTCP {
+		severity = findings.SeverityWarning
+	}
+	evidence := make([]string, 0)
+	for _, s := range diag.FailingServers {
+		evidence = append(evidence, "failing server: "+s)
+	}
+	for _, d := range diag.FailingDomains {
+		evidence = append(evidence, "failing domain: "+d)
+	}
+	res = append(res, findings.New("DNS-FAILING-LAYER-"+strings.ToUpper(diag.Layer), severity, "dnsTest", diag.Verdict, "", evidence...))
+	return res
+}
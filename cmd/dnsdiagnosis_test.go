@@ -0,0 +1,61 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+//dnsTestMatrix builds the results of the ClusterIP and two CoreDNS replicas for an external and a cluster name over UDP and TCP
+func dnsTestMatrix(failed func(r DnsTestResultForDomain) bool) []DnsTestResultForDomain {
+	servers := []struct{ ip, serverType string }{
+		{"10.100.0.10", serverTypeClusterIP},
+		{"10.0.1.10", serverTypeEndpoint},
+		{"10.0.2.20", serverTypeEndpoint},
+	}
+	res := make([]DnsTestResultForDomain, 0)
+	for _, s := range servers {
+		for _, domain := range []string{"amazon.com", "kubernetes.default.svc.cluster.local"} {
+			for _, transport := range []string{transportUDP, transportTCP} {
+				r := DnsTestResultForDomain{DomainName: domain, Server: s.ip, ServerType: s.serverType, Transport: transport, Result: "success"}
+				if failed(r) {
+					r.Result, r.Error = "failed", "i/o timeout"
+				}
+				res = append(res, r)
+			}
+		}
+	}
+	return res
+}
+
+func TestDiagnoseDNSResults(t *testing.T) {
+	tests := []struct {
+		name         string
+		failed       func(r DnsTestResultForDomain) bool
+		wantLayer    string
+		wantReplicas []string
+	}{
+		{"all succeeded", func(r DnsTestResultForDomain) bool { return false }, layerNone, nil},
+		{"all failed", func(r DnsTestResultForDomain) bool { return true }, layerAll, []string{"10.0.1.10", "10.0.2.20"}},
+		{"tcp only", func(r DnsTestResultForDomain) bool { return r.Transport == transportTCP }, layerTransportTCP, nil},
+		{"clusterIP only", func(r DnsTestResultForDomain) bool { return r.ServerType == serverTypeClusterIP }, layerService, nil},
+		{"single replica", func(r DnsTestResultForDomain) bool { return r.Server == "10.0.2.20" }, layerReplica, []string{"10.0.2.20"}},
+		{"external only", func(r DnsTestResultForDomain) bool { return r.DomainName == "amazon.com" }, layerUpstream, nil},
+		{"cluster names only", func(r DnsTestResultForDomain) bool { return r.DomainName != "amazon.com" }, layerKubernetesPlugin, nil},
+		{"inconclusive", func(r DnsTestResultForDomain) bool {
+			return r.Server == "10.0.1.10" && r.DomainName == "amazon.com" && r.Transport == transportUDP
+		}, layerInconclusive, nil},
+	}
+	for _, tt := range tests {
+		diag := diagnoseDNSResults(dnsTestMatrix(tt.failed), "cluster.local")
+		if diag.Layer != tt.wantLayer {
+			t.Errorf("%s: layer = %q, want %q (verdict %q)", tt.name, diag.Layer, tt.wantLayer, diag.Verdict)
+		}
+		if !reflect.DeepEqual(diag.FailingReplicas, tt.wantReplicas) {
+			t.Errorf("%s: failing replicas = %v, want %v", tt.name, diag.FailingReplicas, tt.wantReplicas)
+		}
+	}
+
+	if diag := diagnoseDNSResults(nil, "cluster.local"); diag.Layer != layerInconclusive {
+		t.Errorf("no results: layer = %q, want %q", diag.Layer, layerInconclusive)
+	}
+}
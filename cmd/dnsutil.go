@@ -1,35 +1,49 @@
 package main
 
 import (
-	log "github.com/sirupsen/logrus"
+	"fmt"
 	"net"
+	"time"
 
-	"github.com/bogdanovich/dns_resolver"
+	"github.com/miekg/dns"
+	log "github.com/sirupsen/logrus"
 )
 
 //Coredns struct sets all the properties of coredns
 type Coredns struct {
-	ClusterIP         string     `json:"clusterIP"`
-	EndpointsIP       []string   `json:"endpointsIP"`
-	NotReadyEndpoints []string   `json:"notReadyEndpoints"`
-	Namespace         string     `json:"namespace"`
-	ImageVersion      string     `json:"imageVersion"`
-	RecommVersion     string     `json:"recommendedVersion"`
-	Dnstest           Dnstest    `json:"dnstestResults"`
-	Metrics           []string   `json:"metrics,omitempty"`
-	Replicas          int32      `json:"replicas"`
-	PodNamesList      []string   `json:"podNames"`
-	Corefile          string     `json:"corefile"`
-	ResolvConf        ResolvConf `json:"resolvconf"`
-	HasNodeLocalCache bool       `json:"isNodeLocalCacheEnabled,omitempty"`
+	ClusterIP         string              `json:"clusterIP"`
+	EndpointsIP       []string            `json:"endpointsIP"`
+	NotReadyEndpoints []string            `json:"notReadyEndpoints"`
+	Namespace         string              `json:"namespace"`
+	ImageVersion      string              `json:"imageVersion"`
+	RecommVersion     string              `json:"recommendedVersion"`
+	Dnstest           Dnstest             `json:"dnstestResults"`
+	Metrics           []CorednsPodMetrics `json:"metrics,omitempty"`
+	Replicas          int32               `json:"replicas"`
+	PodNamesList      []string            `json:"podNames"`
+	Corefile          string              `json:"corefile"`
+	ResolvConf        ResolvConf          `json:"resolvconf"`
+	HasNodeLocalCache bool                `json:"isNodeLocalCacheEnabled,omitempty"`
 	//nodeLocalCacheIP  string -> should be set manually to 169.254.20.10
 	ErrorsInCorednsLogs map[string]interface{} `json:"errorCheckInCorednsLogs,omitempty"`
+	LogSignatureMatches []LogSignatureMatch    `json:"logSignatureMatches,omitempty"`
+	DeploymentHealth    *DeploymentHealth      `json:"deploymentHealth,omitempty"`
+	Topology            *TopologyCheck         `json:"topology,omitempty"`
+	ServiceEndpoints    *ServiceEndpointsCheck `json:"serviceEndpoints,omitempty"`
+	NodeLocalDNSCache   *NodeLocalDNSCheck     `json:"nodeLocalDNSCache,omitempty"`
+	LinkLocal           *LinkLocalCheck        `json:"linkLocalAllowance,omitempty"`
+
+	//scrapes of the coredns metrics endpoint of every replica
+	scrapes []podScrape
 }
 
 type DnsTestResultForDomain struct {
 	DomainName string   `json:"domain"`
 	Server     string   `json:"server"`
+	ServerType string   `json:"serverType,omitempty"`
+	Transport  string   `json:"transport,omitempty"`
 	Result     string   `json:"result"`
+	Error      string   `json:"error,omitempty"`
 	Answer     []string `json:"answer,omitempty"`
 }
 
@@ -38,51 +52,72 @@ type Dnstest struct {
 	Description             string                   `json:"description,omitempty"`
 	DomainsTested           []string                 `json:"domainsTested,omitempty"`
 	DnsTestResultForDomains []DnsTestResultForDomain `json:"detailedResultForEachDomain,omitempty"`
+	Diagnosis               *DNSDiagnosis            `json:"diagnosis,omitempty"`
 }
 
-func lookupIP(host string, server []string) *DnsTestResultForDomain {
+const (
+	transportUDP = "udp"
+	transportTCP = "tcp"
+	queryTimeout = 2 * time.Second
+)
+
+//lookupIP sends A queries for host to a single server over the given transport (udp or tcp)
+func lookupIP(host string, server string, transport string) *DnsTestResultForDomain {
 	var (
-		result string
-		s, f   int
-		ip     []net.IP
+		result  string
+		s, f    int
+		lastErr error
 	)
 
-	srv := server // creating a local copy
+	//server may already carry a port (e.g. forward targets like 10.0.0.10:5353)
+	srv := server
+	if _, _, err := net.SplitHostPort(server); err != nil {
+		srv = net.JoinHostPort(server, "53")
+	}
 	testres := DnsTestResultForDomain{}
 
-	resolver := dns_resolver.New(srv)
-
-	// In case of io timeout, retry 3 times
-	resolver.RetryTimes = 3
+	//As per miekg/dns library, domain names MUST be fully qualified before sending them
+	msg := new(dns.Msg)
+	msg.SetQuestion(dns.Fqdn(host), dns.TypeA)
+	client := &dns.Client{Net: transport, Timeout: queryTimeout}
 
 	//Perform each DNS query for 3 times
 	answer := make([]string, 0)
 	for i := 1; i <= 3; i++ {
-		log.Infof("DNS query: %s Servers: %v", host, srv)
-		ip, err := resolver.LookupHost(host)
+		log.Infof("DNS query: %s Server: %v Transport: %s", host, srv, transport)
+		in, _, err := client.Exchange(msg, srv)
+		if err == nil && in.Rcode != dns.RcodeSuccess {
+			err = fmt.Errorf("%s", dns.RcodeToString[in.Rcode])
+		}
 		if err != nil {
-			log.Errorf("Failed to resolve DNS query: %v %v ==> %s", host, ip, err.Error())
+			log.Errorf("Failed to resolve DNS query: %v ==> %s", host, err.Error())
+			lastErr = err
 			f++
 			continue
 		}
 		s++
-	}
 
-	for _, ipaddr := range ip {
-		answer = append(answer, ipaddr.String())
+		answer = answer[:0]
+		for _, rr := range in.Answer {
+			if a, ok := rr.(*dns.A); ok {
+				answer = append(answer, a.A.String())
+			}
+		}
 	}
-	log.Infof("Answer: %s A %s %v", host, ip, answer)
+	log.Infof("Answer: %s A %v", host, answer)
 
-	log.Debugf("success: %d fail: %d domain: %s Servers: %s", s, f, host, srv)
+	log.Debugf("success: %d fail: %d domain: %s Server: %s", s, f, host, srv)
 	if f > 0 {
 		log.Errorf("DNS query failed %d times", f)
 		result = "failed"
+		testres.Error = lastErr.Error()
 	} else {
 		log.Infof("DNS queries succeeded %d times", s)
 		result = "success"
 	}
 
-	testres.DomainName, testres.Server, testres.Result, testres.Answer = host, srv[0], result, answer
+	//the report keeps the server as given (a bare IP for coredns), the port is only needed to send the query
+	testres.DomainName, testres.Server, testres.Transport, testres.Result, testres.Answer = host, server, transport, result, answer
 
 	return &testres
 }
@@ -0,0 +1,276 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/joshisumit/eks-dns-troubleshooter/pkg/findings"
+	log "github.com/sirupsen/logrus"
+	v1 "k8s.io/api/core/v1"
+	discoveryv1beta1 "k8s.io/api/discovery/v1beta1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+)
+
+const (
+	kubeDNSService     = "kube-dns"
+	labelServiceName   = "kubernetes.io/service-name"
+	endpointSliceGroup = "discovery.k8s.io"
+)
+
+//endpointSliceVersions are tried in order, v1beta1 was removed in Kubernetes 1.25
+var endpointSliceVersions = []string{"v1", "v1beta1"}
+
+//kubeDNSMetricsPort is only used to scrape coredns metrics through the service, DNS works without it
+const kubeDNSMetricsPort = "9153/TCP"
+
+//expectedKubeDNSPorts are the ports which the kube-dns service must expose
+var expectedKubeDNSPorts = []string{"53/UDP", "53/TCP", kubeDNSMetricsPort}
+
+//endpointSliceList is the part of an EndpointSliceList (discovery.k8s.io v1 or v1beta1) used by the tool
+type endpointSliceList struct {
+	Items []struct {
+		Endpoints []struct {
+			discoveryv1beta1.Endpoint
+			NodeName *string `json:"nodeName,omitempty"`
+		} `json:"endpoints"`
+		Ports []discoveryv1beta1.EndpointPort `json:"ports"`
+	} `json:"items"`
+}
+
+//EndpointAddress stores a coredns endpoint and the ports it serves
+type EndpointAddress struct {
+	IP    string   `json:"ip"`
+	Pod   string   `json:"pod,omitempty"`
+	Node  string   `json:"node,omitempty"`
+	Ready bool     `json:"ready"`
+	Ports []string `json:"ports"`
+}
+
+//ServiceEndpointsCheck stores endpoints of the kube-dns service and the validation of its ports and selector
+type ServiceEndpointsCheck struct {
+	Source            string            `json:"source"`
+	Addresses         []EndpointAddress `json:"addresses"`
+	ServicePorts      []string          `json:"servicePorts"`
+	MissingPorts      []string          `json:"missingPorts,omitempty"`
+	Selector          map[string]string `json:"selector"`
+	SelectorMatches   bool              `json:"selectorMatchesCorednsPods"`
+	SelectedPodsCount int               `json:"selectedPodsCount"`
+}
+
+//hasServerResource returns true if the API server serves the resource in the given group version
+func hasServerResource(groupVersion, resource string) bool {
+	resources, err := Clientset.Discovery().ServerResourcesForGroupVersion(groupVersion)
+	if err != nil {
+		return false
+	}
+	for _, r := range resources.APIResources {
+		if r.Name == resource {
+			return true
+		}
+	}
+	return false
+}
+
+//getEndpointSlices returns endpoints of the kube-dns service from EndpointSlices, the API version used is returned as well
+func getEndpointSlices(ns string) ([]EndpointAddress, string, error) {
+	for _, version := range endpointSliceVersions {
+		groupVersion := endpointSliceGroup + "/" + version
+		if !hasServerResource(groupVersion, "endpointslices") {
+			continue
+		}
+
+		raw, err := Clientset.CoreV1().RESTClient().Get().
+			AbsPath("/apis", endpointSliceGroup, version, "namespaces", ns, "endpointslices").
+			Param("labelSelector", labelServiceName+"="+kubeDNSService).
+			DoRaw()
+		if err != nil {
+			return nil, groupVersion, fmt.Errorf("Failed to list EndpointSlices of %s service: %v", kubeDNSService, err)
+		}
+		slices := endpointSliceList{}
+		if err := json.Unmarshal(raw, &slices); err != nil {
+			return nil, groupVersion, fmt.Errorf("Failed to decode EndpointSlices: %v", err)
+		}
+
+		addrs := make([]EndpointAddress, 0)
+		for _, slice := range slices.Items {
+			ports := make([]string, 0, len(slice.Ports))
+			for _, p := range slice.Ports {
+				if p.Port != nil && p.Protocol != nil {
+					ports = append(ports, fmt.Sprintf("%d/%s", *p.Port, *p.Protocol))
+				}
+			}
+			for _, ep := range slice.Endpoints {
+				//endpoints are ready unless the condition says otherwise
+				ready := ep.Conditions.Ready == nil || *ep.Conditions.Ready
+				node := ep.Topology["kubernetes.io/hostname"]
+				if ep.NodeName != nil {
+					node = *ep.NodeName
+				}
+				pod := ""
+				if ep.TargetRef != nil {
+					pod = ep.TargetRef.Name
+				}
+				for _, ip := range ep.Addresses {
+					addrs = append(addrs, EndpointAddress{IP: ip, Pod: pod, Node: node, Ready: ready, Ports: ports})
+				}
+			}
+		}
+		return addrs, "EndpointSlice " + groupVersion, nil
+	}
+	return nil, "", fmt.Errorf("EndpointSlice API is not available")
+}
+
+//getEndpoints returns endpoints of the kube-dns service from every subset of the Endpoints object
+func getEndpoints(ns string) ([]EndpointAddress, error) {
+	endpoints, err := Clientset.CoreV1().Endpoints(ns).Get(kubeDNSService, metav1.GetOptions{})
+	if err != nil {
+		return nil, err
+	}
+
+	addrs := make([]EndpointAddress, 0)
+	for _, subset := range endpoints.Subsets {
+		ports := make([]string, 0, len(subset.Ports))
+		for _, p := range subset.Ports {
+			ports = append(ports, fmt.Sprintf("%d/%s", p.Port, p.Protocol))
+		}
+		add := func(list []v1.EndpointAddress, ready bool) {
+			for _, addr := range list {
+				ea := EndpointAddress{IP: addr.IP, Ready: ready, Ports: ports}
+				if addr.NodeName != nil {
+					ea.Node = *addr.NodeName
+				}
+				if addr.TargetRef != nil {
+					ea.Pod = addr.TargetRef.Name
+				}
+				addrs = append(addrs, ea)
+			}
+		}
+		add(subset.Addresses, true)
+		add(subset.NotReadyAddresses, false)
+	}
+	return addrs, nil
+}
+
+//checkServiceEndpoints returns the endpoints of kube-dns, preferring EndpointSlices, and validates ports and selector of the service
+func checkServiceEndpoints(ns string) (*ServiceEndpointsCheck, error) {
+	check := &ServiceEndpointsCheck{}
+
+	addrs, source, err := getEndpointSlices(ns)
+	if err != nil {
+		log.Infof("Falling back to Endpoints API: %v", err)
+		addrs, err = getEndpoints(ns)
+		if err != nil {
+			log.Errorf("kube-dns endpoints does not exist %s", err)
+			return nil, err
+		}
+		source = "Endpoints v1"
+	}
+	check.Source, check.Addresses = source, addrs
+	log.Infof("kube-dns endpoints from %s: %+v", source, addrs)
+
+	svc, err := Clientset.CoreV1().Services(ns).Get(kubeDNSService, metav1.GetOptions{})
+	if err != nil {
+		log.Errorf("kube-dns service does not exist %s", err)
+		return check, err
+	}
+
+	//1. ports of the service
+	check.ServicePorts = make([]string, 0, len(svc.Spec.Ports))
+	exposed := make(map[string]bool)
+	for _, p := range svc.Spec.Ports {
+		port := fmt.Sprintf("%d/%s", p.Port, p.Protocol)
+		check.ServicePorts = append(check.ServicePorts, port)
+		exposed[port] = true
+	}
+	for _, port := range expectedKubeDNSPorts {
+		if !exposed[port] {
+			check.MissingPorts = append(check.MissingPorts, port)
+		}
+	}
+
+	//2. selector of the service must match the labels of the coredns pods
+	check.Selector = svc.Spec.Selector
+	dep, err := Clientset.AppsV1().Deployments(ns).Get("coredns", metav1.GetOptions{})
+	if err != nil {
+		log.Warnf("Unable to compare kube-dns selector with coredns pod labels: %v", err)
+	} else if len(svc.Spec.Selector) != 0 {
+		selector := labels.SelectorFromSet(svc.Spec.Selector)
+		check.SelectorMatches = selector.Matches(labels.Set(dep.Spec.Template.Labels))
+
+		pods, err := Clientset.CoreV1().Pods(ns).List(metav1.ListOptions{LabelSelector: selector.String()})
+		if err != nil {
+			log.Warnf("Failed to list pods selected by kube-dns service: %v", err)
+		} else {
+			check.SelectedPodsCount = len(pods.Items)
+		}
+	}
+
+	log.Infof("kube-dns service checks: %+v", check)
+	return check, nil
+}
+
+//endpointIPs returns IPs of ready and not ready endpoints
+func (check *ServiceEndpointsCheck) endpointIPs() ([]string, []string) {
+	eips := make([]string, 0)
+	notReadyEIP := make([]string, 0)
+	for _, addr := range check.Addresses {
+		if addr.Ready {
+			eips = append(eips, addr.IP)
+		} else {
+			notReadyEIP = append(notReadyEIP, addr.IP)
+		}
+	}
+	return eips, notReadyEIP
+}
+
+//serviceEndpointsFindings reports problems of the kube-dns service and its endpoints
+func serviceEndpointsFindings(check *ServiceEndpointsCheck) []findings.Finding {
+	res := make([]findings.Finding, 0)
+	if check == nil {
+		return res
+	}
+	const source = "kubeDNSService"
+
+	ready := 0
+	for _, addr := range check.Addresses {
+		if addr.Ready {
+			ready++
+		} else {
+			res = append(res, findings.New("KUBE-DNS-ENDPOINT-NOT-READY", findings.SeverityWarning, source,
+				fmt.Sprintf("CoreDNS endpoint %s (pod %s) is not ready and does not receive traffic from the kube-dns service", addr.IP, addr.Pod),
+				"Check the readiness probe and the logs of the coredns pod.",
+				fmt.Sprintf("ip=%s pod=%s node=%s", addr.IP, addr.Pod, addr.Node)))
+		}
+	}
+	if ready == 0 {
+		res = append(res, findings.New("KUBE-DNS-NO-ENDPOINTS", findings.SeverityCritical, source,
+			"kube-dns service has no ready endpoints, every DNS query sent to the ClusterIP fails",
+			"Make sure coredns pods are running and ready, and that the kube-dns service selector matches their labels.",
+			fmt.Sprintf("source=%s", check.Source)))
+	}
+	missingDNS := make([]string, 0, len(check.MissingPorts))
+	for _, port := range check.MissingPorts {
+		if port == kubeDNSMetricsPort {
+			res = append(res, findings.New("KUBE-DNS-METRICS-PORT", findings.SeverityInfo, source,
+				"kube-dns service does not expose 9153/TCP, DNS is not affected but monitoring which scrapes coredns metrics through the service gets no data",
+				"Add 9153/TCP (metrics) to the kube-dns service if coredns metrics are collected through it.",
+				fmt.Sprintf("servicePorts=%v", check.ServicePorts)))
+			continue
+		}
+		missingDNS = append(missingDNS, port)
+	}
+	if len(missingDNS) != 0 {
+		res = append(res, findings.New("KUBE-DNS-SERVICE-PORTS", findings.SeverityCritical, source,
+			fmt.Sprintf("kube-dns service does not expose %v, DNS over the missing transport fails", missingDNS),
+			"Add 53/UDP (dns) and 53/TCP (dns-tcp) to the kube-dns service.",
+			fmt.Sprintf("servicePorts=%v", check.ServicePorts)))
+	}
+	if len(check.Selector) != 0 && !check.SelectorMatches {
+		res = append(res, findings.New("KUBE-DNS-SELECTOR-MISMATCH", findings.SeverityCritical, source,
+			"The selector of kube-dns service does not match the labels of the coredns pod template, coredns pods will not be added as endpoints",
+			"Fix the selector of kube-dns service (default k8s-app=kube-dns) or the labels of the coredns deployment.",
+			fmt.Sprintf("selector=%v selectedPods=%d", check.Selector, check.SelectedPodsCount)))
+	}
+	return res
+}
@@ -0,0 +1,37 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/joshisumit/eks-dns-troubleshooter/pkg/findings"
+)
+
+func TestServiceEndpointsFindingsMissingPorts(t *testing.T) {
+	tests := []struct {
+		missing []string
+		want    map[string]findings.Severity
+	}{
+		{[]string{"9153/TCP"}, map[string]findings.Severity{"KUBE-DNS-METRICS-PORT": findings.SeverityInfo}},
+		{[]string{"53/TCP"}, map[string]findings.Severity{"KUBE-DNS-SERVICE-PORTS": findings.SeverityCritical}},
+		{[]string{"53/TCP", "9153/TCP"}, map[string]findings.Severity{"KUBE-DNS-SERVICE-PORTS": findings.SeverityCritical, "KUBE-DNS-METRICS-PORT": findings.SeverityInfo}},
+	}
+	for _, tt := range tests {
+		check := &ServiceEndpointsCheck{
+			Addresses:    []EndpointAddress{{IP: "10.0.1.10", Ready: true}},
+			MissingPorts: tt.missing,
+		}
+		got := make(map[string]findings.Severity)
+		for _, f := range serviceEndpointsFindings(check) {
+			got[f.ID] = f.Severity
+		}
+		if len(got) != len(tt.want) {
+			t.Errorf("missing %v: got findings %v, want %v", tt.missing, got, tt.want)
+			continue
+		}
+		for id, severity := range tt.want {
+			if got[id] != severity {
+				t.Errorf("missing %v: %s severity = %q, want %q", tt.missing, id, got[id], severity)
+			}
+		}
+	}
+}
@@ -0,0 +1,178 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/joshisumit/eks-dns-troubleshooter/pkg/aws"
+	"github.com/joshisumit/eks-dns-troubleshooter/pkg/findings"
+	log "github.com/sirupsen/logrus"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+const (
+	annotationFargateProfile = "eks.amazonaws.com/fargate-profile"
+	annotationComputeType    = "eks.amazonaws.com/compute-type"
+	computeEC2               = "ec2"
+	profileStatusActive      = "ACTIVE"
+)
+
+//FargateCheck stores the Fargate specific checks of the troubleshooter and coredns pods
+type FargateCheck struct {
+	SelfOnFargate         bool     `json:"selfOnFargate"`
+	DetectedBy            string   `json:"detectedBy,omitempty"`
+	SelfFargateProfile    string   `json:"selfFargateProfile,omitempty"`
+	FargateNodes          int      `json:"fargateNodes"`
+	EC2Nodes              int      `json:"ec2Nodes"`
+	CorednsComputeType    string   `json:"corednsComputeTypeAnnotation,omitempty"`
+	CorednsOnFargate      []string `json:"corednsPodsOnFargate,omitempty"`
+	CorednsPending        []string `json:"corednsPodsPending,omitempty"`
+	CorednsProfiles       []string `json:"corednsFargateProfiles,omitempty"`
+	ProfilesEvaluated     bool     `json:"profilesEvaluated"`
+	ClusterName           string   `json:"clusterName,omitempty"`
+	Region                string   `json:"region,omitempty"`
+	corednsNamespace      string
+	corednsTemplateLabels map[string]string
+}
+
+//isFargateNode returns true if the node is a Fargate virtual node
+func isFargateNode(node *v1.Node) bool {
+	return node.Labels[labelComputeType] == computeFargate
+}
+
+//checkFargate detects whether the troubleshooter and coredns pods run on Fargate and
+//checks the coredns deployment for Fargate scheduling problems
+func checkFargate(ns string, self *v1.Pod) (*FargateCheck, error) {
+	fc := &FargateCheck{corednsNamespace: ns}
+
+	nodes, err := Clientset.CoreV1().Nodes().List(metav1.ListOptions{})
+	if err != nil {
+		return fc, fmt.Errorf("Failed to list nodes: %v", err)
+	}
+	fargateNodes := make(map[string]bool)
+	for i := range nodes.Items {
+		if isFargateNode(&nodes.Items[i]) {
+			fargateNodes[nodes.Items[i].Name] = true
+			fc.FargateNodes++
+		} else {
+			fc.EC2Nodes++
+		}
+	}
+
+	//1. Troubleshooter pod: annotation added by the Fargate scheduler or label of the node
+	if self != nil {
+		if profile, ok := self.Annotations[annotationFargateProfile]; ok {
+			fc.SelfOnFargate = true
+			fc.SelfFargateProfile = profile
+			fc.DetectedBy = "pod annotation " + annotationFargateProfile
+		} else if fargateNodes[self.Spec.NodeName] {
+			fc.SelfOnFargate = true
+			fc.DetectedBy = fmt.Sprintf("node label %s=%s", labelComputeType, computeFargate)
+		}
+	}
+
+	//2. coredns deployment and pods
+	dep, err := Clientset.AppsV1().Deployments(ns).Get("coredns", metav1.GetOptions{})
+	if err != nil {
+		return fc, fmt.Errorf("Failed to get coredns deployment: %v", err)
+	}
+	fc.CorednsComputeType = dep.Spec.Template.Annotations[annotationComputeType]
+	fc.corednsTemplateLabels = dep.Spec.Template.Labels
+
+	pods, err := getDeploymentPods(dep)
+	if err != nil {
+		return fc, err
+	}
+	for _, pod := range pods {
+		if _, ok := pod.Annotations[annotationFargateProfile]; ok || fargateNodes[pod.Spec.NodeName] {
+			fc.CorednsOnFargate = append(fc.CorednsOnFargate, pod.Name)
+		}
+		if pod.Status.Phase == v1.PodPending {
+			fc.CorednsPending = append(fc.CorednsPending, pod.Name)
+		}
+	}
+
+	log.Infof("Fargate check: %+v", fc)
+	return fc, nil
+}
+
+//evaluateProfiles records the Fargate profiles whose selectors match the coredns pods
+func (fc *FargateCheck) evaluateProfiles(profiles []aws.FargateProfileInfo) {
+	if fc == nil || profiles == nil {
+		return
+	}
+	fc.ProfilesEvaluated = true
+	for i := range profiles {
+		if profiles[i].Selects(fc.corednsNamespace, fc.corednsTemplateLabels) {
+			fc.CorednsProfiles = append(fc.CorednsProfiles, profiles[i].Name)
+		}
+	}
+}
+
+//fargateFindings reports Fargate scheduling problems of coredns and misconfigured Fargate profiles
+func fargateFindings(fc *FargateCheck, profiles []aws.FargateProfileInfo) []findings.Finding {
+	res := make([]findings.Finding, 0)
+	if fc == nil {
+		return res
+	}
+	const source = "fargate"
+
+	if fc.SelfOnFargate && (fc.ClusterName == "" || fc.Region == "") {
+		res = append(res, findings.New("FARGATE-CLUSTER-CONFIG-MISSING", findings.SeverityWarning, source,
+			"The troubleshooter runs on Fargate where instance metadata is not available and cluster name or region could not be discovered, so AWS side checks cannot run",
+			fmt.Sprintf("Set the %s and %s environment variables (or the -cluster-name and -region flags) in the troubleshooter deployment.", envClusterName, envRegion),
+			fmt.Sprintf("%s=%q", envClusterName, fc.ClusterName), fmt.Sprintf("%s=%q", envRegion, fc.Region)))
+	}
+
+	if fc.CorednsComputeType == computeEC2 && fc.EC2Nodes == 0 {
+		res = append(res, findings.New("FARGATE-COREDNS-COMPUTE-TYPE-EC2", findings.SeverityCritical, source,
+			fmt.Sprintf("The coredns pod template has the annotation %s: %s but the cluster has no EC2 nodes, so coredns pods cannot be scheduled", annotationComputeType, computeEC2),
+			fmt.Sprintf("Remove the annotation: kubectl patch deployment coredns -n %s --type json -p='[{\"op\": \"remove\", \"path\": \"/spec/template/metadata/annotations/eks.amazonaws.com~1compute-type\"}]' and restart the deployment.", fc.corednsNamespace),
+			fc.CorednsPending...))
+	} else if fc.CorednsComputeType == computeEC2 && len(fc.CorednsProfiles) > 0 {
+		res = append(res, findings.New("FARGATE-COREDNS-COMPUTE-TYPE-EC2", findings.SeverityWarning, source,
+			fmt.Sprintf("Fargate profiles %v select coredns but the annotation %s: %s keeps coredns pods on EC2 nodes", fc.CorednsProfiles, annotationComputeType, computeEC2),
+			"Remove the annotation if coredns should run on Fargate, otherwise remove kube-system coredns from the Fargate profile selectors."))
+	}
+
+	if fc.ProfilesEvaluated && fc.EC2Nodes == 0 && len(fc.CorednsProfiles) == 0 {
+		res = append(res, findings.New("FARGATE-COREDNS-NO-PROFILE", findings.SeverityCritical, source,
+			"The cluster has no EC2 nodes and no Fargate profile selects the coredns pods, so coredns cannot be scheduled",
+			fmt.Sprintf("Create a Fargate profile with a selector for namespace %s and labels %v.", fc.corednsNamespace, fc.corednsTemplateLabels),
+			fc.CorednsPending...))
+	}
+
+	selecting := make(map[string]bool)
+	for _, name := range fc.CorednsProfiles {
+		selecting[name] = true
+	}
+	for _, p := range profiles {
+		if selecting[p.Name] && p.Status != profileStatusActive {
+			res = append(res, findings.New("FARGATE-PROFILE-NOT-ACTIVE", findings.SeverityWarning, source,
+				fmt.Sprintf("Fargate profile %s which selects coredns is in status %s", p.Name, p.Status),
+				"Wait for the profile to become ACTIVE or recreate it if it is in a failed state."))
+		}
+		for _, s := range p.Subnets {
+			if s.VpcID == "" {
+				continue
+			}
+			evidence := fmt.Sprintf("profile %s subnet %s (%s, %d free IPs, pod execution role %s)", p.Name, s.SubnetID, s.AvailabilityZone, s.AvailableIPs, p.PodExecutionRoleArn)
+			if !s.InClusterVpc {
+				res = append(res, findings.New("FARGATE-SUBNET-NOT-IN-CLUSTER-VPC", findings.SeverityCritical, source,
+					fmt.Sprintf("Subnet %s of Fargate profile %s is in VPC %s which is not the cluster VPC", s.SubnetID, p.Name, s.VpcID),
+					"Recreate the Fargate profile with private subnets of the cluster VPC.", evidence))
+			}
+			if s.MapPublicIPOnLaunch {
+				res = append(res, findings.New("FARGATE-SUBNET-PUBLIC", findings.SeverityWarning, source,
+					fmt.Sprintf("Subnet %s of Fargate profile %s assigns public IPs on launch, Fargate pods are only supported in private subnets", s.SubnetID, p.Name),
+					"Use private subnets with a NAT gateway or VPC endpoints for the Fargate profile.", evidence))
+			}
+			if s.AvailableIPs == 0 {
+				res = append(res, findings.New("FARGATE-SUBNET-NO-FREE-IPS", findings.SeverityCritical, source,
+					fmt.Sprintf("Subnet %s of Fargate profile %s has no free IP addresses, new Fargate pods (including coredns) cannot start", s.SubnetID, p.Name),
+					"Free up IP addresses or add subnets with free capacity to the Fargate profile.", evidence))
+			}
+		}
+	}
+	return res
+}
@@ -0,0 +1,107 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+//ImageRef stores the parts of a container image reference
+//Example: 602401143452.dkr.ecr.us-west-2.amazonaws.com/eks/coredns:v1.8.7-eksbuild.3
+type ImageRef struct {
+	Registry   string `json:"registry,omitempty"`
+	Repository string `json:"repository"`
+	Tag        string `json:"tag,omitempty"`
+	Digest     string `json:"digest,omitempty"`
+}
+
+//parseImageRef parses an image reference of the form [registry[:port]/]repository[:tag][@digest]
+func parseImageRef(image string) (ImageRef, error) {
+	ref := ImageRef{}
+	if image == "" {
+		return ref, fmt.Errorf("empty image reference")
+	}
+
+	rest := image
+	if i := strings.Index(rest, "@"); i != -1 {
+		rest, ref.Digest = rest[:i], rest[i+1:]
+	}
+
+	//tag separator is the last colon after the last slash, other colons belong to the registry port
+	if i := strings.LastIndex(rest, ":"); i != -1 && i > strings.LastIndex(rest, "/") {
+		rest, ref.Tag = rest[:i], rest[i+1:]
+	}
+
+	//first component is a registry only if it looks like a host (has a dot or a port, or is localhost)
+	if i := strings.Index(rest, "/"); i != -1 {
+		first := rest[:i]
+		if strings.ContainsAny(first, ".:") || first == "localhost" {
+			ref.Registry, rest = first, rest[i+1:]
+		}
+	}
+	ref.Repository = rest
+
+	if ref.Repository == "" {
+		return ref, fmt.Errorf("invalid image reference %q", image)
+	}
+	return ref, nil
+}
+
+//semVersion is a parsed major.minor.patch version, build suffixes like -eksbuild.1 are ignored
+type semVersion struct {
+	Major, Minor, Patch int
+}
+
+//parseVersion parses versions like v1.8.7-eksbuild.3, 1.16, v1.16.8-eks-e16311
+func parseVersion(s string) (semVersion, error) {
+	v := semVersion{}
+	trimmed := strings.TrimPrefix(strings.TrimSpace(s), "v")
+	if i := strings.IndexAny(trimmed, "-+"); i != -1 {
+		trimmed = trimmed[:i]
+	}
+
+	parts := strings.Split(trimmed, ".")
+	if len(parts) < 2 || len(parts) > 3 {
+		return v, fmt.Errorf("invalid version %q", s)
+	}
+	nums := make([]int, 3)
+	for i, p := range parts {
+		n, err := strconv.Atoi(p)
+		if err != nil {
+			return v, fmt.Errorf("invalid version %q", s)
+		}
+		nums[i] = n
+	}
+	v.Major, v.Minor, v.Patch = nums[0], nums[1], nums[2]
+	return v, nil
+}
+
+//compare returns -1, 0 or 1 if v is lower, equal or greater than o
+func (v semVersion) compare(o semVersion) int {
+	switch {
+	case v.Major != o.Major:
+		return compareInt(v.Major, o.Major)
+	case v.Minor != o.Minor:
+		return compareInt(v.Minor, o.Minor)
+	default:
+		return compareInt(v.Patch, o.Patch)
+	}
+}
+
+func compareInt(a, b int) int {
+	if a < b {
+		return -1
+	} else if a > b {
+		return 1
+	}
+	return 0
+}
+
+func (v semVersion) String() string {
+	return fmt.Sprintf("v%d.%d.%d", v.Major, v.Minor, v.Patch)
+}
+
+//minorVersion returns major.minor of a version (e.g. 1.16), used as key of the version matrix
+func (v semVersion) minorVersion() string {
+	return fmt.Sprintf("%d.%d", v.Major, v.Minor)
+}
@@ -0,0 +1,55 @@
+package main
+
+import "testing"
+
+func TestParseImageRef(t *testing.T) {
+	tests := []struct {
+		image string
+		want  ImageRef
+	}{
+		{"602401143452.dkr.ecr.us-west-2.amazonaws.com/eks/coredns:v1.8.7-eksbuild.3",
+			ImageRef{Registry: "602401143452.dkr.ecr.us-west-2.amazonaws.com", Repository: "eks/coredns", Tag: "v1.8.7-eksbuild.3"}},
+		{"coredns/coredns:1.10.1", ImageRef{Repository: "coredns/coredns", Tag: "1.10.1"}},
+		{"localhost:5000/coredns:v1.9.3", ImageRef{Registry: "localhost:5000", Repository: "coredns", Tag: "v1.9.3"}},
+		{"registry.k8s.io/kube-proxy@sha256:abc", ImageRef{Registry: "registry.k8s.io", Repository: "kube-proxy", Digest: "sha256:abc"}},
+		{"busybox", ImageRef{Repository: "busybox"}},
+	}
+	for _, tt := range tests {
+		got, err := parseImageRef(tt.image)
+		if err != nil || got != tt.want {
+			t.Errorf("parseImageRef(%q) = %+v, %v, want %+v", tt.image, got, err, tt.want)
+		}
+	}
+	for _, bad := range []string{"", "registry.example.com/:v1"} {
+		if _, err := parseImageRef(bad); err == nil {
+			t.Errorf("parseImageRef(%q) returned no error", bad)
+		}
+	}
+}
+
+func TestParseVersion(t *testing.T) {
+	tests := []struct {
+		s    string
+		want semVersion
+	}{
+		{"v1.8.7-eksbuild.3", semVersion{1, 8, 7}},
+		{"1.16", semVersion{1, 16, 0}},
+		{"v1.16.8-eks-e16311", semVersion{1, 16, 8}},
+		{"v1.29.0+k3s1", semVersion{1, 29, 0}},
+	}
+	for _, tt := range tests {
+		got, err := parseVersion(tt.s)
+		if err != nil || got != tt.want {
+			t.Errorf("parseVersion(%q) = %v, %v, want %v", tt.s, got, err, tt.want)
+		}
+	}
+	for _, bad := range []string{"latest", "v1", "1.2.3.4", "v1.x.0"} {
+		if _, err := parseVersion(bad); err == nil {
+			t.Errorf("parseVersion(%q) returned no error", bad)
+		}
+	}
+
+	if c := (semVersion{1, 10, 0}).compare(semVersion{1, 9, 3}); c != 1 {
+		t.Errorf("v1.10.0 compared with v1.9.3 = %d, want 1", c)
+	}
+}
@@ -0,0 +1,34 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/joshisumit/eks-dns-troubleshooter/pkg/aws"
+	"github.com/joshisumit/eks-dns-troubleshooter/pkg/findings"
+)
+
+//imdsFindings reports why instance metadata is not usable from the troubleshooter pod and the impact on the diagnosis
+func imdsFindings(c *aws.IMDSCheck) []findings.Finding {
+	res := make([]findings.Finding, 0)
+	if c == nil || c.Reason == "" {
+		return res
+	}
+
+	remediation := fmt.Sprintf("Configure %s and %s (or the -cluster-name and -region flags) so discovery does not depend on instance metadata.", envClusterName, envRegion)
+	if c.HttpPutResponseHopLimit == 1 && !c.HostNetwork {
+		remediation = "Raise the hop limit to 2 (aws ec2 modify-instance-metadata-options --instance-id <id> --http-put-response-hop-limit 2, or MetadataOptions in the launch template) or " + remediation
+	}
+
+	evidence := []string{
+		fmt.Sprintf("IMDSv1: %s, IMDSv2 token: %s, IMDSv2: %s, hostNetwork: %v", c.V1, c.V2Token, c.V2, c.HostNetwork),
+	}
+	if c.MetadataOptionsError != "" {
+		evidence = append(evidence, "MetadataOptions: "+c.MetadataOptionsError)
+	} else {
+		evidence = append(evidence, fmt.Sprintf("MetadataOptions: HttpEndpoint=%s HttpTokens=%s HttpPutResponseHopLimit=%d", c.HttpEndpoint, c.HttpTokens, c.HttpPutResponseHopLimit))
+	}
+	evidence = append(evidence, c.Impact...)
+
+	res = append(res, findings.New("IMDS-UNAVAILABLE", findings.SeverityWarning, "imds", c.Reason, remediation, evidence...))
+	return res
+}
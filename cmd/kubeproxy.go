@@ -0,0 +1,222 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/joshisumit/eks-dns-troubleshooter/pkg/findings"
+	log "github.com/sirupsen/logrus"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/yaml"
+)
+
+const (
+	kubeProxyConfigMap = "kube-proxy-config"
+	//kubeProxyLogTailLines is the number of log lines checked for sync errors in each kube-proxy pod
+	kubeProxyLogTailLines = 1000
+	kubeProxyModeIptables = "iptables"
+)
+
+//kubeProxySyncErrors matches log lines of kube-proxy which show that the service rules were not programmed
+var kubeProxySyncErrors = regexp.MustCompile(`(?i)(Failed to execute iptables-restore|iptables-restore: line \d+ failed|Failed to sync|sync proxy rules.*(fail|error)|Failed to list \*v1(beta1)?\.(Service|Endpoints|EndpointSlice)|Failed to retrieve node info|ipvs.*(fail|error))`)
+
+//supportedKubeProxySkew returns how many minor versions kube-proxy may be older than the control plane,
+//the version skew policy allows 3 since Kubernetes 1.28 and 2 before
+func supportedKubeProxySkew(cluster semVersion) int {
+	if cluster.Major > 1 || cluster.Minor >= 28 {
+		return 3
+	}
+	return 2
+}
+
+//KubeProxyCheck stores rollout status, version, proxy mode and per pod health of kube-proxy
+type KubeProxyCheck struct {
+	DesiredNumberScheduled int32          `json:"desiredNumberScheduled"`
+	UpdatedNumberScheduled int32          `json:"updatedNumberScheduled"`
+	NumberReady            int32          `json:"numberReady"`
+	NumberAvailable        int32          `json:"numberAvailable"`
+	NumberUnavailable      int32          `json:"numberUnavailable"`
+	Image                  string         `json:"image"`
+	Version                string         `json:"version"`
+	KubernetesVersion      string         `json:"kubernetesVersion"`
+	VersionSkew            string         `json:"versionSkew,omitempty"`
+	Mode                   string         `json:"mode"`
+	Pods                   []KubeProxyPod `json:"pods"`
+}
+
+//KubeProxyPod stores status of a kube-proxy pod and the sync errors found in its logs
+type KubeProxyPod struct {
+	Name                  string   `json:"name"`
+	Node                  string   `json:"node"`
+	Ready                 bool     `json:"ready"`
+	RestartCount          int32    `json:"restartCount"`
+	LastTerminationReason string   `json:"lastTerminationReason,omitempty"`
+	LogsChecked           bool     `json:"logsChecked"`
+	SyncErrors            []string `json:"syncErrors,omitempty"`
+}
+
+//kubeProxyMode reads the proxy mode from the kube-proxy-config ConfigMap, empty mode means iptables
+func kubeProxyMode(ns string) (string, error) {
+	cm, err := Clientset.CoreV1().ConfigMaps(ns).Get(kubeProxyConfigMap, metav1.GetOptions{})
+	if err != nil {
+		return "", fmt.Errorf("Failed to get %s configmap: %v", kubeProxyConfigMap, err)
+	}
+
+	cfg := struct {
+		Mode string `json:"mode"`
+	}{}
+	err = yaml.Unmarshal([]byte(cm.Data["config"]), &cfg)
+	if err != nil {
+		return "", fmt.Errorf("Failed to parse %s configmap: %v", kubeProxyConfigMap, err)
+	}
+	if cfg.Mode == "" {
+		return kubeProxyModeIptables, nil
+	}
+	return cfg.Mode, nil
+}
+
+//checkKubeProxy inspects the kube-proxy DaemonSet. Logs are only checked for pods running on the given nodes
+//(nodes of the troubleshooter and coredns pods), as those are the nodes on which the DNS tests were executed.
+func checkKubeProxy(ns string, k8sGitVersion string, logNodes []string) (*KubeProxyCheck, error) {
+	ds, err := Clientset.AppsV1().DaemonSets(ns).Get("kube-proxy", metav1.GetOptions{})
+	if err != nil {
+		log.Errorf("Failed to get kube-proxy daemonset: %v", err)
+		return nil, fmt.Errorf("Failed to get kube-proxy daemonset: %v", err)
+	}
+
+	kp := &KubeProxyCheck{
+		DesiredNumberScheduled: ds.Status.DesiredNumberScheduled,
+		UpdatedNumberScheduled: ds.Status.UpdatedNumberScheduled,
+		NumberReady:            ds.Status.NumberReady,
+		NumberAvailable:        ds.Status.NumberAvailable,
+		NumberUnavailable:      ds.Status.NumberUnavailable,
+		Image:                  containerImage(ds.Spec.Template.Spec, "kube-proxy"),
+		Pods:                   make([]KubeProxyPod, 0),
+	}
+
+	//1. version of kube-proxy compared with the version of the control plane
+	if ref, err := parseImageRef(kp.Image); err == nil {
+		kp.Version = ref.Tag
+	}
+	proxyVersion, perr := parseVersion(kp.Version)
+	clusterVersion, cerr := parseVersion(k8sGitVersion)
+	if cerr == nil {
+		kp.KubernetesVersion = clusterVersion.minorVersion()
+	}
+	if perr == nil && cerr == nil {
+		switch {
+		case proxyVersion.Major != clusterVersion.Major || proxyVersion.Minor > clusterVersion.Minor:
+			kp.VersionSkew = fmt.Sprintf("kube-proxy %s is newer than the control plane %s, which is not supported", kp.Version, kp.KubernetesVersion)
+		case clusterVersion.Minor-proxyVersion.Minor > supportedKubeProxySkew(clusterVersion):
+			kp.VersionSkew = fmt.Sprintf("kube-proxy %s is %d minor versions older than the control plane %s, more than the supported skew of %d", kp.Version, clusterVersion.Minor-proxyVersion.Minor, kp.KubernetesVersion, supportedKubeProxySkew(clusterVersion))
+		}
+	}
+
+	//2. iptables or IPVS mode
+	kp.Mode, err = kubeProxyMode(ns)
+	if err != nil {
+		log.Warnf("Unable to detect kube-proxy mode: %v", err)
+	}
+
+	//3. restarts of every kube-proxy pod and sync errors in the logs of pods running on the relevant nodes
+	selector, err := metav1.LabelSelectorAsSelector(ds.Spec.Selector)
+	if err != nil {
+		return kp, fmt.Errorf("invalid selector of kube-proxy daemonset: %v", err)
+	}
+	podList, err := Clientset.CoreV1().Pods(ns).List(metav1.ListOptions{LabelSelector: selector.String()})
+	if err != nil {
+		return kp, fmt.Errorf("Failed to list kube-proxy pods: %v", err)
+	}
+
+	checkLogsOn := make(map[string]bool)
+	for _, node := range logNodes {
+		checkLogsOn[node] = true
+	}
+
+	for _, pod := range podList.Items {
+		p := KubeProxyPod{Name: pod.Name, Node: pod.Spec.NodeName}
+		for _, cs := range pod.Status.ContainerStatuses {
+			p.Ready = cs.Ready
+			p.RestartCount += cs.RestartCount
+			if cs.LastTerminationState.Terminated != nil {
+				p.LastTerminationReason = cs.LastTerminationState.Terminated.Reason
+			}
+		}
+
+		if checkLogsOn[p.Node] || p.RestartCount > 0 || !p.Ready {
+			logContent, err := getPodLogs(ns, pod.Name, kubeProxyLogTailLines)
+			if err != nil {
+				log.Warnf("Failed to get logs of kube-proxy pod %s: %v", pod.Name, err)
+			} else {
+				p.LogsChecked = true
+				for _, line := range strings.Split(logContent, "\n") {
+					if kubeProxySyncErrors.MatchString(line) && len(p.SyncErrors) < maxSampleLines {
+						p.SyncErrors = append(p.SyncErrors, strings.TrimSpace(line))
+					}
+				}
+			}
+		}
+		kp.Pods = append(kp.Pods, p)
+	}
+
+	log.Infof("kube-proxy check: %+v", kp)
+	return kp, nil
+}
+
+//kubeProxyClusterIPPath explains why a kube-proxy problem only breaks queries sent to the kube-dns ClusterIP
+const kubeProxyClusterIPPath = "kube-proxy programs the iptables/IPVS DNAT rules which translate the kube-dns ClusterIP into CoreDNS pod IPs on every node, " +
+	"so queries sent to the ClusterIP from the affected nodes are dropped while queries sent directly to the CoreDNS pod IPs bypass these rules and still succeed"
+
+//kubeProxyFindings reports kube-proxy problems which break the kube-dns ClusterIP, linked to the DNS tests when they point at the service path
+func kubeProxyFindings(kp *KubeProxyCheck, diag *DNSDiagnosis) []findings.Finding {
+	res := make([]findings.Finding, 0)
+	if kp == nil {
+		return res
+	}
+	const source = "kubeProxy"
+
+	//the DNS tests confirm the kube-proxy problem when only the ClusterIP path failed
+	related := make([]string, 0)
+	if diag != nil && diag.Layer == layerService {
+		related = append(related, fmt.Sprintf("dnsTest layer=%s: %s", diag.Layer, diag.Verdict))
+	}
+	withRelated := func(evidence ...string) []string {
+		res := make([]string, 0, len(evidence)+len(related))
+		return append(append(res, evidence...), related...)
+	}
+
+	if kp.NumberUnavailable > 0 || kp.NumberReady < kp.DesiredNumberScheduled || kp.UpdatedNumberScheduled < kp.DesiredNumberScheduled {
+		res = append(res, findings.New("KUBE-PROXY-ROLLOUT", findings.SeverityCritical, source,
+			fmt.Sprintf("kube-proxy is not ready on every node (%d ready of %d desired). %s", kp.NumberReady, kp.DesiredNumberScheduled, kubeProxyClusterIPPath),
+			"Check the kube-proxy pods which are not ready (kubectl get pods -n kube-system -l k8s-app=kube-proxy -o wide) and their logs.",
+			withRelated(fmt.Sprintf("desired=%d updated=%d ready=%d available=%d unavailable=%d", kp.DesiredNumberScheduled, kp.UpdatedNumberScheduled, kp.NumberReady, kp.NumberAvailable, kp.NumberUnavailable))...))
+	}
+	if kp.VersionSkew != "" {
+		res = append(res, findings.New("KUBE-PROXY-VERSION-SKEW", findings.SeverityWarning, source,
+			fmt.Sprintf("%s. An unsupported kube-proxy can program the service rules incorrectly: %s", kp.VersionSkew, kubeProxyClusterIPPath),
+			"Update kube-proxy to the version recommended for the cluster version after every control plane upgrade.",
+			withRelated(fmt.Sprintf("image=%s", kp.Image))...))
+	}
+	if kp.Mode != "" && kp.Mode != kubeProxyModeIptables && kp.Mode != "ipvs" {
+		res = append(res, findings.New("KUBE-PROXY-MODE", findings.SeverityWarning, source,
+			fmt.Sprintf("kube-proxy runs in %q mode, EKS supports iptables and ipvs", kp.Mode),
+			fmt.Sprintf("Set mode to iptables or ipvs in the %s configmap.", kubeProxyConfigMap)))
+	}
+
+	for _, p := range kp.Pods {
+		if len(p.SyncErrors) != 0 {
+			res = append(res, findings.New("KUBE-PROXY-SYNC-ERRORS", findings.SeverityCritical, source,
+				fmt.Sprintf("kube-proxy pod %s on node %s is failing to sync service rules. %s", p.Name, p.Node, kubeProxyClusterIPPath),
+				"Check the full logs of the kube-proxy pod, the iptables/ipvs kernel modules of the node and the permissions of the kube-proxy service account.",
+				withRelated(p.SyncErrors...)...))
+		}
+		if p.RestartCount > restartCountThreshold || !p.Ready {
+			res = append(res, findings.New("KUBE-PROXY-POD-UNHEALTHY", findings.SeverityWarning, source,
+				fmt.Sprintf("kube-proxy pod %s on node %s is not healthy (ready %t, %d restarts), the service rules of this node may be missing or stale. %s", p.Name, p.Node, p.Ready, p.RestartCount, kubeProxyClusterIPPath),
+				"Describe the kube-proxy pod and check the logs of the previous container.",
+				withRelated(fmt.Sprintf("lastTerminationReason=%s", p.LastTerminationReason))...))
+		}
+	}
+	return res
+}
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestSupportedKubeProxySkew(t *testing.T) {
+	tests := []struct {
+		cluster string
+		want    int
+	}{
+		{"v1.27.8-eks-8cb36c9", 2},
+		{"v1.28.3-eks-4f4795d", 3},
+		{"v1.30.0", 3},
+	}
+	for _, tt := range tests {
+		v, err := parseVersion(tt.cluster)
+		if err != nil {
+			t.Fatalf("parseVersion(%q) returned error: %v", tt.cluster, err)
+		}
+		if got := supportedKubeProxySkew(v); got != tt.want {
+			t.Errorf("supportedKubeProxySkew(%s) = %d, want %d", tt.cluster, got, tt.want)
+		}
+	}
+}
+
+func TestKubeProxyFindingsExplainClusterIPPath(t *testing.T) {
+	kp := &KubeProxyCheck{
+		DesiredNumberScheduled: 2,
+		UpdatedNumberScheduled: 2,
+		NumberReady:            1,
+		NumberAvailable:        1,
+		NumberUnavailable:      1,
+		VersionSkew:            "kube-proxy v1.24.7 is 4 minor versions older than the control plane v1.28.3, more than the supported skew of 3",
+		Pods: []KubeProxyPod{{
+			Name:       "kube-proxy-abcde",
+			Node:       "ip-10-0-1-10.ec2.internal",
+			Ready:      false,
+			SyncErrors: []string{"Failed to execute iptables-restore: exit status 1"},
+		}},
+	}
+	diag := &DNSDiagnosis{Layer: layerService, Verdict: "Queries to the kube-dns ClusterIP fail"}
+
+	res := kubeProxyFindings(kp, diag)
+	if len(res) != 4 {
+		t.Fatalf("got %d findings, want 4", len(res))
+	}
+	for _, f := range res {
+		if !strings.Contains(f.Explanation, kubeProxyClusterIPPath) {
+			t.Errorf("%s explanation does not explain the ClusterIP path: %q", f.ID, f.Explanation)
+		}
+		if len(f.Evidence) == 0 || !strings.HasPrefix(f.Evidence[len(f.Evidence)-1], "dnsTest layer="+layerService) {
+			t.Errorf("%s evidence does not reference the %s layer: %v", f.ID, layerService, f.Evidence)
+		}
+	}
+
+	for _, f := range kubeProxyFindings(kp, &DNSDiagnosis{Layer: layerNone}) {
+		for _, e := range f.Evidence {
+			if strings.HasPrefix(e, "dnsTest layer=") {
+				t.Errorf("%s references the DNS tests although they passed: %v", f.ID, f.Evidence)
+			}
+		}
+	}
+}
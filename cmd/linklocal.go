@@ -0,0 +1,207 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"sort"
+	"strings"
+
+	"github.com/joshisumit/eks-dns-troubleshooter/pkg/aws"
+	"github.com/joshisumit/eks-dns-troubleshooter/pkg/findings"
+)
+
+const (
+	//linkLocalPPSLimit is the packets per second allowance per ENI towards the VPC resolver (and other link-local services)
+	linkLocalPPSLimit = 1024
+	//linkLocalWarnRatio of the allowance is reported as close to the limit, short windows hide peaks
+	linkLocalWarnRatio = 0.7
+	//packetsPerForward counts the query and the response of a forwarded UDP query
+	packetsPerForward = 2
+
+	noForwardMetrics = "no forward request metrics exposed (forward plugin not used or prometheus plugin disabled)"
+)
+
+//forwardRequestMetrics are the counters of queries sent upstream, by coredns version
+var forwardRequestMetrics = []string{
+	"coredns_forward_requests_total",
+	"coredns_forward_request_count_total",
+	"coredns_proxy_request_count_total",
+}
+
+//PodPacketRate is the estimated rate of packets from a coredns pod to the VPC resolver
+type PodPacketRate struct {
+	Pod          string  `json:"pod"`
+	IP           string  `json:"ip"`
+	Node         string  `json:"node,omitempty"`
+	Metric       string  `json:"metric,omitempty"`
+	ForwardQPS   float64 `json:"forwardQps"`
+	EstimatedPPS float64 `json:"estimatedPps"`
+	Error        string  `json:"error,omitempty"`
+}
+
+//NodePacketRate is the estimated rate of packets to the VPC resolver of the coredns pods of a node.
+//Pods on different ENIs of the node have separate allowances, so this is an upper bound per ENI.
+type NodePacketRate struct {
+	Node         string   `json:"node"`
+	Pods         []string `json:"pods"`
+	EstimatedPPS float64  `json:"estimatedPps"`
+	PercentLimit float64  `json:"percentOfAllowance"`
+}
+
+//LinkLocalCheck estimates the packets per second of coredns towards the VPC resolver from its forward metrics
+type LinkLocalCheck struct {
+	WindowSeconds float64          `json:"windowSeconds"`
+	ResolverIPs   []string         `json:"resolverIps,omitempty"`
+	Pods          []PodPacketRate  `json:"pods"`
+	Nodes         []NodePacketRate `json:"nodes,omitempty"`
+}
+
+//vpcResolverIPs returns the addresses of the VPC resolver which count against the link-local allowance
+func vpcResolverIPs(vpc *aws.VPCDNSCheck) []string {
+	ips := []string{aws.AmazonDNSLinkLocalIP}
+	if vpc != nil && vpc.AmazonDNSIP != "" {
+		ips = append(ips, vpc.AmazonDNSIP)
+	}
+	return ips
+}
+
+//estimateLinkLocalPPS computes the rate of queries forwarded to the VPC resolver by every coredns pod and node.
+//Without the VPC (AWS discovery failed) every forward target is counted.
+func estimateLinkLocalPPS(scrapes []podScrape, vpc *aws.VPCDNSCheck) *LinkLocalCheck {
+	if len(scrapes) == 0 {
+		return nil
+	}
+	check := &LinkLocalCheck{WindowSeconds: metricsScrapeWindow.Seconds()}
+	isResolver := make(map[string]bool)
+	if vpc != nil {
+		check.ResolverIPs = vpcResolverIPs(vpc)
+		for _, ip := range check.ResolverIPs {
+			isResolver[ip] = true
+		}
+	}
+	toResolver := func(labels map[string]string) bool {
+		to, ok := labels["to"]
+		if !ok || len(isResolver) == 0 {
+			return true
+		}
+		if host, _, err := net.SplitHostPort(to); err == nil {
+			to = host
+		}
+		return isResolver[to]
+	}
+
+	byNode := make(map[string]*NodePacketRate)
+	for i := range scrapes {
+		sc := &scrapes[i]
+		rate := PodPacketRate{Pod: sc.pod, IP: sc.ip, Node: sc.node}
+		if sc.err != nil {
+			rate.Error = sc.err.Error()
+			check.Pods = append(check.Pods, rate)
+			continue
+		}
+		qps, metric, ok := sc.counterRate(forwardRequestMetrics, toResolver)
+		if !ok {
+			rate.Error = noForwardMetrics
+		}
+		rate.Metric, rate.ForwardQPS, rate.EstimatedPPS = metric, qps, qps*packetsPerForward
+		check.Pods = append(check.Pods, rate)
+
+		node := sc.node
+		if node == "" {
+			node = sc.pod
+		}
+		n, ok := byNode[node]
+		if !ok {
+			n = &NodePacketRate{Node: node}
+			byNode[node] = n
+		}
+		n.Pods = append(n.Pods, sc.pod)
+		n.EstimatedPPS += rate.EstimatedPPS
+	}
+
+	nodes := make([]string, 0, len(byNode))
+	for node := range byNode {
+		nodes = append(nodes, node)
+	}
+	sort.Strings(nodes)
+	for _, node := range nodes {
+		n := byNode[node]
+		n.PercentLimit = n.EstimatedPPS * 100 / linkLocalPPSLimit
+		check.Nodes = append(check.Nodes, *n)
+	}
+	return check
+}
+
+//corefileHasPlugin returns true if any server block of the Corefile uses the plugin
+func corefileHasPlugin(corefile, plugin string) bool {
+	blocks, err := parseServerBlocks("Corefile", corefile)
+	if err != nil {
+		return false
+	}
+	for _, block := range blocks {
+		if len(directiveArgs(block, plugin)) > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+//linkLocalFindings reports nodes whose coredns pods are likely to exceed the link-local packets per second allowance
+func linkLocalFindings(check *LinkLocalCheck, cd *Coredns) []findings.Finding {
+	res := make([]findings.Finding, 0)
+	if check == nil {
+		return res
+	}
+	const source = "linkLocalAllowance"
+
+	remediation := make([]string, 0, 3)
+	if !corefileHasPlugin(cd.Corefile, "cache") {
+		remediation = append(remediation, "enable the cache plugin in the Corefile")
+	}
+	if !cd.HasNodeLocalCache {
+		remediation = append(remediation, "deploy NodeLocal DNSCache so that nodes cache answers and spread upstream queries over every node")
+	}
+	remediation = append(remediation, "scale coredns and spread replicas over more nodes (each ENI has its own allowance)",
+		"lower ndots or use fully qualified names to avoid search path queries")
+
+	for _, n := range check.Nodes {
+		evidence := []string{
+			fmt.Sprintf("coredns pods: %s", strings.Join(n.Pods, ", ")),
+			fmt.Sprintf("estimated %.0f packets/s over %.0fs (%d packets per forwarded query)", n.EstimatedPPS, check.WindowSeconds, packetsPerForward),
+		}
+		if len(check.ResolverIPs) > 0 {
+			evidence = append(evidence, "VPC resolver addresses counted: "+strings.Join(check.ResolverIPs, ", "))
+		} else {
+			evidence = append(evidence, "VPC resolver unknown, queries to every forward target counted")
+		}
+		evidence = append(evidence, "confirm with `ethtool -S eth0 | grep linklocal_allowance_exceeded` on the node")
+
+		switch {
+		case n.EstimatedPPS >= linkLocalPPSLimit:
+			res = append(res, findings.New("LINKLOCAL-ALLOWANCE-EXCEEDED", findings.SeverityCritical, source,
+				fmt.Sprintf("coredns on node %s sends about %.0f packets/s to the VPC resolver, above the %d packets/s allowance per ENI; external lookups time out intermittently", n.Node, n.EstimatedPPS, linkLocalPPSLimit),
+				"Reduce the queries forwarded to the VPC resolver: "+strings.Join(remediation, "; ")+".",
+				evidence...))
+		case n.EstimatedPPS >= linkLocalPPSLimit*linkLocalWarnRatio:
+			res = append(res, findings.New("LINKLOCAL-ALLOWANCE-NEAR-LIMIT", findings.SeverityWarning, source,
+				fmt.Sprintf("coredns on node %s sends about %.0f packets/s to the VPC resolver (%.0f%% of the %d packets/s allowance per ENI), peaks may exceed it", n.Node, n.EstimatedPPS, n.PercentLimit, linkLocalPPSLimit),
+				"Reduce the queries forwarded to the VPC resolver: "+strings.Join(remediation, "; ")+".",
+				evidence...))
+		}
+	}
+
+	//pods whose metrics could not be scraped are reported by metricsFindings
+	failed := make([]string, 0)
+	for _, p := range check.Pods {
+		if p.Error == noForwardMetrics {
+			failed = append(failed, fmt.Sprintf("%s (%s)", p.Pod, p.IP))
+		}
+	}
+	if len(failed) > 0 {
+		res = append(res, findings.New("LINKLOCAL-ESTIMATE-INCOMPLETE", findings.SeverityInfo, source,
+			"The packet rate towards the VPC resolver could not be estimated for some coredns pods: "+noForwardMetrics,
+			"Enable the prometheus plugin (prometheus :9153) in the Corefile.",
+			failed...))
+	}
+	return res
+}
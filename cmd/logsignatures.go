@@ -0,0 +1,206 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"regexp"
+	"strings"
+
+	"github.com/joshisumit/eks-dns-troubleshooter/pkg/findings"
+	log "github.com/sirupsen/logrus"
+	"sigs.k8s.io/yaml"
+)
+
+const (
+	//envLogSignatures points to a YAML/JSON file (e.g. mounted from a ConfigMap) with additional log signatures
+	envLogSignatures = "EKS_DNS_LOG_SIGNATURES"
+	//maxSampleLines is the number of matched log lines kept as evidence for each signature
+	maxSampleLines = 3
+)
+
+//LogSignature maps a regular expression matching CoreDNS log lines to a known root cause
+type LogSignature struct {
+	ID          string            `json:"id"`
+	Pattern     string            `json:"pattern"`
+	Severity    findings.Severity `json:"severity"`
+	Explanation string            `json:"explanation"`
+	Remediation string            `json:"remediation"`
+
+	re *regexp.Regexp
+}
+
+//LogSignatureMatch stores how many times a signature matched in the logs of a coredns pod
+type LogSignatureMatch struct {
+	ID          string            `json:"id"`
+	Pod         string            `json:"pod"`
+	Count       int               `json:"count"`
+	SampleLines []string          `json:"sampleLines"`
+	Severity    findings.Severity `json:"-"`
+	Explanation string            `json:"-"`
+	Remediation string            `json:"-"`
+}
+
+//defaultLogSignatures is the built-in catalogue of well-known CoreDNS log errors on EKS
+var defaultLogSignatures = []LogSignature{
+	{
+		ID:          "COREDNS-LOG-UPSTREAM-TIMEOUT",
+		Pattern:     `(?i)read (udp|tcp) \S+->\S+:53: i/o timeout`,
+		Severity:    findings.SeverityCritical,
+		Explanation: "CoreDNS did not get a response from the upstream resolver (normally the VPC resolver at VPC CIDR base+2 or 169.254.169.253) in time. External names fail or time out intermittently.",
+		Remediation: "Check that the worker node Security Groups and NACLs allow outbound 53/UDP and 53/TCP, that the VPC has enableDnsSupport turned on, and that CoreDNS is not exceeding the 1024 packets per second per ENI limit to the VPC resolver (scale CoreDNS or use NodeLocal DNSCache).",
+	},
+	{
+		ID:          "COREDNS-LOG-LOOP",
+		Pattern:     `Loop \(.*\) detected for zone`,
+		Severity:    findings.SeverityCritical,
+		Explanation: "The loop plugin detected that CoreDNS forwards queries back to itself. This usually happens when /etc/resolv.conf of the node points to a local stub resolver (e.g. 127.0.0.53 of systemd-resolved) or to the kube-dns ClusterIP. CoreDNS exits and the pods go into CrashLoopBackOff.",
+		Remediation: "Point the forward plugin to the real upstream (e.g. the VPC resolver IP) or configure kubelet --resolv-conf to a file which contains the real upstream nameservers.",
+	},
+	{
+		ID:          "COREDNS-LOG-APISERVER-UNREACHABLE",
+		Pattern:     `plugin/kubernetes: .*(connection refused|i/o timeout|no route to host)`,
+		Severity:    findings.SeverityCritical,
+		Explanation: "The kubernetes plugin of CoreDNS cannot reach the Kubernetes API server, so cluster names (services and pods) cannot be resolved or are stale.",
+		Remediation: "Make sure kube-proxy is running on the nodes hosting CoreDNS (the `kubernetes` service ClusterIP must be reachable) and that the cluster Security Group allows the worker nodes to reach the control plane on 443/TCP.",
+	},
+	{
+		ID:          "COREDNS-LOG-RBAC-FORBIDDEN",
+		Pattern:     `is forbidden: User "system:serviceaccount:kube-system:coredns"`,
+		Severity:    findings.SeverityCritical,
+		Explanation: "The coredns service account is not allowed to list or watch resources needed by the kubernetes plugin. This is common after upgrading CoreDNS to v1.8.x without adding endpointslices to the system:coredns ClusterRole.",
+		Remediation: "Update the system:coredns ClusterRole so that it allows list/watch on endpoints, services, pods, namespaces and discovery.k8s.io/endpointslices.",
+	},
+	{
+		ID:          "COREDNS-LOG-HINFO-PROBE",
+		Pattern:     `(?i)HINFO: .*(timeout|unreachable|refused)`,
+		Severity:    findings.SeverityWarning,
+		Explanation: "The HINFO query sent by the loop plugin at startup failed. The query itself is harmless, but its failure shows that CoreDNS could not reach the upstream resolver when the pod started.",
+		Remediation: "Verify that the upstream resolver configured in the forward plugin is reachable from the CoreDNS pods (Security Groups, NACLs, route tables).",
+	},
+	{
+		ID:          "COREDNS-LOG-NO-SUCH-HOST",
+		Pattern:     `no such host`,
+		Severity:    findings.SeverityWarning,
+		Explanation: "A hostname used by CoreDNS itself (for example in a plugin configuration) could not be resolved.",
+		Remediation: "Use IP addresses in the forward plugin and other plugin configuration, and verify that the VPC DHCP options set and DNS attributes are correct.",
+	},
+	{
+		ID:          "COREDNS-LOG-NO-HEALTHY-UPSTREAM",
+		Pattern:     `no healthy proxies`,
+		Severity:    findings.SeverityCritical,
+		Explanation: "All the upstreams configured in the forward plugin failed their health checks, so CoreDNS answers SERVFAIL for every name it has to forward.",
+		Remediation: "Check that the forward targets are reachable from the CoreDNS pods on 53/UDP and 53/TCP and that they are healthy.",
+	},
+}
+
+//loadLogSignatures returns the built-in signatures together with the ones configured through envLogSignatures.
+//A custom file which cannot be read or is invalid is ignored with a warning, so that the built-in signatures are still used.
+func loadLogSignatures() ([]LogSignature, error) {
+	signatures := make([]LogSignature, 0, len(defaultLogSignatures))
+	signatures = append(signatures, defaultLogSignatures...)
+	if err := compileLogSignatures(signatures); err != nil {
+		return nil, err
+	}
+
+	path := os.Getenv(envLogSignatures)
+	if path == "" {
+		return signatures, nil
+	}
+	custom, err := loadCustomLogSignatures(path)
+	if err != nil {
+		log.Warnf("Ignoring custom log signatures, only the built-in ones are used: %v", err)
+		return signatures, nil
+	}
+	log.Infof("Loaded %d custom log signatures from %s", len(custom), path)
+	return append(signatures, custom...), nil
+}
+
+//loadCustomLogSignatures reads and compiles the signatures of a YAML/JSON file
+func loadCustomLogSignatures(path string) ([]LogSignature, error) {
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to read log signatures file %q: %v", path, err)
+	}
+
+	custom := make([]LogSignature, 0)
+	err = yaml.Unmarshal(content, &custom)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to parse log signatures file %q: %v", path, err)
+	}
+	if err := compileLogSignatures(custom); err != nil {
+		return nil, fmt.Errorf("Invalid log signatures file %q: %v", path, err)
+	}
+	return custom, nil
+}
+
+//compileLogSignatures validates the signatures, compiles their patterns and defaults their severity
+func compileLogSignatures(signatures []LogSignature) error {
+	for i := range signatures {
+		sig := &signatures[i]
+		if sig.ID == "" || sig.Pattern == "" {
+			return fmt.Errorf("log signature #%d must have an id and a pattern", i)
+		}
+		if sig.Severity == "" {
+			sig.Severity = findings.SeverityWarning
+		}
+		re, err := regexp.Compile(sig.Pattern)
+		if err != nil {
+			return fmt.Errorf("invalid pattern for log signature %q: %v", sig.ID, err)
+		}
+		sig.re = re
+	}
+	return nil
+}
+
+//matchLogSignatures applies all the signatures on the logs of a pod, line by line
+func matchLogSignatures(signatures []LogSignature, podName, logContent string) []LogSignatureMatch {
+	matches := make([]LogSignatureMatch, 0)
+
+	lines := strings.Split(logContent, "\n")
+	for _, sig := range signatures {
+		m := LogSignatureMatch{
+			ID:          sig.ID,
+			Pod:         podName,
+			SampleLines: make([]string, 0, maxSampleLines),
+			Severity:    sig.Severity,
+			Explanation: sig.Explanation,
+			Remediation: sig.Remediation,
+		}
+		for _, line := range lines {
+			if !sig.re.MatchString(line) {
+				continue
+			}
+			m.Count++
+			if len(m.SampleLines) < maxSampleLines {
+				m.SampleLines = append(m.SampleLines, strings.TrimSpace(line))
+			}
+		}
+		if m.Count > 0 {
+			log.Debugf("Log signature %s matched %d times in pod %s", sig.ID, m.Count, podName)
+			matches = append(matches, m)
+		}
+	}
+	return matches
+}
+
+//logSignatureFindings converts signature matches into findings, one per signature
+func logSignatureFindings(matches []LogSignatureMatch) []findings.Finding {
+	res := make([]findings.Finding, 0)
+	index := make(map[string]int)
+
+	for _, m := range matches {
+		evidence := make([]string, 0, len(m.SampleLines))
+		for _, line := range m.SampleLines {
+			evidence = append(evidence, fmt.Sprintf("%s: %s", m.Pod, line))
+		}
+
+		if i, ok := index[m.ID]; ok {
+			res[i].Evidence = append(res[i].Evidence, evidence...)
+			continue
+		}
+		index[m.ID] = len(res)
+		res = append(res, findings.New(m.ID, m.Severity, "corednsLogs", m.Explanation, m.Remediation, evidence...))
+	}
+	return res
+}
@@ -0,0 +1,48 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestLoadLogSignatures(t *testing.T) {
+	dir, err := ioutil.TempDir("", "signatures")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	tests := []struct {
+		name    string
+		content string
+		want    int
+	}{
+		{"valid", "- id: CUSTOM\n  pattern: 'refused'\n", len(defaultLogSignatures) + 1},
+		{"malformed", "- id: [CUSTOM\n", len(defaultLogSignatures)},
+		{"invalid pattern", "- id: CUSTOM\n  pattern: '('\n", len(defaultLogSignatures)},
+		{"missing pattern", "- id: CUSTOM\n", len(defaultLogSignatures)},
+	}
+	for _, tt := range tests {
+		path := filepath.Join(dir, "signatures.yaml")
+		if err := ioutil.WriteFile(path, []byte(tt.content), 0600); err != nil {
+			t.Fatal(err)
+		}
+		os.Setenv(envLogSignatures, path)
+		signatures, err := loadLogSignatures()
+		if err != nil {
+			t.Errorf("%s: loadLogSignatures returned error: %v", tt.name, err)
+			continue
+		}
+		if len(signatures) != tt.want {
+			t.Errorf("%s: got %d signatures, want %d", tt.name, len(signatures), tt.want)
+		}
+	}
+
+	os.Setenv(envLogSignatures, filepath.Join(dir, "missing.yaml"))
+	defer os.Unsetenv(envLogSignatures)
+	if signatures, err := loadLogSignatures(); err != nil || len(signatures) != len(defaultLogSignatures) {
+		t.Errorf("expected the built-in signatures for a missing file, got %d (%v)", len(signatures), err)
+	}
+}
@@ -1,6 +1,7 @@
 package main
 
 import (
+	"flag"
 	"fmt"
 	"github.com/joshisumit/eks-dns-troubleshooter/pkg/aws"
 	"github.com/joshisumit/eks-dns-troubleshooter/version"
@@ -20,16 +21,20 @@ const (
 	logFilePath   = "/var/log/eks-dns-tool.log"
 	sleepDuration = 86400
 	envLogLevel   = "EKS_DNS_LOGLEVEL"
+	//envTestDomains is a comma separated list of additional domains to test (e.g. internal domains)
+	envTestDomains = "EKS_DNS_TEST_DOMAINS"
 )
 
 //Clientset will be used for accessing multiple k8s groups
-var Clientset *kubernetes.Clientset
+var Clientset kubernetes.Interface
 
 func main() {
 	os.Exit(_main())
 }
 
 func _main() int {
+	flag.Parse()
+
 	//0. Logging - write same logs to stdout and file simultaneously
 	//Set Logging based on a file
 	file, err := os.OpenFile(logFilePath, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0644)
@@ -79,7 +84,7 @@ func _main() int {
 	}
 
 	//Detect cluster version
-	srvVersion, err := Clientset.ServerVersion()
+	srvVersion, err := Clientset.Discovery().ServerVersion()
 	if err != nil {
 		log.Errorf("Failed to fetch kubernetes version Error: %s", err)
 		sum.DiagError = fmt.Sprintf("Failed to fetch kubernetes version: %s", err)
@@ -114,7 +119,7 @@ func _main() int {
 	cd.ClusterIP = clusterIP
 
 	//Check endpoint exist or not
-	eips, notReadyEIP, err := checkServieEndpoint(ns)
+	epCheck, err := checkServiceEndpoints(ns)
 	if err != nil {
 		log.Errorf("kube-dns endpoints does not exist %s", err)
 		sum.DiagError = fmt.Sprintf("kube-dns endpoints does not exist %s", err)
@@ -125,14 +130,15 @@ func _main() int {
 		time.Sleep(sleepDuration * time.Second)
 		return 1
 	}
+	eips, notReadyEIP := epCheck.endpointIPs()
 	cd.EndpointsIP = eips
 	cd.NotReadyEndpoints = notReadyEIP
+	cd.ServiceEndpoints = epCheck
 
 	log.Infof("kube-dns endpoint IPs: %v length: %d cd.endspointsIP: %v", eips, len(eips), cd.EndpointsIP)
 
 	//Check recommenedVersion of CoreDNS pod is running or not
 	poVer, podNamesList, replicas, err := checkPodVersion(ns, &cd)
-	cd.RecommVersion = "v1.6.6"
 	cd.PodNamesList = podNamesList
 	cd.Replicas = replicas
 	if err != nil {
@@ -145,18 +151,93 @@ func _main() int {
 		time.Sleep(sleepDuration * time.Second)
 		return 1
 	}
-	if poVer == cd.RecommVersion {
-		log.Infof("Recommended coredns version %v is running", poVer)
-		//sum.RecommendedVersion = true
+
+	//Compare coredns, kube-proxy and VPC CNI versions with the version matrix of the running EKS version
+	versionChecks, err := checkComponentVersions(ns, srvVersion.GitVersion)
+	if err != nil {
+		log.Errorf("Failed to check versions of coredns, kube-proxy and VPC CNI: %v", err)
+	}
+	sum.ComponentVersions = versionChecks
+	for _, vc := range versionChecks {
+		if vc.Component != componentCoredns {
+			continue
+		}
+		cd.RecommVersion = vc.Recommended
+		if vc.Status == versionStatusRecommended || vc.Status == versionStatusNewer {
+			log.Infof("Recommended coredns version %v is running", poVer)
+			//sum.RecommendedVersion = true
+		} else {
+			log.Infof("Current coredns pods are running version %s (%s) ", poVer, vc.Status)
+			log.Infof("Recommended version for EKS %s is %s", srvVersion.GitVersion, cd.RecommVersion)
+			//Suggest to Upgrade coredns version with latest image
+		}
+	}
+
+	//Check health of coredns deployment: conditions, restarts, probes and resources
+	health, err := checkDeploymentHealth(ns)
+	if err != nil {
+		log.Errorf("Failed to check health of coredns deployment: %v", err)
+	}
+	cd.DeploymentHealth = health
+
+	//Check if coredns replicas are spread across nodes and AZs
+	topology, err := checkTopology(ns)
+	if err != nil {
+		log.Errorf("Failed to check topology of coredns replicas: %v", err)
+	}
+	cd.Topology = topology
+
+	//Check kube-proxy which programs the kube-dns ClusterIP on the nodes of the troubleshooter and coredns pods
+	var selfNode string
+	self, err := getSelfPod()
+	if err != nil {
+		log.Warnf("Unable to detect the node of the troubleshooter pod: %v", err)
 	} else {
-		log.Infof("Current coredns pods are running older version %s ", poVer)
-		log.Infof("Recommended version for EKS %s is %s", srvVersion.GitVersion, cd.RecommVersion)
-		//Suggest to Upgrade coredns version with latest image
+		selfNode = self.Spec.NodeName
+	}
+
+	//Detect Fargate, instance metadata is not available there
+	fargate, err := checkFargate(ns, self)
+	if err != nil {
+		log.Errorf("Failed to check Fargate: %v", err)
+	}
+	sum.Fargate = fargate
+
+	logNodes := []string{selfNode}
+	if topology != nil {
+		logNodes = append(logNodes, topology.Nodes...)
+	}
+	kubeProxy, err := checkKubeProxy(ns, srvVersion.GitVersion, logNodes)
+	if err != nil {
+		log.Errorf("Failed to check kube-proxy: %v", err)
 	}
+	sum.KubeProxy = kubeProxy
 
 	// Test DNS resolution
 	cd.testDNS()
 
+	//Validate NodeLocal DNSCache and kubelet --cluster-dns
+	nodeLocal, err := checkNodeLocalDNSCache(ns, &cd, self)
+	if err != nil {
+		log.Errorf("Failed to check NodeLocal DNSCache: %v", err)
+	}
+	cd.NodeLocalDNSCache = nodeLocal
+	cd.HasNodeLocalCache = cd.HasNodeLocalCache || (nodeLocal != nil && nodeLocal.Enabled)
+
+	//Audit dnsPolicy and dnsConfig of the workloads running in the cluster
+	dnsAudit, err := auditDNSPolicies(cd.ClusterIP)
+	if err != nil {
+		log.Errorf("Failed to audit dnsPolicy of workloads: %v", err)
+	}
+	sum.DNSPolicyAudit = dnsAudit
+
+	//Evaluate NetworkPolicies for DNS traffic between the troubleshooter (and configured targets) and coredns pods
+	netpol, err := checkNetworkPolicies(ns, self)
+	if err != nil {
+		log.Errorf("Failed to evaluate NetworkPolicies: %v", err)
+	}
+	sum.NetworkPolicies = netpol
+
 	//checkForErrorsInLogs
 	log.Infof("Checking logs of coredns pods for further debugging")
 	err = checkForErrorsInLogs(ns, &cd)
@@ -167,13 +248,40 @@ func _main() int {
 		return 1
 	}
 
+	//Scrape the prometheus metrics of every coredns replica twice to compute rates, and summarise them
+	cd.scrapes = scrapeCoredns(cd.ServiceEndpoints)
+	cd.Metrics = analyseMetrics(cd.scrapes)
+
 	//copy content of coredns struct to sum struct
 	sum.Coredns = cd
 
-	clusterInfo, err := aws.DiscoverClusterInfo()
+	//Discover cluster name and region from flags/env, tags, node, configmaps or IMDS
+	identity := discoverClusterIdentity(selfNode)
+	sum.ClusterInfo.Identity = *identity
+	if fargate != nil {
+		fargate.ClusterName, fargate.Region = identity.ClusterName, identity.Region
+	}
+
+	//Probe IMDSv1/v2 and the MetadataOptions of the node, Fargate has no instance metadata
+	if fargate == nil || !fargate.SelfOnFargate {
+		sum.IMDS = aws.CheckIMDS(identity, self != nil && self.Spec.HostNetwork)
+	}
+
+	var clusterInfo *aws.ClusterInfo
+	if fargate != nil && fargate.SelfOnFargate {
+		log.Infof("Running on Fargate (%s), using cluster name %q and region %q", fargate.DetectedBy, identity.ClusterName, identity.Region)
+		clusterInfo, err = aws.DiscoverFargateClusterInfo(identity)
+	} else {
+		clusterInfo, err = aws.DiscoverClusterInfo(identity)
+	}
 	if err != nil {
 		log.Errorf("Failed to check EKS cluster resources Reason: %v", err)
 		sum.DiagError = fmt.Sprintf("Failed to check EKS cluster resources Reason: %v", err)
+		//upstream servers can still be tested, /etc/resolv.conf of the node falls back to the link-local resolver
+		if sum.Upstreams, err = checkUpstreams(ns, &sum.Coredns, nil); err != nil {
+			log.Errorf("Failed to check upstream servers of coredns: %v", err)
+		}
+		sum.Coredns.LinkLocal = estimateLinkLocalPPS(sum.Coredns.scrapes, nil)
 		err = sum.printSummary()
 		if err != nil {
 			log.Errorf("Failed to printSummary: %v", err)
@@ -182,8 +290,82 @@ func _main() int {
 		return 1
 	}
 	sum.ClusterInfo = *clusterInfo
+	fargate.evaluateProfiles(clusterInfo.FargateProfiles)
+
+	//Evaluate security groups of every client node and coredns node pair for 53/UDP and 53/TCP,
+	//and the NACLs of the subnets of clients and coredns endpoints
+	var nodeEndpoints []*aws.SGEndpoint
+	if cd.ServiceEndpoints != nil {
+		clients, servers, err := dnsSGEndpoints(cd.ServiceEndpoints)
+		if err != nil {
+			log.Errorf("Failed to collect nodes for security group evaluation: %v", err)
+		} else {
+			nodeEndpoints = clients
+			sum.ClusterInfo.DNSSecurityGroups, err = aws.EvaluateDNSSecurityGroups(clusterInfo.Region, clients, servers)
+			if err != nil {
+				log.Errorf("Failed to evaluate security groups: %v", err)
+			}
+
+			naclClients, naclServers := naclEndpoints(clients, self, cd.ServiceEndpoints)
+			sum.ClusterInfo.DNSNetworkACLs, err = aws.EvaluateDNSNetworkACLs(clusterInfo.Region, clusterInfo.VpcID(), naclClients, naclServers)
+			if err != nil {
+				log.Errorf("Failed to evaluate NACLs: %v", err)
+			}
+		}
+	}
+
+	//DNS attributes and DHCP options set of the cluster VPC
+	sum.ClusterInfo.VPCDNS, err = aws.CheckVPCDNS(clusterInfo.Region, clusterInfo.VpcID())
+	if err != nil {
+		log.Errorf("Failed to check DNS settings of the VPC: %v", err)
+	}
+
+	//Estimate packets per second of every coredns node towards the VPC resolver, limited per ENI
+	sum.Coredns.LinkLocal = estimateLinkLocalPPS(sum.Coredns.scrapes, sum.ClusterInfo.VPCDNS)
+
+	//Route 53 Resolver rules and private hosted zones which answer the configured test domains in the VPC
+	sum.ClusterInfo.Route53, err = aws.CheckRoute53(clusterInfo.Region, clusterInfo.VpcID())
+	if err != nil {
+		log.Errorf("Failed to check Route 53 Resolver rules and private hosted zones: %v", err)
+	}
+	if sum.ClusterInfo.Route53 != nil {
+		sum.ClusterInfo.Route53.Endpoints, err = aws.CheckResolverEndpoints(clusterInfo.Region, sum.ClusterInfo.Route53.Rules)
+		if err != nil {
+			log.Errorf("Failed to check Route 53 Resolver endpoints: %v", err)
+		}
+	}
+	sum.DomainResolution = explainDomainResolution(sum.ClusterInfo.Route53, configuredTestDomains(), sum.Coredns.Dnstest.DnsTestResultForDomains)
+
+	//Query the forward targets of coredns directly and compare with queries through coredns
+	sum.Upstreams, err = checkUpstreams(ns, &sum.Coredns, sum.ClusterInfo.VPCDNS)
+	if err != nil {
+		log.Errorf("Failed to check upstream servers of coredns: %v", err)
+	}
+
+	//Routes of the node, coredns and Resolver endpoint subnets towards the DNS forward targets
+	scopes := routeScopes(nodeEndpoints, cd.ServiceEndpoints, sum.Upstreams, sum.ClusterInfo.Route53, sum.ClusterInfo.VPCDNS)
+	sum.ClusterInfo.RouteTables, err = aws.CheckRouteTables(clusterInfo.Region, clusterInfo.VpcID(), scopes)
+	if err != nil {
+		log.Errorf("Failed to check route tables: %v", err)
+	}
+
+	//VPC interface endpoints and their private DNS, resolved through coredns like pods do
+	sum.ClusterInfo.VPCEndpoints, err = aws.CheckVPCEndpoints(clusterInfo.Region, clusterInfo.VpcID())
+	if err != nil {
+		log.Errorf("Failed to check VPC endpoints: %v", err)
+	}
+	sum.EndpointDNS = resolveVPCEndpoints(cd.ClusterIP, sum.ClusterInfo.VPCEndpoints)
+
+	//Security groups for pods replace node security groups for pods with a branch ENI
+	sum.PodSecurityGroups, err = checkSecurityGroupPolicies(ns, self, clusterInfo.Region)
+	if err != nil {
+		log.Errorf("Failed to check SecurityGroupPolicies: %v", err)
+	}
 	log.Debugf("Printing clusterInfo struct %+v", clusterInfo)
 
+	//Compare EKS managed add-ons with the live coredns, kube-proxy and aws-node objects
+	sum.AddonDrift = compareAddons(ns, clusterInfo.Addons)
+
 	//sum.IsDiagSuccessful = true
 	sum.IsDiagComplete = true
 
@@ -213,10 +395,11 @@ func CreateKubeClient() (*kubernetes.Clientset, error) {
 	}
 
 	//2. Create ClientSet
-	Clientset, err = kubernetes.NewForConfig(config)
+	cs, err := kubernetes.NewForConfig(config)
 	if err != nil {
 		log.Errorf("Failed to create clientset: %s", err)
 		return nil, err
 	}
-	return Clientset, err
+	Clientset = cs
+	return cs, err
 }
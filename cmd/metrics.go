@@ -0,0 +1,241 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	corednsMetricsPort   = "9153"
+	corednsMetricsPath   = "/metrics"
+	metricsScrapeTimeout = 5 * time.Second
+	//metricsScrapeWindow is the time between the two scrapes used to compute rates of counters
+	metricsScrapeWindow = 10 * time.Second
+)
+
+//metricSample is a single sample of the Prometheus text exposition format
+type metricSample struct {
+	name   string
+	labels map[string]string
+	value  float64
+}
+
+//podScrape stores two scrapes of the metrics of a coredns pod, taken metricsScrapeWindow apart
+type podScrape struct {
+	pod    string
+	ip     string
+	node   string
+	first  []metricSample
+	second []metricSample
+	window float64
+	err    error
+}
+
+//parseLabels parses the label set of a sample, e.g. {server="dns://:53",to="10.0.0.2:53"}
+func parseLabels(s string) (map[string]string, error) {
+	labels := make(map[string]string)
+	for len(s) > 0 {
+		s = strings.TrimLeft(s, ", ")
+		if s == "" {
+			break
+		}
+		eq := strings.Index(s, "=")
+		if eq < 0 || len(s) < eq+2 || s[eq+1] != '"' {
+			return nil, fmt.Errorf("malformed label set %q", s)
+		}
+		name := strings.TrimSpace(s[:eq])
+		var value strings.Builder
+		i := eq + 2
+		for ; i < len(s) && s[i] != '"'; i++ {
+			if s[i] == '\\' && i+1 < len(s) {
+				i++
+				switch s[i] {
+				case 'n':
+					value.WriteByte('\n')
+				default:
+					value.WriteByte(s[i])
+				}
+				continue
+			}
+			value.WriteByte(s[i])
+		}
+		if i >= len(s) {
+			return nil, fmt.Errorf("unterminated label value of %s", name)
+		}
+		labels[name] = value.String()
+		s = s[i+1:]
+	}
+	return labels, nil
+}
+
+//parseMetrics parses the Prometheus text exposition format, comments (# HELP, # TYPE) are skipped
+func parseMetrics(r io.Reader) ([]metricSample, error) {
+	samples := make([]metricSample, 0)
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		sample := metricSample{labels: map[string]string{}}
+		rest := line
+		if open := strings.Index(line, "{"); open >= 0 {
+			end := strings.LastIndex(line, "}")
+			if end < open {
+				return nil, fmt.Errorf("malformed sample %q", line)
+			}
+			labels, err := parseLabels(line[open+1 : end])
+			if err != nil {
+				return nil, fmt.Errorf("malformed sample %q: %v", line, err)
+			}
+			sample.name, sample.labels, rest = line[:open], labels, line[end+1:]
+		} else {
+			fields := strings.Fields(line)
+			sample.name, rest = fields[0], strings.TrimPrefix(line, fields[0])
+		}
+		//the value may be followed by a timestamp
+		fields := strings.Fields(rest)
+		if len(fields) == 0 {
+			return nil, fmt.Errorf("sample without value %q", line)
+		}
+		value, err := strconv.ParseFloat(fields[0], 64)
+		if err != nil {
+			return nil, fmt.Errorf("malformed value of sample %q: %v", line, err)
+		}
+		sample.value = value
+		samples = append(samples, sample)
+	}
+	return samples, scanner.Err()
+}
+
+//scrapeMetrics fetches and parses the metrics of a coredns pod
+func scrapeMetrics(ip string) ([]metricSample, error) {
+	client := &http.Client{Timeout: metricsScrapeTimeout}
+	url := "http://" + net.JoinHostPort(ip, corednsMetricsPort) + corednsMetricsPath
+	resp, err := client.Get(url)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to scrape %s: %v", url, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("Failed to scrape %s: HTTP %d", url, resp.StatusCode)
+	}
+	return parseMetrics(resp.Body)
+}
+
+//scrapeCoredns scrapes every coredns endpoint twice, metricsScrapeWindow apart, so that rates can be computed
+func scrapeCoredns(sec *ServiceEndpointsCheck) []podScrape {
+	if sec == nil {
+		return nil
+	}
+	scrapes := make([]podScrape, 0, len(sec.Addresses))
+	//counters are read by coredns when it answers, so the window is the time between the two completed responses
+	scraped := make([]time.Time, 0, len(sec.Addresses))
+	for _, addr := range sec.Addresses {
+		sc := podScrape{pod: addr.Pod, ip: addr.IP, node: addr.Node}
+		if sc.pod == "" {
+			sc.pod = addr.IP
+		}
+		sc.first, sc.err = scrapeMetrics(addr.IP)
+		scraped = append(scraped, time.Now())
+		if sc.err != nil {
+			log.Warnf("Failed to scrape metrics of coredns %s: %v", sc.pod, sc.err)
+		}
+		scrapes = append(scrapes, sc)
+	}
+	if len(scrapes) == 0 {
+		return scrapes
+	}
+
+	log.Infof("Waiting %v to scrape coredns metrics again", metricsScrapeWindow)
+	time.Sleep(metricsScrapeWindow)
+	for i := range scrapes {
+		sc := &scrapes[i]
+		if sc.err != nil {
+			continue
+		}
+		sc.second, sc.err = scrapeMetrics(sc.ip)
+		sc.window = time.Since(scraped[i]).Seconds()
+		if sc.err != nil {
+			log.Warnf("Failed to scrape metrics of coredns %s: %v", sc.pod, sc.err)
+		}
+	}
+	return scrapes
+}
+
+//sumSamples returns the sum of the samples of the metric whose labels are accepted by match (nil accepts all)
+func sumSamples(samples []metricSample, name string, match func(map[string]string) bool) (float64, bool) {
+	total, found := 0.0, false
+	for _, s := range samples {
+		if s.name != name || (match != nil && !match(s.labels)) || math.IsNaN(s.value) {
+			continue
+		}
+		total += s.value
+		found = true
+	}
+	return total, found
+}
+
+//counterIncrease returns the increase between the scrapes of the first counter of names exposed by the pod.
+//Counter resets between the scrapes (coredns restarts) are treated as an increase of the second value.
+func (sc *podScrape) counterIncrease(names []string, match func(map[string]string) bool) (float64, string, bool) {
+	if sc.err != nil || sc.window <= 0 {
+		return 0, "", false
+	}
+	for _, name := range names {
+		second, ok := sumSamples(sc.second, name, match)
+		if !ok {
+			continue
+		}
+		first, _ := sumSamples(sc.first, name, match)
+		delta := second - first
+		if delta < 0 {
+			delta = second
+		}
+		return delta, name, true
+	}
+	return 0, "", false
+}
+
+//counterRate returns the per second rate of the first counter of names exposed by the pod
+func (sc *podScrape) counterRate(names []string, match func(map[string]string) bool) (float64, string, bool) {
+	delta, name, ok := sc.counterIncrease(names, match)
+	if !ok {
+		return 0, "", false
+	}
+	return delta / sc.window, name, true
+}
+
+//increaseByLabel returns the increases between the scrapes of the first counter of names exposed by the pod, grouped by a label
+func (sc *podScrape) increaseByLabel(names []string, label string) map[string]float64 {
+	if sc.err != nil || sc.window <= 0 {
+		return nil
+	}
+	for _, name := range names {
+		second := sumByLabel(sc.second, []string{name}, label)
+		if len(second) == 0 {
+			continue
+		}
+		first := sumByLabel(sc.first, []string{name}, label)
+		res := make(map[string]float64, len(second))
+		for value, total := range second {
+			delta := total - first[value]
+			if delta < 0 {
+				delta = total
+			}
+			res[value] = delta
+		}
+		return res
+	}
+	return nil
+}
@@ -0,0 +1,95 @@
+package main
+
+import (
+	"math"
+	"strings"
+	"testing"
+)
+
+func TestParseMetrics(t *testing.T) {
+	text := `# HELP coredns_dns_requests_total Counter of DNS requests made per zone, protocol and family.
+# TYPE coredns_dns_requests_total counter
+coredns_dns_requests_total{family="1",proto="udp",server="dns://:53",type="A",zone="."} 120
+coredns_dns_requests_total{family="1",proto="tcp",server="dns://:53",type="A",zone="."} 3 1700000000000
+coredns_forward_requests_total{to="10.0.0.2:53"} 40
+coredns_panics_total 0
+coredns_build_info{goversion="go1.20",revision="a\"b",version="1.10.1"} 1
+process_start_time_seconds 1.7e+09
+`
+	samples, err := parseMetrics(strings.NewReader(text))
+	if err != nil {
+		t.Fatalf("parseMetrics returned error: %v", err)
+	}
+	if len(samples) != 6 {
+		t.Fatalf("got %d samples, want 6", len(samples))
+	}
+	if total, _ := sumSamples(samples, "coredns_dns_requests_total", nil); total != 123 {
+		t.Errorf("requests total = %v, want 123 (timestamps are not values)", total)
+	}
+	if to := samples[2].labels["to"]; to != "10.0.0.2:53" {
+		t.Errorf("to label = %q", to)
+	}
+	if rev := samples[4].labels["revision"]; rev != `a"b` {
+		t.Errorf("escaped label value = %q", rev)
+	}
+	if samples[5].value != 1.7e9 {
+		t.Errorf("exponent value = %v", samples[5].value)
+	}
+
+	for _, bad := range []string{
+		`coredns_panics_total{to="10.0.0.2:53} 1`,
+		`coredns_panics_total{to=10.0.0.2} 1`,
+		`coredns_panics_total`,
+		`coredns_panics_total abc`,
+	} {
+		if _, err := parseMetrics(strings.NewReader(bad)); err == nil {
+			t.Errorf("parseMetrics(%q) returned no error", bad)
+		}
+	}
+}
+
+func TestHistogramQuantiles(t *testing.T) {
+	const name = "coredns_dns_request_duration_seconds"
+	tests := []struct {
+		name    string
+		text    string
+		want    *DurationPercentiles
+		wantNil bool
+	}{
+		{"no histogram", `coredns_panics_total 0`, nil, true},
+		{"empty histogram", name + `_bucket{le="0.1"} 0
+` + name + `_bucket{le="+Inf"} 0`, nil, true},
+		{"linear interpolation", name + `_bucket{le="0.1"} 50
+` + name + `_bucket{le="0.2"} 100
+` + name + `_bucket{le="+Inf"} 100`, &DurationPercentiles{P50: 0.1, P90: 0.18, P99: 0.198}, false},
+		{"series are summed", name + `_bucket{server="a",le="1"} 10
+` + name + `_bucket{server="b",le="1"} 10
+` + name + `_bucket{server="a",le="+Inf"} 10
+` + name + `_bucket{server="b",le="+Inf"} 10`, &DurationPercentiles{P50: 0.5, P90: 0.9, P99: 0.99}, false},
+		{"slow requests above the highest bound", name + `_bucket{le="1"} 0
+` + name + `_bucket{le="+Inf"} 10`, &DurationPercentiles{P50: 1, P90: 1, P99: 1}, false},
+	}
+	for _, tt := range tests {
+		samples, err := parseMetrics(strings.NewReader(tt.text))
+		if err != nil {
+			t.Fatalf("%s: parseMetrics returned error: %v", tt.name, err)
+		}
+		got := histogramQuantiles(samples, name)
+		if tt.wantNil {
+			if got != nil {
+				t.Errorf("%s: got %+v, want nil", tt.name, got)
+			}
+			continue
+		}
+		if got == nil {
+			t.Errorf("%s: got nil, want %+v", tt.name, tt.want)
+			continue
+		}
+		for _, q := range []struct{ got, want float64 }{{got.P50, tt.want.P50}, {got.P90, tt.want.P90}, {got.P99, tt.want.P99}} {
+			if math.Abs(q.got-q.want) > 1e-9 {
+				t.Errorf("%s: got %+v, want %+v", tt.name, got, tt.want)
+				break
+			}
+		}
+	}
+}
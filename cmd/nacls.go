@@ -0,0 +1,100 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/joshisumit/eks-dns-troubleshooter/pkg/aws"
+	"github.com/joshisumit/eks-dns-troubleshooter/pkg/findings"
+	v1 "k8s.io/api/core/v1"
+)
+
+//naclEndpoints returns the clients (nodes and the troubleshooter pod) and the coredns endpoints used for the NACL evaluation
+func naclEndpoints(nodes []*aws.SGEndpoint, self *v1.Pod, sec *ServiceEndpointsCheck) ([]*aws.SGEndpoint, []*aws.SGEndpoint) {
+	clients := append([]*aws.SGEndpoint{}, nodes...)
+	if self != nil && self.Status.PodIP != "" && !self.Spec.HostNetwork {
+		clients = append(clients, &aws.SGEndpoint{Node: self.Namespace + "/" + self.Name, IP: self.Status.PodIP})
+	}
+
+	servers := make([]*aws.SGEndpoint, 0)
+	if sec != nil {
+		for _, addr := range sec.Addresses {
+			name := addr.Pod
+			if name == "" {
+				name = addr.IP
+			}
+			servers = append(servers, &aws.SGEndpoint{Node: name, IP: addr.IP})
+		}
+	}
+	return clients, servers
+}
+
+//naclFindings reports DNS flows between subnets which are blocked by a NACL, with the deciding rule of each leg
+func naclFindings(eval *aws.NACLEvaluation) []findings.Finding {
+	res := make([]findings.Finding, 0)
+	if eval == nil {
+		return res
+	}
+	const source = "networkAcls"
+
+	for _, f := range eval.Flows {
+		if f.Allowed && f.Partial {
+			evidence := make([]string, 0)
+			for _, d := range f.Decisions {
+				if d.FullRangeRule != "" {
+					evidence = append(evidence, fmt.Sprintf("%s: 32768-60999 allowed by %s, but %s", d.Leg, d.Rule, d.FullRangeRule))
+				}
+			}
+			evidence = append(evidence, "clients: "+strings.Join(f.Clients, ", "))
+			res = append(res, findings.New("NACL-DNS-EPHEMERAL-PARTIAL", findings.SeverityWarning, source,
+				fmt.Sprintf("NACLs allow 53/%s responses from coredns %s in subnet %s to subnet %s only on part of the ephemeral ports 1024-65535; Linux clients work, clients with another port range (e.g. Windows 49152-65535) may time out", strings.ToUpper(f.Protocol), f.Server, f.ServerSubnet, f.ClientSubnet),
+				"Allow ephemeral ports 1024-65535 for the responses in both NACLs, with rule numbers lower than any matching deny rule.",
+				evidence...))
+		}
+		if f.Allowed {
+			continue
+		}
+		evidence := make([]string, 0)
+		legs := make([]string, 0)
+		for _, d := range f.Decisions {
+			verdict := "allowed"
+			if !d.Allowed {
+				verdict = "BLOCKED"
+				legs = append(legs, d.Leg)
+			}
+			evidence = append(evidence, fmt.Sprintf("%s: %s by %s", d.Leg, verdict, d.Rule))
+		}
+		evidence = append(evidence, "clients: "+strings.Join(f.Clients, ", "))
+		res = append(res, findings.New("NACL-DNS-BLOCKED", findings.SeverityCritical, source,
+			fmt.Sprintf("NACLs block 53/%s from subnet %s to coredns %s in subnet %s (%s)", strings.ToUpper(f.Protocol), f.ClientSubnet, f.Server, f.ServerSubnet, strings.Join(legs, ", ")),
+			"NACLs are stateless and evaluated in rule number order: allow port 53 (UDP and TCP) towards the coredns subnets and ephemeral ports 1024-65535 back, in both NACLs, with rule numbers lower than any matching deny rule.",
+			evidence...))
+	}
+	if len(eval.Errors) > 0 {
+		res = append(res, findings.New("NACL-EVALUATION-INCOMPLETE", findings.SeverityInfo, source,
+			"Some endpoints could not be mapped to subnets or NACLs, the NACL results may be incomplete",
+			"Grant ec2:DescribeSubnets and ec2:DescribeNetworkAcls to the troubleshooter.",
+			eval.Errors...))
+	}
+	return res
+}
+
+//naclVerdict summarises the NACL evaluation of DNS flows for the analysis section of the report
+func naclVerdict(eval *aws.NACLEvaluation) string {
+	if eval == nil {
+		return "naclRules were not evaluated...see diagnosis logs"
+	}
+	blocked := 0
+	for _, f := range eval.Flows {
+		if !f.Allowed {
+			blocked++
+		}
+	}
+	switch {
+	case blocked > 0:
+		return fmt.Sprintf("naclRules are NOT configured correctly...blocking %d DNS flows between client and coredns subnets", blocked)
+	case len(eval.Errors) > 0:
+		return "naclRules are NOT blocking any evaluated DNS communication...evaluation is incomplete"
+	}
+	return "naclRules are configured correctly...NOT blocking any DNS communication"
+}
@@ -0,0 +1,343 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"strings"
+
+	"github.com/joshisumit/eks-dns-troubleshooter/pkg/findings"
+	log "github.com/sirupsen/logrus"
+	v1 "k8s.io/api/core/v1"
+	networkingv1 "k8s.io/api/networking/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/util/intstr"
+)
+
+const (
+	//envNetpolTargets is a comma separated list of namespaces or namespace/pod whose DNS traffic is evaluated
+	envNetpolTargets = "EKS_DNS_NETPOL_TARGETS"
+	//maxNetpolPodsPerNamespace limits the number of pods evaluated for a namespace target
+	maxNetpolPodsPerNamespace = 50
+	dnsPort                   = 53
+
+	decisionNotIsolated = "not isolated"
+	decisionAllowed     = "allowed"
+	decisionBlocked     = "blocked"
+)
+
+//NetworkPolicyCheck stores the evaluation of NetworkPolicies for DNS traffic between clients and coredns pods
+type NetworkPolicyCheck struct {
+	PoliciesCount int               `json:"policiesCount"`
+	Flows         []NetpolDNSFlow   `json:"flows,omitempty"`
+	Summary       map[string]string `json:"summary,omitempty"`
+}
+
+//NetpolDNSFlow is the decision for one client pod -> coredns pod flow on 53/UDP or 53/TCP
+type NetpolDNSFlow struct {
+	Client           string   `json:"client"`
+	CorednsPod       string   `json:"corednsPod"`
+	Protocol         string   `json:"protocol"`
+	EgressDecision   string   `json:"egressDecision"`
+	EgressAllowedBy  string   `json:"egressAllowedBy,omitempty"`
+	EgressBlockedBy  []string `json:"egressBlockedBy,omitempty"`
+	IngressDecision  string   `json:"ingressDecision"`
+	IngressAllowedBy string   `json:"ingressAllowedBy,omitempty"`
+	IngressBlockedBy []string `json:"ingressBlockedBy,omitempty"`
+}
+
+//netpolEvaluator evaluates NetworkPolicies (networking.k8s.io/v1) for pod to pod traffic
+type netpolEvaluator struct {
+	policies []networkingv1.NetworkPolicy
+	nsLabels map[string]labels.Set
+}
+
+//policyTypes returns the effective policy types, Egress is implied when egress rules exist
+func policyTypes(pol *networkingv1.NetworkPolicy) map[networkingv1.PolicyType]bool {
+	types := make(map[networkingv1.PolicyType]bool)
+	if len(pol.Spec.PolicyTypes) == 0 {
+		types[networkingv1.PolicyTypeIngress] = true
+		if len(pol.Spec.Egress) > 0 {
+			types[networkingv1.PolicyTypeEgress] = true
+		}
+		return types
+	}
+	for _, t := range pol.Spec.PolicyTypes {
+		types[t] = true
+	}
+	return types
+}
+
+//selectsPod returns true if the policy applies to the pod
+func selectsPod(pol *networkingv1.NetworkPolicy, pod *v1.Pod) bool {
+	if pol.Namespace != pod.Namespace {
+		return false
+	}
+	selector, err := metav1.LabelSelectorAsSelector(&pol.Spec.PodSelector)
+	if err != nil {
+		return false
+	}
+	return selector.Matches(labels.Set(pod.Labels))
+}
+
+//containerPort resolves a named port of a pod
+func containerPort(pod *v1.Pod, name string, protocol v1.Protocol) (int32, bool) {
+	for _, c := range pod.Spec.Containers {
+		for _, p := range c.Ports {
+			if p.Name == name && p.Protocol == protocol {
+				return p.ContainerPort, true
+			}
+		}
+	}
+	return 0, false
+}
+
+//portsMatch returns true if the rule ports allow protocol/port on the destination pod
+func portsMatch(ports []networkingv1.NetworkPolicyPort, protocol v1.Protocol, port int32, dst *v1.Pod) bool {
+	if len(ports) == 0 {
+		return true
+	}
+	for _, p := range ports {
+		proto := v1.ProtocolTCP
+		if p.Protocol != nil {
+			proto = *p.Protocol
+		}
+		if proto != protocol {
+			continue
+		}
+		if p.Port == nil {
+			return true
+		}
+		if p.Port.Type == intstr.Int && p.Port.IntVal == port {
+			return true
+		}
+		if p.Port.Type == intstr.String {
+			if resolved, ok := containerPort(dst, p.Port.StrVal, protocol); ok && resolved == port {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+//peerMatches returns true if the peer of a rule of a policy in policyNS matches the other pod
+func (e *netpolEvaluator) peerMatches(peer networkingv1.NetworkPolicyPeer, policyNS string, other *v1.Pod) bool {
+	if peer.IPBlock != nil {
+		ip := net.ParseIP(other.Status.PodIP)
+		_, cidr, err := net.ParseCIDR(peer.IPBlock.CIDR)
+		if ip == nil || err != nil || !cidr.Contains(ip) {
+			return false
+		}
+		for _, except := range peer.IPBlock.Except {
+			if _, exceptCidr, err := net.ParseCIDR(except); err == nil && exceptCidr.Contains(ip) {
+				return false
+			}
+		}
+		return true
+	}
+
+	if peer.NamespaceSelector != nil {
+		nsSelector, err := metav1.LabelSelectorAsSelector(peer.NamespaceSelector)
+		if err != nil || !nsSelector.Matches(e.nsLabels[other.Namespace]) {
+			return false
+		}
+	} else if other.Namespace != policyNS {
+		//podSelector alone selects pods in the namespace of the policy
+		return false
+	}
+
+	if peer.PodSelector != nil {
+		podSelector, err := metav1.LabelSelectorAsSelector(peer.PodSelector)
+		if err != nil || !podSelector.Matches(labels.Set(other.Labels)) {
+			return false
+		}
+	}
+	return true
+}
+
+//evaluate decides if traffic is allowed for the subject pod in the given direction.
+//For egress the subject is the client and other is the coredns pod, for ingress it is the other way around.
+func (e *netpolEvaluator) evaluate(direction networkingv1.PolicyType, subject, other *v1.Pod, protocol v1.Protocol, port int32) (string, string, []string) {
+	dst := other
+	if direction == networkingv1.PolicyTypeIngress {
+		dst = subject
+	}
+
+	isolating := make([]string, 0)
+	for i := range e.policies {
+		pol := &e.policies[i]
+		if !selectsPod(pol, subject) || !policyTypes(pol)[direction] {
+			continue
+		}
+		name := pol.Namespace + "/" + pol.Name
+		isolating = append(isolating, name)
+
+		if direction == networkingv1.PolicyTypeEgress {
+			for _, rule := range pol.Spec.Egress {
+				if portsMatch(rule.Ports, protocol, port, dst) && e.peersMatch(rule.To, pol.Namespace, other) {
+					return decisionAllowed, name, nil
+				}
+			}
+		} else {
+			for _, rule := range pol.Spec.Ingress {
+				if portsMatch(rule.Ports, protocol, port, dst) && e.peersMatch(rule.From, pol.Namespace, other) {
+					return decisionAllowed, name, nil
+				}
+			}
+		}
+	}
+
+	if len(isolating) == 0 {
+		return decisionNotIsolated, "", nil
+	}
+	return decisionBlocked, "", isolating
+}
+
+//peersMatch returns true if one of the peers matches, an empty list matches everything
+func (e *netpolEvaluator) peersMatch(peers []networkingv1.NetworkPolicyPeer, policyNS string, other *v1.Pod) bool {
+	if len(peers) == 0 {
+		return true
+	}
+	for _, peer := range peers {
+		if e.peerMatches(peer, policyNS, other) {
+			return true
+		}
+	}
+	return false
+}
+
+//netpolTargets returns the client pods to evaluate: the troubleshooter itself and the targets from envNetpolTargets
+func netpolTargets(self *v1.Pod) []v1.Pod {
+	targets := make([]v1.Pod, 0)
+	if self != nil {
+		targets = append(targets, *self)
+	}
+
+	for _, t := range strings.Split(os.Getenv(envNetpolTargets), ",") {
+		t = strings.TrimSpace(t)
+		if t == "" {
+			continue
+		}
+		parts := strings.SplitN(t, "/", 2)
+		if len(parts) == 2 {
+			pod, err := Clientset.CoreV1().Pods(parts[0]).Get(parts[1], metav1.GetOptions{})
+			if err != nil {
+				log.Warnf("Skipping NetworkPolicy target %s: %v", t, err)
+				continue
+			}
+			targets = append(targets, *pod)
+			continue
+		}
+		pods, err := Clientset.CoreV1().Pods(t).List(metav1.ListOptions{})
+		if err != nil {
+			log.Warnf("Skipping NetworkPolicy target namespace %s: %v", t, err)
+			continue
+		}
+		for i, pod := range pods.Items {
+			if i >= maxNetpolPodsPerNamespace {
+				log.Warnf("Evaluating only the first %d pods of namespace %s", maxNetpolPodsPerNamespace, t)
+				break
+			}
+			targets = append(targets, pod)
+		}
+	}
+	return targets
+}
+
+//checkNetworkPolicies evaluates whether NetworkPolicies allow DNS (53/UDP and 53/TCP) from the target pods to the coredns pods
+func checkNetworkPolicies(ns string, self *v1.Pod) (*NetworkPolicyCheck, error) {
+	polList, err := Clientset.NetworkingV1().NetworkPolicies(metav1.NamespaceAll).List(metav1.ListOptions{})
+	if err != nil {
+		log.Errorf("Failed to list NetworkPolicies: %v", err)
+		return nil, fmt.Errorf("Failed to list NetworkPolicies: %v", err)
+	}
+	check := &NetworkPolicyCheck{PoliciesCount: len(polList.Items), Summary: make(map[string]string)}
+	if len(polList.Items) == 0 {
+		log.Infof("No NetworkPolicies found in the cluster")
+		return check, nil
+	}
+
+	nsList, err := Clientset.CoreV1().Namespaces().List(metav1.ListOptions{})
+	if err != nil {
+		return check, fmt.Errorf("Failed to list namespaces: %v", err)
+	}
+	e := &netpolEvaluator{policies: polList.Items, nsLabels: make(map[string]labels.Set)}
+	for _, namespace := range nsList.Items {
+		e.nsLabels[namespace.Name] = labels.Set(namespace.Labels)
+	}
+
+	dep, err := Clientset.AppsV1().Deployments(ns).Get("coredns", metav1.GetOptions{})
+	if err != nil {
+		return check, fmt.Errorf("Failed to get coredns deployment: %v", err)
+	}
+	corednsPods, err := getDeploymentPods(dep)
+	if err != nil {
+		return check, err
+	}
+
+	for _, client := range netpolTargets(self) {
+		client := client
+		blocked := 0
+		for i := range corednsPods {
+			server := &corednsPods[i]
+			for _, protocol := range []v1.Protocol{v1.ProtocolUDP, v1.ProtocolTCP} {
+				flow := NetpolDNSFlow{
+					Client:     client.Namespace + "/" + client.Name,
+					CorednsPod: server.Name,
+					Protocol:   string(protocol),
+				}
+				flow.EgressDecision, flow.EgressAllowedBy, flow.EgressBlockedBy = e.evaluate(networkingv1.PolicyTypeEgress, &client, server, protocol, dnsPort)
+				flow.IngressDecision, flow.IngressAllowedBy, flow.IngressBlockedBy = e.evaluate(networkingv1.PolicyTypeIngress, server, &client, protocol, dnsPort)
+				if flow.EgressDecision == decisionBlocked || flow.IngressDecision == decisionBlocked {
+					blocked++
+				}
+				check.Flows = append(check.Flows, flow)
+			}
+		}
+		if blocked == 0 {
+			check.Summary[client.Namespace+"/"+client.Name] = "DNS to coredns is allowed by NetworkPolicies"
+		} else {
+			check.Summary[client.Namespace+"/"+client.Name] = fmt.Sprintf("%d of %d DNS flows to coredns are blocked by NetworkPolicies", blocked, 2*len(corednsPods))
+		}
+	}
+
+	log.Infof("NetworkPolicy check: %+v", check.Summary)
+	return check, nil
+}
+
+//networkPolicyFindings reports DNS flows blocked by NetworkPolicies, naming the blocking policies
+func networkPolicyFindings(check *NetworkPolicyCheck) []findings.Finding {
+	res := make([]findings.Finding, 0)
+	if check == nil {
+		return res
+	}
+	const source = "networkPolicies"
+
+	egress, ingress := make(map[string][]string), make(map[string][]string)
+	for _, f := range check.Flows {
+		flow := fmt.Sprintf("%s -> %s %d/%s", f.Client, f.CorednsPod, dnsPort, f.Protocol)
+		if f.EgressDecision == decisionBlocked {
+			key := strings.Join(f.EgressBlockedBy, ", ")
+			egress[key] = append(egress[key], flow)
+		}
+		if f.IngressDecision == decisionBlocked {
+			key := strings.Join(f.IngressBlockedBy, ", ")
+			ingress[key] = append(ingress[key], flow)
+		}
+	}
+
+	for policies, flows := range egress {
+		res = append(res, findings.New("NETPOL-DNS-EGRESS-BLOCKED", findings.SeverityCritical, source,
+			fmt.Sprintf("NetworkPolicies %s isolate the client pods for egress and none of them allows DNS to the coredns pods in kube-system", policies),
+			"Add an egress rule allowing 53/UDP and 53/TCP to pods with label k8s-app=kube-dns in namespace kube-system (namespaceSelector kubernetes.io/metadata.name: kube-system).",
+			flows...))
+	}
+	for policies, flows := range ingress {
+		res = append(res, findings.New("NETPOL-DNS-INGRESS-BLOCKED", findings.SeverityCritical, source,
+			fmt.Sprintf("NetworkPolicies %s isolate the coredns pods for ingress and none of them allows DNS from the client pods", policies),
+			"Add an ingress rule to the coredns policy allowing 53/UDP and 53/TCP from all namespaces (namespaceSelector: {}).",
+			flows...))
+	}
+	return res
+}
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"testing"
+
+	v1 "k8s.io/api/core/v1"
+	networkingv1 "k8s.io/api/networking/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/util/intstr"
+)
+
+func testPod(ns, name, ip string, podLabels map[string]string) *v1.Pod {
+	return &v1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Namespace: ns, Name: name, Labels: podLabels},
+		Status:     v1.PodStatus{PodIP: ip},
+	}
+}
+
+func TestPortsMatch(t *testing.T) {
+	udp, tcp := v1.ProtocolUDP, v1.ProtocolTCP
+	port53, named := intstr.FromInt(53), intstr.FromString("dns")
+	coredns := testPod("kube-system", "coredns", "10.0.1.10", nil)
+	coredns.Spec.Containers = []v1.Container{{Ports: []v1.ContainerPort{{Name: "dns", ContainerPort: 53, Protocol: v1.ProtocolUDP}}}}
+
+	tests := []struct {
+		name  string
+		ports []networkingv1.NetworkPolicyPort
+		want  bool
+	}{
+		{"no ports", nil, true},
+		{"udp 53", []networkingv1.NetworkPolicyPort{{Protocol: &udp, Port: &port53}}, true},
+		{"tcp 53", []networkingv1.NetworkPolicyPort{{Protocol: &tcp, Port: &port53}}, false},
+		{"default protocol is tcp", []networkingv1.NetworkPolicyPort{{Port: &port53}}, false},
+		{"all udp ports", []networkingv1.NetworkPolicyPort{{Protocol: &udp}}, true},
+		{"named port", []networkingv1.NetworkPolicyPort{{Protocol: &udp, Port: &named}}, true},
+	}
+	for _, tt := range tests {
+		if got := portsMatch(tt.ports, v1.ProtocolUDP, dnsPort, coredns); got != tt.want {
+			t.Errorf("%s: portsMatch = %t, want %t", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestNetpolEvaluate(t *testing.T) {
+	udp := v1.ProtocolUDP
+	port53 := intstr.FromInt(53)
+	client := testPod("app", "web", "10.0.2.20", map[string]string{"app": "web"})
+	coredns := testPod("kube-system", "coredns", "10.0.1.10", map[string]string{"k8s-app": "kube-dns"})
+	e := &netpolEvaluator{nsLabels: map[string]labels.Set{
+		"app":         {"name": "app"},
+		"kube-system": {"kubernetes.io/metadata.name": "kube-system"},
+	}}
+	toKubeSystem := networkingv1.NetworkPolicyPeer{
+		NamespaceSelector: &metav1.LabelSelector{MatchLabels: map[string]string{"kubernetes.io/metadata.name": "kube-system"}},
+		PodSelector:       &metav1.LabelSelector{MatchLabels: map[string]string{"k8s-app": "kube-dns"}},
+	}
+	egress := func(name string, peers ...networkingv1.NetworkPolicyPeer) networkingv1.NetworkPolicy {
+		return networkingv1.NetworkPolicy{
+			ObjectMeta: metav1.ObjectMeta{Namespace: "app", Name: name},
+			Spec: networkingv1.NetworkPolicySpec{
+				PolicyTypes: []networkingv1.PolicyType{networkingv1.PolicyTypeEgress},
+				Egress:      []networkingv1.NetworkPolicyEgressRule{{Ports: []networkingv1.NetworkPolicyPort{{Protocol: &udp, Port: &port53}}, To: peers}},
+			},
+		}
+	}
+	denyAll := networkingv1.NetworkPolicy{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "app", Name: "deny-all"},
+		Spec:       networkingv1.NetworkPolicySpec{PolicyTypes: []networkingv1.PolicyType{networkingv1.PolicyTypeEgress}},
+	}
+	podSelectorOnly := networkingv1.NetworkPolicyPeer{PodSelector: &metav1.LabelSelector{MatchLabels: map[string]string{"k8s-app": "kube-dns"}}}
+	ipBlock := networkingv1.NetworkPolicyPeer{IPBlock: &networkingv1.IPBlock{CIDR: "10.0.0.0/16", Except: []string{"10.0.1.0/24"}}}
+
+	tests := []struct {
+		name     string
+		policies []networkingv1.NetworkPolicy
+		want     string
+	}{
+		{"no policy", nil, decisionNotIsolated},
+		{"deny all", []networkingv1.NetworkPolicy{denyAll}, decisionBlocked},
+		{"allow to kube-dns", []networkingv1.NetworkPolicy{denyAll, egress("allow-dns", toKubeSystem)}, decisionAllowed},
+		{"pod selector only matches the policy namespace", []networkingv1.NetworkPolicy{denyAll, egress("allow-dns", podSelectorOnly)}, decisionBlocked},
+		{"ipBlock except", []networkingv1.NetworkPolicy{denyAll, egress("allow-vpc", ipBlock)}, decisionBlocked},
+		{"empty peers allow everything", []networkingv1.NetworkPolicy{egress("allow-any")}, decisionAllowed},
+	}
+	for _, tt := range tests {
+		e.policies = tt.policies
+		if got, _, _ := e.evaluate(networkingv1.PolicyTypeEgress, client, coredns, v1.ProtocolUDP, dnsPort); got != tt.want {
+			t.Errorf("%s: evaluate = %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
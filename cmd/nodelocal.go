@@ -0,0 +1,323 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/joshisumit/eks-dns-troubleshooter/pkg/findings"
+	log "github.com/sirupsen/logrus"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+)
+
+const (
+	nodeLocalDNSIP         = "169.254.20.10"
+	nodeLocalDNSName       = "node-local-dns"
+	kubeDNSUpstreamService = "kube-dns-upstream"
+	//pillarClusterDNS is replaced by node-cache with the ClusterIP of kube-dns-upstream at runtime
+	pillarClusterDNS = "__PILLAR__CLUSTER__DNS__"
+
+	serverTypeNodeLocal = "nodeLocalDNSCache"
+	serverTypeBypass    = "bypassNodeLocalDNSCache"
+
+	labelComputeType = "eks.amazonaws.com/compute-type"
+	computeFargate   = "fargate"
+
+	clusterDNSFromConfigz    = "kubelet configz"
+	clusterDNSFromResolvConf = "troubleshooter pod resolv.conf"
+)
+
+//NodeLocalDNSCheck stores the validation of NodeLocal DNSCache
+type NodeLocalDNSCheck struct {
+	Enabled                bool                     `json:"enabled"`
+	DesiredNumberScheduled int32                    `json:"desiredNumberScheduled"`
+	NumberReady            int32                    `json:"numberReady"`
+	UncoveredNodes         []string                 `json:"uncoveredNodes,omitempty"`
+	BindIPs                []string                 `json:"bindIPs,omitempty"`
+	ClusterDomainForward   []string                 `json:"clusterDomainForward,omitempty"`
+	UpstreamForward        []string                 `json:"upstreamForward,omitempty"`
+	UpstreamService        *UpstreamServiceCheck    `json:"kubeDNSUpstreamService,omitempty"`
+	KubeletClusterDNS      []string                 `json:"kubeletClusterDNS,omitempty"`
+	KubeletClusterDNSFrom  string                   `json:"kubeletClusterDNSSource,omitempty"`
+	KubeletNode            string                   `json:"kubeletNode,omitempty"`
+	ResolutionComparison   []DnsTestResultForDomain `json:"resolutionComparison,omitempty"`
+}
+
+//UpstreamServiceCheck stores details of kube-dns-upstream service which NodeLocal DNSCache forwards cluster names to
+type UpstreamServiceCheck struct {
+	Exists          bool   `json:"exists"`
+	ClusterIP       string `json:"clusterIP,omitempty"`
+	SelectorMatches bool   `json:"selectorMatchesCorednsPods"`
+	ReadyEndpoints  int    `json:"readyEndpoints"`
+}
+
+//getKubeletClusterDNS reads clusterDNS from the kubelet configuration of a node through the API server proxy.
+//This needs get on nodes/proxy, which is only granted by the optional deploy/rbac-role-kubelet-config.yaml.
+func getKubeletClusterDNS(nodeName string) ([]string, error) {
+	raw, err := Clientset.CoreV1().RESTClient().Get().
+		Resource("nodes").Name(nodeName).SubResource("proxy").Suffix("configz").
+		DoRaw()
+	if err != nil {
+		return nil, fmt.Errorf("Failed to read kubelet configz of node %s: %v", nodeName, err)
+	}
+	configz := struct {
+		KubeletConfig struct {
+			ClusterDNS []string `json:"clusterDNS"`
+		} `json:"kubeletconfig"`
+	}{}
+	if err := json.Unmarshal(raw, &configz); err != nil {
+		return nil, fmt.Errorf("Failed to decode kubelet configz of node %s: %v", nodeName, err)
+	}
+	return configz.KubeletConfig.ClusterDNS, nil
+}
+
+//usesClusterDNS returns true if kubelet configures the pod with the --cluster-dns nameserver
+func usesClusterDNS(pod *v1.Pod) bool {
+	switch pod.Spec.DNSPolicy {
+	case v1.DNSClusterFirst, "":
+		return !pod.Spec.HostNetwork
+	case v1.DNSClusterFirstWithHostNet:
+		return true
+	}
+	return false
+}
+
+//checkUpstreamService validates kube-dns-upstream service
+func checkUpstreamService(ns string) *UpstreamServiceCheck {
+	us := &UpstreamServiceCheck{}
+	svc, err := Clientset.CoreV1().Services(ns).Get(kubeDNSUpstreamService, metav1.GetOptions{})
+	if err != nil {
+		log.Warnf("%s service does not exist: %v", kubeDNSUpstreamService, err)
+		return us
+	}
+	us.Exists, us.ClusterIP = true, svc.Spec.ClusterIP
+
+	if dep, err := Clientset.AppsV1().Deployments(ns).Get("coredns", metav1.GetOptions{}); err == nil && len(svc.Spec.Selector) != 0 {
+		us.SelectorMatches = labels.SelectorFromSet(svc.Spec.Selector).Matches(labels.Set(dep.Spec.Template.Labels))
+	}
+	if ep, err := Clientset.CoreV1().Endpoints(ns).Get(kubeDNSUpstreamService, metav1.GetOptions{}); err == nil {
+		for _, subset := range ep.Subsets {
+			us.ReadyEndpoints += len(subset.Addresses)
+		}
+	}
+	return us
+}
+
+//checkNodeLocalDNSCache validates the node-local-dns DaemonSet, its Corefile, the kube-dns-upstream service, kubelet --cluster-dns
+//and compares resolution through NodeLocal DNSCache with resolution which bypasses it
+func checkNodeLocalDNSCache(ns string, cd *Coredns, self *v1.Pod) (*NodeLocalDNSCheck, error) {
+	nl := &NodeLocalDNSCheck{}
+
+	//1. kubelet --cluster-dns of the node where the troubleshooter runs (checked even if NodeLocal DNSCache is not installed).
+	//Without access to the kubelet configz, the nameserver kubelet wrote into the resolv.conf of this ClusterFirst pod is used.
+	if self != nil && self.Spec.NodeName != "" {
+		nl.KubeletNode = self.Spec.NodeName
+		clusterDNS, err := getKubeletClusterDNS(self.Spec.NodeName)
+		if err != nil {
+			log.Warnf("%v", err)
+		}
+		if len(clusterDNS) > 0 {
+			nl.KubeletClusterDNS, nl.KubeletClusterDNSFrom = clusterDNS, clusterDNSFromConfigz
+		} else if usesClusterDNS(self) && len(cd.ResolvConf.Nameserver) > 0 {
+			nl.KubeletClusterDNS, nl.KubeletClusterDNSFrom = cd.ResolvConf.Nameserver[:1], clusterDNSFromResolvConf
+		}
+	}
+
+	//2. DaemonSet and nodes covered by it
+	ds, err := Clientset.AppsV1().DaemonSets(ns).Get(nodeLocalDNSName, metav1.GetOptions{})
+	if errors.IsNotFound(err) {
+		log.Infof("NodeLocal DNSCache (%s daemonset) is not installed", nodeLocalDNSName)
+		return nl, nil
+	} else if err != nil {
+		return nl, fmt.Errorf("Failed to get %s daemonset: %v", nodeLocalDNSName, err)
+	}
+	nl.Enabled = true
+	nl.DesiredNumberScheduled, nl.NumberReady = ds.Status.DesiredNumberScheduled, ds.Status.NumberReady
+
+	selector, err := metav1.LabelSelectorAsSelector(ds.Spec.Selector)
+	if err != nil {
+		return nl, fmt.Errorf("invalid selector of %s daemonset: %v", nodeLocalDNSName, err)
+	}
+	pods, err := Clientset.CoreV1().Pods(ns).List(metav1.ListOptions{LabelSelector: selector.String()})
+	if err != nil {
+		return nl, fmt.Errorf("Failed to list %s pods: %v", nodeLocalDNSName, err)
+	}
+	covered := make(map[string]bool)
+	for _, pod := range pods.Items {
+		covered[pod.Spec.NodeName] = true
+	}
+	nodes, err := Clientset.CoreV1().Nodes().List(metav1.ListOptions{})
+	if err != nil {
+		return nl, fmt.Errorf("Failed to list nodes: %v", err)
+	}
+	for _, node := range nodes.Items {
+		//daemonsets do not run on Fargate
+		if node.Labels[labelComputeType] == computeFargate {
+			continue
+		}
+		if !covered[node.Name] {
+			nl.UncoveredNodes = append(nl.UncoveredNodes, node.Name)
+		}
+	}
+
+	//3. Corefile of node-local-dns: bind IPs and forward targets
+	cm, err := Clientset.CoreV1().ConfigMaps(ns).Get(nodeLocalDNSName, metav1.GetOptions{})
+	if err != nil {
+		log.Warnf("Failed to get %s configmap: %v", nodeLocalDNSName, err)
+	} else {
+		blocks, err := parseServerBlocks(nodeLocalDNSName, cm.Data["Corefile"])
+		if err != nil {
+			log.Warnf("%v", err)
+		}
+		clusterDomain := cd.ResolvConf.clusterDomain()
+		for _, block := range blocks {
+			for _, args := range directiveArgs(block, "bind") {
+				nl.BindIPs = appendUnique(nl.BindIPs, args...)
+			}
+			for _, args := range directiveArgs(block, "forward") {
+				if len(args) < 2 {
+					continue
+				}
+				for _, key := range block.Keys {
+					if isClusterName(zoneOfKey(key), clusterDomain) {
+						nl.ClusterDomainForward = appendUnique(nl.ClusterDomainForward, args[1:]...)
+					} else if zoneOfKey(key) == "." {
+						nl.UpstreamForward = appendUnique(nl.UpstreamForward, args[1:]...)
+					}
+				}
+			}
+		}
+	}
+
+	//4. kube-dns-upstream service used to reach coredns without going through the ClusterIP bound by node-local-dns
+	nl.UpstreamService = checkUpstreamService(ns)
+
+	//5. resolution through NodeLocal DNSCache compared with resolution bypassing it
+	bypass := nl.UpstreamService.ClusterIP
+	if bypass == "" && len(cd.EndpointsIP) > 0 {
+		bypass = cd.EndpointsIP[0]
+	}
+	for _, dom := range cd.Dnstest.DomainsTested {
+		res := lookupIP(dom, nodeLocalDNSIP, transportUDP)
+		res.ServerType = serverTypeNodeLocal
+		nl.ResolutionComparison = append(nl.ResolutionComparison, *res)
+		if bypass != "" {
+			res = lookupIP(dom, bypass, transportUDP)
+			res.ServerType = serverTypeBypass
+			nl.ResolutionComparison = append(nl.ResolutionComparison, *res)
+		}
+	}
+
+	log.Infof("NodeLocal DNSCache check: %+v", nl)
+	return nl, nil
+}
+
+//zoneOfKey returns the zone of a server block key, e.g. "cluster.local:53" => "cluster.local"
+func zoneOfKey(key string) string {
+	for i := len(key) - 1; i >= 0; i-- {
+		if key[i] == ':' {
+			return key[:i]
+		}
+	}
+	return key
+}
+
+//appendUnique appends values which are not present in the slice yet
+func appendUnique(slice []string, values ...string) []string {
+	for _, v := range values {
+		found := false
+		for _, s := range slice {
+			if s == v {
+				found = true
+				break
+			}
+		}
+		if !found {
+			slice = append(slice, v)
+		}
+	}
+	return slice
+}
+
+func containsString(slice []string, value string) bool {
+	for _, s := range slice {
+		if s == value {
+			return true
+		}
+	}
+	return false
+}
+
+//nodeLocalDNSFindings reports problems of NodeLocal DNSCache and of kubelet --cluster-dns
+func nodeLocalDNSFindings(nl *NodeLocalDNSCheck, clusterIP string) []findings.Finding {
+	res := make([]findings.Finding, 0)
+	if nl == nil {
+		return res
+	}
+	const source = "nodeLocalDNSCache"
+
+	kubeletEvidence := fmt.Sprintf("node=%s clusterDNS=%v (from %s)", nl.KubeletNode, nl.KubeletClusterDNS, nl.KubeletClusterDNSFrom)
+	if len(nl.KubeletClusterDNS) > 0 {
+		clusterDNS := nl.KubeletClusterDNS[0]
+		switch {
+		case !nl.Enabled && clusterDNS == nodeLocalDNSIP:
+			res = append(res, findings.New("NODELOCAL-KUBELET-WITHOUT-CACHE", findings.SeverityCritical, source,
+				fmt.Sprintf("kubelet --cluster-dns is set to %s but NodeLocal DNSCache is not installed, pods on this node have no working nameserver", nodeLocalDNSIP),
+				fmt.Sprintf("Install NodeLocal DNSCache or set kubelet --cluster-dns (clusterDNS) back to %s.", clusterIP), kubeletEvidence))
+		case nl.Enabled && clusterDNS != nodeLocalDNSIP && !containsString(nl.BindIPs, clusterDNS):
+			res = append(res, findings.New("NODELOCAL-KUBELET-BYPASS", findings.SeverityWarning, source,
+				fmt.Sprintf("NodeLocal DNSCache is installed but kubelet --cluster-dns is %s, which node-local-dns does not listen on, so pods bypass the cache", clusterDNS),
+				fmt.Sprintf("Set kubelet --cluster-dns to %s, or bind node-local-dns to the kube-dns ClusterIP as well.", nodeLocalDNSIP), kubeletEvidence))
+		case clusterDNS != nodeLocalDNSIP && clusterDNS != clusterIP:
+			res = append(res, findings.New("KUBELET-CLUSTER-DNS-MISMATCH", findings.SeverityCritical, source,
+				fmt.Sprintf("kubelet --cluster-dns is %s, which is neither the kube-dns ClusterIP %s nor the NodeLocal DNSCache IP %s", clusterDNS, clusterIP, nodeLocalDNSIP),
+				"Fix --cluster-dns of kubelet (clusterDNS in the kubelet config or the bootstrap.sh --dns-cluster-ip argument).", kubeletEvidence))
+		}
+	}
+	if !nl.Enabled {
+		return res
+	}
+
+	if len(nl.UncoveredNodes) != 0 || nl.NumberReady < nl.DesiredNumberScheduled {
+		res = append(res, findings.New("NODELOCAL-NOT-ON-ALL-NODES", findings.SeverityCritical, source,
+			fmt.Sprintf("node-local-dns is not running and ready on every node (%d ready of %d desired, %d nodes without a pod), pods on these nodes cannot resolve names when kubelet points them to %s", nl.NumberReady, nl.DesiredNumberScheduled, len(nl.UncoveredNodes), nodeLocalDNSIP),
+			"Check tolerations and nodeSelector of the node-local-dns daemonset and the status of its pods.",
+			nl.UncoveredNodes...))
+	}
+
+	us := nl.UpstreamService
+	if us != nil && (!us.Exists || !us.SelectorMatches || us.ReadyEndpoints == 0) {
+		res = append(res, findings.New("NODELOCAL-UPSTREAM-SERVICE", findings.SeverityCritical, source,
+			fmt.Sprintf("%s service which node-local-dns uses to reach coredns is missing, does not select the coredns pods or has no ready endpoints, cache misses for cluster names fail", kubeDNSUpstreamService),
+			fmt.Sprintf("Create the %s service with the same selector and ports as kube-dns.", kubeDNSUpstreamService),
+			fmt.Sprintf("exists=%t selectorMatches=%t readyEndpoints=%d", us.Exists, us.SelectorMatches, us.ReadyEndpoints)))
+	}
+
+	if containsString(nl.ClusterDomainForward, clusterIP) && containsString(nl.BindIPs, clusterIP) {
+		res = append(res, findings.New("NODELOCAL-FORWARD-LOOP", findings.SeverityCritical, source,
+			fmt.Sprintf("node-local-dns listens on the kube-dns ClusterIP %s and also forwards cluster names to it, queries loop back to node-local-dns", clusterIP),
+			fmt.Sprintf("Forward cluster names to %s (the %s service ClusterIP) instead of the kube-dns ClusterIP.", pillarClusterDNS, kubeDNSUpstreamService),
+			fmt.Sprintf("bind=%v forward=%v", nl.BindIPs, nl.ClusterDomainForward)))
+	}
+
+	cacheFailed, bypassOK := make(map[string]bool), make(map[string]bool)
+	for _, r := range nl.ResolutionComparison {
+		if r.ServerType == serverTypeNodeLocal && r.Result != "success" {
+			cacheFailed[r.DomainName] = true
+		}
+		if r.ServerType == serverTypeBypass && r.Result == "success" {
+			bypassOK[r.DomainName] = true
+		}
+	}
+	for dom := range cacheFailed {
+		if bypassOK[dom] {
+			res = append(res, findings.New("NODELOCAL-RESOLUTION-FAILS", findings.SeverityCritical, source,
+				fmt.Sprintf("%s fails through NodeLocal DNSCache (%s) but resolves when bypassing it, node-local-dns on this node is broken", dom, nodeLocalDNSIP),
+				"Check the node-local-dns pod on this node (logs, restarts) and its Corefile.",
+				fmt.Sprintf("domain=%s", dom)))
+		}
+	}
+	return res
+}
@@ -9,6 +9,9 @@ import (
 	log "github.com/sirupsen/logrus"
 )
 
+//defaultClusterDomain is used when cluster domain can not be detected from the search path
+const defaultClusterDomain = "cluster.local"
+
 //ResolvConf struct stores /etc/resolv.conf of a pod
 type ResolvConf struct {
 	SearchPath []string
@@ -55,3 +58,13 @@ func (rc *ResolvConf) readResolvConf() error {
 	log.Infof("resolvconf struct values: %+v", rc)
 	return err
 }
+
+//clusterDomain detects the cluster domain from the search path (e.g. svc.cluster.local -> cluster.local)
+func (rc *ResolvConf) clusterDomain() string {
+	for _, search := range rc.SearchPath {
+		if strings.HasPrefix(search, "svc.") {
+			return strings.TrimPrefix(search, "svc.")
+		}
+	}
+	return defaultClusterDomain
+}
@@ -0,0 +1,212 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/joshisumit/eks-dns-troubleshooter/pkg/aws"
+	"github.com/joshisumit/eks-dns-troubleshooter/pkg/findings"
+)
+
+const (
+	pathForwardRule   = "resolver-forward-rule"
+	pathHostedZone    = "private-hosted-zone"
+	pathNotAssociated = "not-associated"
+	pathPublic        = "public-dns"
+	pathUnknown       = "unknown"
+	ruleTypeForward   = "FORWARD"
+	ruleTypeSystem    = "SYSTEM"
+	statusComplete    = "COMPLETE"
+
+	resolverEndpointOperational = "OPERATIONAL"
+	resolverIPAttached          = "ATTACHED"
+	resolverEndpointOutbound    = "OUTBOUND"
+)
+
+//DomainResolutionPath explains how the Route 53 Resolver of the cluster VPC answers a configured test domain
+type DomainResolutionPath struct {
+	Domain        string `json:"domain"`
+	Path          string `json:"path"`
+	Detail        string `json:"detail"`
+	ShouldResolve bool   `json:"shouldResolveInVpc"`
+	TestResult    string `json:"testResult,omitempty"`
+}
+
+//explainDomainResolution works out for each domain which Resolver rule or private hosted zone answers it in the VPC.
+//The most specific domain wins; a forward rule wins over a private hosted zone with the same name.
+//When the rules or the zones could not be listed, the other list cannot be weighed against them and the path is unknown.
+func explainDomainResolution(check *aws.Route53Check, domains []string, results []DnsTestResultForDomain) []DomainResolutionPath {
+	if check == nil {
+		return nil
+	}
+	unlisted := make([]string, 0, 2)
+	if !check.RulesListed {
+		unlisted = append(unlisted, "Resolver rules")
+	}
+	if !check.HostedZonesListed {
+		unlisted = append(unlisted, "private hosted zones")
+	}
+	tested := make(map[string]string)
+	for _, r := range results {
+		if r.ServerType == serverTypeClusterIP && r.Transport == transportUDP {
+			tested[r.DomainName] = r.Result
+		}
+	}
+
+	paths := make([]DomainResolutionPath, 0, len(domains))
+	for _, domain := range domains {
+		p := DomainResolutionPath{Domain: domain, TestResult: tested[domain]}
+
+		var rule, unassocRule *aws.ResolverRuleInfo
+		for i := range check.Rules {
+			r := &check.Rules[i]
+			if r.RuleType == ruleTypeForward || r.RuleType == ruleTypeSystem {
+				if !zoneMatches(domain, r.DomainName) || r.DomainName == "." {
+					continue
+				}
+				if r.AssociatedWithVpc && (rule == nil || len(r.DomainName) > len(rule.DomainName)) {
+					rule = r
+				}
+				if !r.AssociatedWithVpc && (unassocRule == nil || len(r.DomainName) > len(unassocRule.DomainName)) {
+					unassocRule = r
+				}
+			}
+		}
+		var zone, unassocZone *aws.HostedZoneInfo
+		for i := range check.HostedZones {
+			z := &check.HostedZones[i]
+			if !zoneMatches(domain, z.Name) {
+				continue
+			}
+			if z.AssociatedWithVpc && (zone == nil || len(z.Name) > len(zone.Name)) {
+				zone = z
+			}
+			if !z.AssociatedWithVpc && (unassocZone == nil || len(z.Name) > len(unassocZone.Name)) {
+				unassocZone = z
+			}
+		}
+
+		switch {
+		case rule != nil && rule.RuleType == ruleTypeForward && (zone == nil || len(rule.DomainName) >= len(zone.Name)):
+			p.Path, p.ShouldResolve = pathForwardRule, rule.Status == statusComplete && rule.AssociationStatus == statusComplete
+			p.Detail = fmt.Sprintf("Forwarded by Resolver rule %s (%s, %s) through endpoint %s to %v (rule status %s, association status %s)",
+				rule.ID, rule.Name, rule.DomainName, rule.ResolverEndpointID, rule.TargetIPs, rule.Status, rule.AssociationStatus)
+		case zone != nil:
+			p.Path, p.ShouldResolve = pathHostedZone, true
+			p.Detail = fmt.Sprintf("Answered by private hosted zone %s (%s) associated with VPC %s, NXDOMAIN if the record does not exist in the zone", zone.ID, zone.Name, check.VpcID)
+		case unassocRule != nil || unassocZone != nil:
+			p.Path = pathNotAssociated
+			names := make([]string, 0)
+			if unassocRule != nil {
+				names = append(names, fmt.Sprintf("Resolver rule %s (%s)", unassocRule.ID, unassocRule.DomainName))
+			}
+			if unassocZone != nil {
+				names = append(names, fmt.Sprintf("private hosted zone %s (%s)", unassocZone.ID, unassocZone.Name))
+			}
+			p.Detail = fmt.Sprintf("%s matches but is not associated with VPC %s, so the name is resolved on public DNS (usually NXDOMAIN for internal names)", strings.Join(names, " and "), check.VpcID)
+		default:
+			p.Path, p.ShouldResolve = pathPublic, true
+			p.Detail = "No Resolver rule or private hosted zone matches, the name is resolved on public DNS"
+		}
+		if len(unlisted) > 0 {
+			p.Path, p.ShouldResolve = pathUnknown, false
+			p.Detail = fmt.Sprintf("Unknown, %s could not be listed (from what was listed: %s)", strings.Join(unlisted, " and "), p.Detail)
+		}
+		paths = append(paths, p)
+	}
+	return paths
+}
+
+//route53Findings reports test domains which cannot resolve in the VPC because of Resolver rule or private hosted zone associations,
+//and Route 53 lists which failed so that the paths could not be worked out
+func route53Findings(check *aws.Route53Check, paths []DomainResolutionPath) []findings.Finding {
+	res := make([]findings.Finding, 0)
+	const source = "route53"
+
+	if check != nil && len(check.Errors) > 0 {
+		res = append(res, findings.New("ROUTE53-EVALUATION-INCOMPLETE", findings.SeverityInfo, source,
+			"Route 53 Resolver rules or private hosted zones could not be listed, how the test domains resolve in the VPC is unknown",
+			"Grant route53resolver:ListResolverRules, route53resolver:ListResolverRuleAssociations, route53:ListHostedZones and route53:ListHostedZonesByVPC to the troubleshooter.",
+			check.Errors...))
+	}
+
+	for _, p := range paths {
+		failed := p.TestResult != "" && p.TestResult != "success"
+		switch {
+		case p.Path == pathNotAssociated:
+			severity := findings.SeverityWarning
+			if failed {
+				severity = findings.SeverityCritical
+			}
+			res = append(res, findings.New("ROUTE53-NOT-ASSOCIATED", severity, source,
+				fmt.Sprintf("%s does not resolve inside the cluster VPC: %s", p.Domain, p.Detail),
+				"Associate the Resolver rule (aws route53resolver associate-resolver-rule) or the private hosted zone (aws route53 associate-vpc-with-hosted-zone) with the cluster VPC.",
+				fmt.Sprintf("test result through coredns: %s", p.TestResult)))
+		case p.Path == pathForwardRule && (failed || !p.ShouldResolve):
+			res = append(res, findings.New("ROUTE53-FORWARD-RULE-FAILING", findings.SeverityCritical, source,
+				fmt.Sprintf("%s is forwarded by a Resolver rule but does not resolve: %s", p.Domain, p.Detail),
+				"Check that the rule and its association are COMPLETE, the outbound Resolver endpoint is healthy and its security group allows DNS to the target servers, and the target servers answer for the domain.",
+				fmt.Sprintf("test result through coredns: %s", p.TestResult)))
+		case p.Path == pathHostedZone && failed:
+			res = append(res, findings.New("ROUTE53-HOSTED-ZONE-FAILING", findings.SeverityWarning, source,
+				fmt.Sprintf("%s belongs to an associated private hosted zone but does not resolve: %s", p.Domain, p.Detail),
+				"Check that the record exists in the zone and that enableDnsSupport and enableDnsHostnames are enabled on the VPC.",
+				fmt.Sprintf("test result through coredns: %s", p.TestResult)))
+		}
+	}
+	return res
+}
+
+//resolverEndpointFindings reports unhealthy outbound Resolver endpoints and security groups blocking DNS to the rule targets
+func resolverEndpointFindings(check *aws.Route53Check) []findings.Finding {
+	res := make([]findings.Finding, 0)
+	if check == nil {
+		return res
+	}
+	const source = "route53ResolverEndpoints"
+
+	for _, ep := range check.Endpoints {
+		rules := fmt.Sprintf("rules: %s", strings.Join(ep.Rules, ", "))
+		//the status is unknown when the endpoint could not be described
+		if ep.Status != "" && ep.Status != resolverEndpointOperational {
+			res = append(res, findings.New("RESOLVER-ENDPOINT-NOT-OPERATIONAL", findings.SeverityCritical, source,
+				fmt.Sprintf("Resolver endpoint %s (%s) used by forward rules of the VPC is %s: %s", ep.ID, ep.Name, ep.Status, ep.StatusMessage),
+				"Fix the endpoint (e.g. ACTION_NEEDED usually means its ENIs or subnets were removed) or recreate it with IP addresses in healthy subnets.",
+				rules))
+		}
+
+		unhealthy := make([]string, 0)
+		for _, ip := range ep.IPAddresses {
+			if ip.Status != resolverIPAttached {
+				unhealthy = append(unhealthy, fmt.Sprintf("%s in %s: %s %s", ip.IP, ip.SubnetID, ip.Status, ip.StatusMessage))
+			}
+		}
+		if len(unhealthy) > 0 {
+			severity := findings.SeverityWarning
+			if len(unhealthy) == len(ep.IPAddresses) {
+				severity = findings.SeverityCritical
+			}
+			res = append(res, findings.New("RESOLVER-ENDPOINT-IP-UNHEALTHY", severity, source,
+				fmt.Sprintf("%d of %d IP addresses of Resolver endpoint %s are not attached", len(unhealthy), len(ep.IPAddresses), ep.ID),
+				"Replace the unhealthy IP addresses of the endpoint (aws route53resolver update-resolver-endpoint / associate-resolver-endpoint-ip-address), keeping at least two in different AZs.",
+				append(unhealthy, rules)...))
+		}
+
+		for _, t := range ep.TargetChecks {
+			if t.Allowed {
+				continue
+			}
+			res = append(res, findings.New("RESOLVER-ENDPOINT-SG-BLOCKED", findings.SeverityCritical, source,
+				fmt.Sprintf("Security groups %v of Resolver endpoint %s do not allow outbound %s to target %s", ep.SecurityGroupIDs, ep.ID, strings.ToUpper(t.Protocol), t.Target),
+				fmt.Sprintf("Add an outbound rule for %s to %s in one of the security groups of the endpoint.", t.Protocol, t.Target),
+				t.Rule, rules))
+		}
+		if len(ep.Errors) > 0 {
+			res = append(res, findings.New("RESOLVER-ENDPOINT-CHECK-INCOMPLETE", findings.SeverityInfo, source,
+				fmt.Sprintf("Resolver endpoint %s could not be fully evaluated", ep.ID),
+				"Grant route53resolver:GetResolverEndpoint, route53resolver:ListResolverEndpointIpAddresses and ec2:DescribeSecurityGroups to the troubleshooter.",
+				ep.Errors...))
+		}
+	}
+	return res
+}
@@ -0,0 +1,163 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"strings"
+
+	"github.com/joshisumit/eks-dns-troubleshooter/pkg/aws"
+	"github.com/joshisumit/eks-dns-troubleshooter/pkg/findings"
+)
+
+const (
+	routeRoleNodes            = "nodes"
+	routeRoleCoredns          = "coredns"
+	routeRoleResolverEndpoint = "resolver endpoint"
+)
+
+//inVpc returns true if the IP is in one of the VPC CIDRs, such targets use the local route
+func inVpc(ip string, vpc *aws.VPCDNSCheck) bool {
+	if vpc == nil {
+		return false
+	}
+	for _, cidr := range vpc.CidrBlocks {
+		if _, ipNet, err := net.ParseCIDR(cidr); err == nil && ipNet.Contains(net.ParseIP(ip)) {
+			return true
+		}
+	}
+	return false
+}
+
+//routedTarget returns the IP of a forward or rule target if it leaves the VPC through a route
+func routedTarget(address string, vpc *aws.VPCDNSCheck) string {
+	host := address
+	if h, _, err := net.SplitHostPort(address); err == nil {
+		host = h
+	}
+	ip := net.ParseIP(host)
+	if ip == nil || ip.IsLoopback() || ip.IsLinkLocalUnicast() || inVpc(host, vpc) {
+		return ""
+	}
+	return host
+}
+
+//routeScopes returns the node, coredns and outbound Resolver endpoint subnets with the targets each of them forwards DNS to
+func routeScopes(nodes []*aws.SGEndpoint, sec *ServiceEndpointsCheck, upstreams *UpstreamCheck, r53 *aws.Route53Check, vpc *aws.VPCDNSCheck) []aws.RouteScope {
+	scopes := make([]aws.RouteScope, 0, 3)
+
+	nodeScope := aws.RouteScope{Role: routeRoleNodes}
+	for _, n := range nodes {
+		if n.IP != "" {
+			nodeScope.IPs = append(nodeScope.IPs, n.IP)
+		}
+	}
+	scopes = append(scopes, nodeScope)
+
+	//coredns sends queries of forwarded zones from the pod IP to the targets of the Corefile
+	corednsScope := aws.RouteScope{Role: routeRoleCoredns}
+	if sec != nil {
+		for _, addr := range sec.Addresses {
+			corednsScope.IPs = append(corednsScope.IPs, addr.IP)
+		}
+	}
+	if upstreams != nil {
+		for _, t := range upstreams.Targets {
+			if ip := routedTarget(t.Address, vpc); ip != "" {
+				corednsScope.Targets = append(corednsScope.Targets, aws.RouteTarget{IP: ip, Purpose: fmt.Sprintf("forward %s (%s)", t.Zone, t.Source)})
+			}
+		}
+	}
+	scopes = append(scopes, corednsScope)
+
+	//outbound Resolver endpoints send the queries of forward rules from their ENIs to the rule targets
+	if r53 != nil {
+		for _, ep := range r53.Endpoints {
+			if ep.Direction != resolverEndpointOutbound {
+				continue
+			}
+			scope := aws.RouteScope{Role: routeRoleResolverEndpoint}
+			for _, ip := range ep.IPAddresses {
+				scope.SubnetIDs = append(scope.SubnetIDs, ip.SubnetID)
+			}
+			for _, rule := range r53.Rules {
+				if rule.ResolverEndpointID != ep.ID || !rule.AssociatedWithVpc {
+					continue
+				}
+				for _, t := range rule.TargetIPs {
+					if ip := routedTarget(t, vpc); ip != "" {
+						scope.Targets = append(scope.Targets, aws.RouteTarget{IP: ip, Purpose: fmt.Sprintf("Resolver rule %s (%s)", rule.ID, rule.DomainName)})
+					}
+				}
+			}
+			scopes = append(scopes, scope)
+		}
+	}
+	return scopes
+}
+
+//routeTableFindings reports routes which prevent coredns or Resolver endpoints from reaching their forward targets
+func routeTableFindings(check *aws.RouteTableCheck) []findings.Finding {
+	res := make([]findings.Finding, 0)
+	if check == nil {
+		return res
+	}
+	const source = "routeTables"
+
+	for _, s := range check.Subnets {
+		roles := strings.Join(s.Roles, ", ")
+		routeTable := s.RouteTableID
+		if s.MainRouteTable {
+			routeTable += " (main route table)"
+		}
+		for _, t := range s.TargetRoutes {
+			evidence := []string{
+				"route table: " + routeTable,
+				"roles of the subnet: " + roles,
+				fmt.Sprintf("target: %s, %s", t.Target, t.Purpose),
+			}
+			if t.Destination != "" {
+				evidence = append(evidence, fmt.Sprintf("matching route: %s via %s", t.Destination, t.Via))
+			}
+			switch t.State {
+			case aws.RouteStateNoRoute:
+				res = append(res, findings.New("ROUTE-TARGET-NO-ROUTE", findings.SeverityCritical, source,
+					fmt.Sprintf("Subnet %s has no route to DNS forward target %s", s.SubnetID, t.Target),
+					"Add a route for the target to the route table: a default route to a NAT gateway for internet resolvers, or a Transit Gateway/VPN route for on-prem resolvers.",
+					evidence...))
+			case aws.RouteStateBlackhole:
+				res = append(res, findings.New("ROUTE-TARGET-BLACKHOLE", findings.SeverityCritical, source,
+					fmt.Sprintf("The route of subnet %s towards DNS forward target %s is a blackhole", s.SubnetID, t.Target),
+					fmt.Sprintf("The next hop %s of route %s was deleted or detached. Point the route to an existing NAT gateway, Transit Gateway or VPN gateway.", t.Via, t.Destination),
+					evidence...))
+			case aws.RouteStateNATUnavailable:
+				res = append(res, findings.New("ROUTE-TARGET-NAT-UNAVAILABLE", findings.SeverityCritical, source,
+					fmt.Sprintf("Subnet %s reaches DNS forward target %s through NAT gateway %s which is not available", s.SubnetID, t.Target, t.Via),
+					"Replace the NAT gateway or point the default route to an available NAT gateway in a public subnet.",
+					evidence...))
+			case aws.RouteStatePrivateViaInternet:
+				res = append(res, findings.New("ROUTE-TARGET-PRIVATE-VIA-INTERNET", findings.SeverityCritical, source,
+					fmt.Sprintf("Private DNS forward target %s is only covered by route %s via %s in subnet %s", t.Target, t.Destination, t.Via, s.SubnetID),
+					"On-prem resolvers need a more specific route to the Transit Gateway, VPN gateway or peering connection which reaches the on-prem network.",
+					evidence...))
+			case aws.RouteStateIGWWithoutPublicIP:
+				res = append(res, findings.New("ROUTE-TARGET-IGW-NO-PUBLIC-IP", findings.SeverityWarning, source,
+					fmt.Sprintf("Subnet %s reaches DNS forward target %s through internet gateway %s but does not assign public IPs", s.SubnetID, t.Target, t.Via),
+					"Queries are dropped unless the source has a public or Elastic IP. Run coredns in private subnets with a default route to a NAT gateway.",
+					evidence...))
+			}
+		}
+		if len(s.Blackholes) > 0 {
+			res = append(res, findings.New("ROUTE-TABLE-BLACKHOLE", findings.SeverityWarning, source,
+				fmt.Sprintf("Route table %s of subnet %s (%s) has %d blackhole route(s)", s.RouteTableID, s.SubnetID, roles, len(s.Blackholes)),
+				"Remove the blackhole routes or point them to an existing next hop.",
+				s.Blackholes...))
+		}
+	}
+	if len(check.Errors) > 0 {
+		res = append(res, findings.New("ROUTE-TABLE-EVALUATION-INCOMPLETE", findings.SeverityInfo, source,
+			"Some subnets or routes could not be evaluated, the route table results may be incomplete",
+			"Grant ec2:DescribeSubnets, ec2:DescribeRouteTables, ec2:DescribeNatGateways and ec2:GetManagedPrefixListEntries to the troubleshooter.",
+			check.Errors...))
+	}
+	return res
+}
@@ -0,0 +1,118 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/joshisumit/eks-dns-troubleshooter/pkg/aws"
+	"github.com/joshisumit/eks-dns-troubleshooter/pkg/findings"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+//nodeInternalIP returns the InternalIP address of the node
+func nodeInternalIP(node *v1.Node) string {
+	for _, addr := range node.Status.Addresses {
+		if addr.Type == v1.NodeInternalIP {
+			return addr.Address
+		}
+	}
+	return ""
+}
+
+//dnsSGEndpoints returns all EC2 nodes as client endpoints and the coredns pods on EC2 nodes as server endpoints.
+//Servers use the pod IP, so that CIDR rules are matched against the address DNS queries are sent to,
+//and the security groups of their node.
+func dnsSGEndpoints(sec *ServiceEndpointsCheck) ([]*aws.SGEndpoint, []*aws.SGEndpoint, error) {
+	nodes, err := Clientset.CoreV1().Nodes().List(metav1.ListOptions{})
+	if err != nil {
+		return nil, nil, fmt.Errorf("Failed to list nodes: %v", err)
+	}
+
+	clients, servers := make([]*aws.SGEndpoint, 0), make([]*aws.SGEndpoint, 0)
+	instanceOf := make(map[string]string)
+	for i := range nodes.Items {
+		node := &nodes.Items[i]
+		m := providerIDRegex.FindStringSubmatch(node.Spec.ProviderID)
+		//Fargate nodes have no EC2 instance and use the cluster security group
+		if isFargateNode(node) || m == nil {
+			continue
+		}
+		clients = append(clients, &aws.SGEndpoint{Node: node.Name, InstanceID: m[2], IP: nodeInternalIP(node)})
+		instanceOf[node.Name] = m[2]
+	}
+	if sec != nil {
+		for _, addr := range sec.Addresses {
+			instanceID, ok := instanceOf[addr.Node]
+			if !ok {
+				continue
+			}
+			pod := addr.Pod
+			if pod == "" {
+				pod = addr.IP
+			}
+			servers = append(servers, &aws.SGEndpoint{Node: addr.Node, Pod: pod, InstanceID: instanceID, IP: addr.IP})
+		}
+	}
+	return clients, servers, nil
+}
+
+//corednsServerLabel names the coredns endpoint of a flow, the pod when known and its node
+func corednsServerLabel(f aws.SGFlowResult) string {
+	if f.ServerPod == "" {
+		return f.Server
+	}
+	return fmt.Sprintf("%s on node %s", f.ServerPod, f.Server)
+}
+
+//securityGroupFindings reports node and coredns pod pairs whose security groups block DNS along with the deciding rules
+func securityGroupFindings(eval *aws.SGEvaluation) []findings.Finding {
+	res := make([]findings.Finding, 0)
+	if eval == nil {
+		return res
+	}
+	const source = "securityGroups"
+
+	for _, f := range eval.Flows {
+		clients := strings.Join(f.ClientNodes, ", ")
+		if !f.EgressAllowed {
+			res = append(res, findings.New("SG-DNS-EGRESS-BLOCKED", findings.SeverityCritical, source,
+				fmt.Sprintf("Security groups %v of nodes %s do not allow outbound 53/%s to coredns %s", f.ClientSGs, clients, strings.ToUpper(f.Protocol), corednsServerLabel(f)),
+				fmt.Sprintf("Add an outbound rule for %s 53 to one of %v (or to the VPC CIDR) in one of %v.", f.Protocol, f.ServerSGs, f.ClientSGs),
+				f.EgressRule))
+		}
+		if !f.IngressAllowed {
+			res = append(res, findings.New("SG-DNS-INGRESS-BLOCKED", findings.SeverityCritical, source,
+				fmt.Sprintf("Security groups %v of coredns %s do not allow inbound 53/%s from nodes %s", f.ServerSGs, corednsServerLabel(f), strings.ToUpper(f.Protocol), clients),
+				fmt.Sprintf("Add an inbound rule for %s 53 from one of %v (or from the VPC CIDR) in one of %v.", f.Protocol, f.ClientSGs, f.ServerSGs),
+				f.IngressRule))
+		}
+	}
+	if len(eval.Errors) > 0 {
+		res = append(res, findings.New("SG-EVALUATION-INCOMPLETE", findings.SeverityInfo, source,
+			"Some security groups or prefix lists could not be evaluated, the security group results may be incomplete",
+			"Grant ec2:DescribeInstances, ec2:DescribeSecurityGroups and ec2:GetManagedPrefixListEntries to the troubleshooter.",
+			eval.Errors...))
+	}
+	return res
+}
+
+//securityGroupVerdict summarises the security group evaluation of DNS flows for the analysis section of the report
+func securityGroupVerdict(eval *aws.SGEvaluation) string {
+	if eval == nil {
+		return "securityGroups were not evaluated...see diagnosis logs"
+	}
+	blocked := 0
+	for _, f := range eval.Flows {
+		if !f.EgressAllowed || !f.IngressAllowed {
+			blocked++
+		}
+	}
+	switch {
+	case blocked > 0:
+		return fmt.Sprintf("securityGroups are NOT configured correctly...blocking %d DNS flows between nodes and coredns", blocked)
+	case len(eval.Errors) > 0:
+		return "securityGroups are not blocking any evaluated DNS communication...evaluation is incomplete"
+	}
+	return "securityGroups are configured correctly...not blocking any DNS communication"
+}
@@ -0,0 +1,32 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+//serviceAccountNamespaceFile contains the namespace of the pod in which the tool is running
+const serviceAccountNamespaceFile = "/var/run/secrets/kubernetes.io/serviceaccount/namespace"
+
+//getSelfPod returns the pod object of the troubleshooter itself (hostname of the container is the pod name)
+func getSelfPod() (*v1.Pod, error) {
+	podName, err := os.Hostname()
+	if err != nil {
+		return nil, fmt.Errorf("Failed to get hostname: %v", err)
+	}
+	ns, err := ioutil.ReadFile(serviceAccountNamespaceFile)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to read namespace of the pod: %v", err)
+	}
+
+	pod, err := Clientset.CoreV1().Pods(strings.TrimSpace(string(ns))).Get(podName, metav1.GetOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("Failed to get pod %s: %v", podName, err)
+	}
+	return pod, nil
+}
@@ -0,0 +1,280 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/joshisumit/eks-dns-troubleshooter/pkg/aws"
+	"github.com/joshisumit/eks-dns-troubleshooter/pkg/findings"
+	log "github.com/sirupsen/logrus"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+)
+
+const (
+	sgpGroupVersion = "vpcresources.k8s.aws/v1beta1"
+	sgpResource     = "securitygrouppolicies"
+	//annotationPodENI is set by the VPC resource controller once a branch ENI is attached to the pod
+	annotationPodENI = "vpc.amazonaws.com/pod-eni"
+	envEnablePodENI  = "ENABLE_POD_ENI"
+	envSGEnforceMode = "POD_SECURITY_GROUP_ENFORCING_MODE"
+	//maxSGPClientPods limits the number of user workload pods evaluated
+	maxSGPClientPods = 50
+)
+
+//securityGroupPolicyList is the part of a SecurityGroupPolicyList (vpcresources.k8s.aws/v1beta1) used by the tool
+type securityGroupPolicyList struct {
+	Items []securityGroupPolicy `json:"items"`
+}
+
+//securityGroupPolicy is the part of a SecurityGroupPolicy used by the tool
+type securityGroupPolicy struct {
+	metav1.ObjectMeta `json:"metadata"`
+	Spec              struct {
+		PodSelector            *metav1.LabelSelector `json:"podSelector,omitempty"`
+		ServiceAccountSelector *metav1.LabelSelector `json:"serviceAccountSelector,omitempty"`
+		SecurityGroups         struct {
+			GroupIds []string `json:"groupIds"`
+		} `json:"securityGroups"`
+	} `json:"spec"`
+}
+
+//SGPolicyMatch is a SecurityGroupPolicy and the pods it selects
+type SGPolicyMatch struct {
+	Name           string   `json:"name"`
+	SecurityGroups []string `json:"securityGroups"`
+	Pods           []string `json:"pods,omitempty"`
+}
+
+//SGPolicyCheck stores the Security Groups for Pods configuration and the evaluation of pod security groups for DNS
+type SGPolicyCheck struct {
+	PodENIEnabled    string            `json:"enablePodENI"`
+	EnforcingMode    string            `json:"enforcingMode,omitempty"`
+	Policies         []SGPolicyMatch   `json:"policies,omitempty"`
+	SelfMatched      bool              `json:"troubleshooterMatched"`
+	CorednsMatched   bool              `json:"corednsMatched"`
+	WithoutBranchENI []string          `json:"podsWithoutBranchENI,omitempty"`
+	Evaluation       *aws.SGEvaluation `json:"evaluation,omitempty"`
+}
+
+//awsNodeEnv returns the value of an environment variable of the aws-node container
+func awsNodeEnv(name string) string {
+	ds, err := Clientset.AppsV1().DaemonSets(metav1.NamespaceSystem).Get("aws-node", metav1.GetOptions{})
+	if err != nil {
+		log.Warnf("Failed to get aws-node daemonset: %v", err)
+		return ""
+	}
+	for _, c := range ds.Spec.Template.Spec.Containers {
+		if c.Name != "aws-node" {
+			continue
+		}
+		for _, env := range c.Env {
+			if env.Name == name {
+				return env.Value
+			}
+		}
+	}
+	return ""
+}
+
+//sgpSelects returns true if the policy selects the pod, pod and service account selectors must both match when set
+func sgpSelects(podSelector, saSelector *metav1.LabelSelector, pod *v1.Pod, saLabels map[string]labels.Set) bool {
+	if podSelector == nil && saSelector == nil {
+		return false
+	}
+	if podSelector != nil {
+		selector, err := metav1.LabelSelectorAsSelector(podSelector)
+		if err != nil || !selector.Matches(labels.Set(pod.Labels)) {
+			return false
+		}
+	}
+	if saSelector != nil {
+		selector, err := metav1.LabelSelectorAsSelector(saSelector)
+		if err != nil || !selector.Matches(saLabels[pod.Namespace+"/"+pod.Spec.ServiceAccountName]) {
+			return false
+		}
+	}
+	return true
+}
+
+//matchSecurityGroupPolicies returns the pods selected by each policy and the security groups of every selected pod.
+//Like the VPC resource controller, a pod selected by several policies gets the union of their security groups
+func matchSecurityGroupPolicies(policies []securityGroupPolicy, pods []v1.Pod, saLabels map[string]labels.Set) ([]SGPolicyMatch, map[string][]string) {
+	matches := make([]SGPolicyMatch, 0, len(policies))
+	podSGs := make(map[string][]string)
+	for _, item := range policies {
+		match := SGPolicyMatch{Name: item.Namespace + "/" + item.Name, SecurityGroups: item.Spec.SecurityGroups.GroupIds}
+		for i := range pods {
+			pod := &pods[i]
+			key := pod.Namespace + "/" + pod.Name
+			if pod.Namespace != item.Namespace || pod.Spec.HostNetwork || pod.Status.PodIP == "" {
+				continue
+			}
+			if !sgpSelects(item.Spec.PodSelector, item.Spec.ServiceAccountSelector, pod, saLabels) {
+				continue
+			}
+			match.Pods = append(match.Pods, key)
+			for _, sg := range item.Spec.SecurityGroups.GroupIds {
+				if !containsString(podSGs[key], sg) {
+					podSGs[key] = append(podSGs[key], sg)
+				}
+			}
+		}
+		matches = append(matches, match)
+	}
+	return matches, podSGs
+}
+
+//checkSecurityGroupPolicies finds SecurityGroupPolicies which select the troubleshooter, coredns or user workloads
+//and evaluates their security groups for DNS between those pods and the coredns pods
+func checkSecurityGroupPolicies(ns string, self *v1.Pod, region string) (*SGPolicyCheck, error) {
+	if !hasServerResource(sgpGroupVersion, sgpResource) {
+		log.Infof("SecurityGroupPolicy CRD (%s) is not installed", sgpGroupVersion)
+		return nil, nil
+	}
+	check := &SGPolicyCheck{
+		PodENIEnabled: awsNodeEnv(envEnablePodENI),
+		EnforcingMode: awsNodeEnv(envSGEnforceMode),
+	}
+
+	raw, err := Clientset.CoreV1().RESTClient().Get().AbsPath("/apis", sgpGroupVersion, sgpResource).DoRaw()
+	if err != nil {
+		return check, fmt.Errorf("Failed to list SecurityGroupPolicies: %v", err)
+	}
+	policies := securityGroupPolicyList{}
+	if err := json.Unmarshal(raw, &policies); err != nil {
+		return check, fmt.Errorf("Failed to decode SecurityGroupPolicies: %v", err)
+	}
+	if len(policies.Items) == 0 {
+		return check, nil
+	}
+
+	pods, err := Clientset.CoreV1().Pods(metav1.NamespaceAll).List(metav1.ListOptions{})
+	if err != nil {
+		return check, fmt.Errorf("Failed to list pods: %v", err)
+	}
+	sas, err := Clientset.CoreV1().ServiceAccounts(metav1.NamespaceAll).List(metav1.ListOptions{})
+	if err != nil {
+		return check, fmt.Errorf("Failed to list service accounts: %v", err)
+	}
+	saLabels := make(map[string]labels.Set)
+	for _, sa := range sas.Items {
+		saLabels[sa.Namespace+"/"+sa.Name] = labels.Set(sa.Labels)
+	}
+
+	dep, err := Clientset.AppsV1().Deployments(ns).Get("coredns", metav1.GetOptions{})
+	if err != nil {
+		return check, fmt.Errorf("Failed to get coredns deployment: %v", err)
+	}
+	corednsPods, err := getDeploymentPods(dep)
+	if err != nil {
+		return check, err
+	}
+	isCoredns := make(map[string]bool)
+	for _, pod := range corednsPods {
+		isCoredns[pod.Namespace+"/"+pod.Name] = true
+	}
+
+	//1. security groups of every pod selected by at least one policy
+	var podSGs map[string][]string
+	check.Policies, podSGs = matchSecurityGroupPolicies(policies.Items, pods.Items, saLabels)
+	clients := make([]*aws.SGEndpoint, 0)
+	for i := range pods.Items {
+		pod := &pods.Items[i]
+		key := pod.Namespace + "/" + pod.Name
+		sgs, ok := podSGs[key]
+		if !ok {
+			continue
+		}
+		if _, ok := pod.Annotations[annotationPodENI]; !ok {
+			check.WithoutBranchENI = append(check.WithoutBranchENI, key)
+		}
+
+		isSelf := self != nil && self.Namespace == pod.Namespace && self.Name == pod.Name
+		check.SelfMatched = check.SelfMatched || isSelf
+		check.CorednsMatched = check.CorednsMatched || isCoredns[key]
+		if !isCoredns[key] && (isSelf || len(clients) < maxSGPClientPods) {
+			clients = append(clients, &aws.SGEndpoint{Node: key, IP: pod.Status.PodIP, SecurityGroups: sgs})
+		}
+	}
+	if len(podSGs) == 0 {
+		log.Infof("No SecurityGroupPolicy selects running pods")
+		return check, nil
+	}
+
+	//2. coredns pods use their pod security groups if selected, otherwise the security groups of their node
+	nodeClients, _, err := dnsSGEndpoints(nil)
+	if err != nil {
+		return check, err
+	}
+	instanceOf := make(map[string]string)
+	for _, ep := range nodeClients {
+		instanceOf[ep.Node] = ep.InstanceID
+	}
+	servers := make([]*aws.SGEndpoint, 0)
+	for _, pod := range corednsPods {
+		key := pod.Namespace + "/" + pod.Name
+		if pod.Status.PodIP == "" {
+			continue
+		}
+		server := &aws.SGEndpoint{Node: key, IP: pod.Status.PodIP, SecurityGroups: podSGs[key]}
+		if len(server.SecurityGroups) == 0 {
+			server.InstanceID = instanceOf[pod.Spec.NodeName]
+		}
+		servers = append(servers, server)
+	}
+	//clients without pod security groups are only affected when coredns has pod security groups
+	if check.CorednsMatched {
+		clients = append(clients, nodeClients...)
+	}
+
+	check.Evaluation, err = aws.EvaluateDNSSecurityGroups(region, clients, servers)
+	if err != nil {
+		return check, err
+	}
+	log.Infof("SecurityGroupPolicy check: %+v", check)
+	return check, nil
+}
+
+//securityGroupPolicyFindings reports pod security groups which block DNS and policies which are not applied
+func securityGroupPolicyFindings(check *SGPolicyCheck) []findings.Finding {
+	res := make([]findings.Finding, 0)
+	if check == nil {
+		return res
+	}
+	const source = "securityGroupPolicies"
+
+	if len(check.WithoutBranchENI) > 0 {
+		res = append(res, findings.New("SGP-NOT-APPLIED", findings.SeverityWarning, source,
+			fmt.Sprintf("Pods are selected by a SecurityGroupPolicy but have no branch ENI (%s annotation), so the node security groups apply instead (%s=%q)", annotationPodENI, envEnablePodENI, check.PodENIEnabled),
+			fmt.Sprintf("Set %s=true on the aws-node daemonset, use instance types which support trunk ENIs and recreate the pods.", envEnablePodENI),
+			check.WithoutBranchENI...))
+	}
+	if check.Evaluation == nil {
+		return res
+	}
+
+	for _, f := range check.Evaluation.Flows {
+		clients := strings.Join(f.ClientNodes, ", ")
+		if !f.EgressAllowed {
+			res = append(res, findings.New("SGP-DNS-EGRESS-BLOCKED", findings.SeverityCritical, source,
+				fmt.Sprintf("Pod security groups %v of %s do not allow outbound 53/%s to coredns pod %s", f.ClientSGs, clients, strings.ToUpper(f.Protocol), f.Server),
+				fmt.Sprintf("Security groups for pods must allow outbound %s 53 to the cluster security group or the security groups of coredns (%v).", f.Protocol, f.ServerSGs),
+				f.EgressRule))
+		}
+		if !f.IngressAllowed {
+			res = append(res, findings.New("SGP-DNS-INGRESS-BLOCKED", findings.SeverityCritical, source,
+				fmt.Sprintf("Security groups %v of coredns pod %s do not allow inbound 53/%s from %s", f.ServerSGs, f.Server, strings.ToUpper(f.Protocol), clients),
+				fmt.Sprintf("Allow inbound %s 53 from the pod security groups %v in the cluster security group or the security groups of coredns.", f.Protocol, f.ClientSGs),
+				f.IngressRule))
+		}
+	}
+	if len(check.Evaluation.Errors) > 0 {
+		res = append(res, findings.New("SGP-EVALUATION-INCOMPLETE", findings.SeverityInfo, source,
+			"Some pod security groups could not be evaluated", "Check that the security groups referenced by SecurityGroupPolicies exist.",
+			check.Evaluation.Errors...))
+	}
+	return res
+}
@@ -0,0 +1,87 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+)
+
+func testSGPolicy(name string, podSelector, saSelector *metav1.LabelSelector, groups ...string) securityGroupPolicy {
+	p := securityGroupPolicy{ObjectMeta: metav1.ObjectMeta{Namespace: "app", Name: name}}
+	p.Spec.PodSelector = podSelector
+	p.Spec.ServiceAccountSelector = saSelector
+	p.Spec.SecurityGroups.GroupIds = groups
+	return p
+}
+
+func testSGPPod(name, sa, ip string, podLabels map[string]string) v1.Pod {
+	return v1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "app", Name: name, Labels: podLabels},
+		Spec:       v1.PodSpec{ServiceAccountName: sa},
+		Status:     v1.PodStatus{PodIP: ip},
+	}
+}
+
+func TestSGPSelects(t *testing.T) {
+	web := &metav1.LabelSelector{MatchLabels: map[string]string{"app": "web"}}
+	db := &metav1.LabelSelector{MatchLabels: map[string]string{"app": "db"}}
+	secured := &metav1.LabelSelector{MatchLabels: map[string]string{"sg": "true"}}
+	saLabels := map[string]labels.Set{"app/secured": {"sg": "true"}, "app/default": {}}
+
+	pod := testSGPPod("web-1", "secured", "10.0.1.5", map[string]string{"app": "web"})
+	tests := []struct {
+		name                    string
+		podSelector, saSelector *metav1.LabelSelector
+		pod                     v1.Pod
+		want                    bool
+	}{
+		{"no selector", nil, nil, pod, false},
+		{"pod selector", web, nil, pod, true},
+		{"other pod selector", db, nil, pod, false},
+		{"service account selector", nil, secured, pod, true},
+		{"both selectors", web, secured, pod, true},
+		{"service account not matching", web, secured, testSGPPod("web-2", "default", "10.0.1.6", map[string]string{"app": "web"}), false},
+	}
+	for _, tt := range tests {
+		if got := sgpSelects(tt.podSelector, tt.saSelector, &tt.pod, saLabels); got != tt.want {
+			t.Errorf("%s: sgpSelects = %t, want %t", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestMatchSecurityGroupPolicies(t *testing.T) {
+	policies := []securityGroupPolicy{
+		testSGPolicy("web", &metav1.LabelSelector{MatchLabels: map[string]string{"app": "web"}}, nil, "sg-web", "sg-shared"),
+		testSGPolicy("all", &metav1.LabelSelector{}, nil, "sg-shared", "sg-dns"),
+	}
+	pods := []v1.Pod{
+		testSGPPod("web-1", "default", "10.0.1.5", map[string]string{"app": "web"}),
+		testSGPPod("api-1", "default", "10.0.1.6", map[string]string{"app": "api"}),
+		testSGPPod("pending", "default", "", map[string]string{"app": "web"}),
+	}
+	hostNetwork := testSGPPod("agent", "default", "10.0.1.7", map[string]string{"app": "web"})
+	hostNetwork.Spec.HostNetwork = true
+	other := testSGPPod("web-2", "default", "10.0.1.8", map[string]string{"app": "web"})
+	other.Namespace = "other"
+	pods = append(pods, hostNetwork, other)
+
+	matches, podSGs := matchSecurityGroupPolicies(policies, pods, map[string]labels.Set{})
+
+	wantMatches := []SGPolicyMatch{
+		{Name: "app/web", SecurityGroups: []string{"sg-web", "sg-shared"}, Pods: []string{"app/web-1"}},
+		{Name: "app/all", SecurityGroups: []string{"sg-shared", "sg-dns"}, Pods: []string{"app/web-1", "app/api-1"}},
+	}
+	if !reflect.DeepEqual(matches, wantMatches) {
+		t.Errorf("matches = %+v, want %+v", matches, wantMatches)
+	}
+	wantSGs := map[string][]string{
+		"app/web-1": {"sg-web", "sg-shared", "sg-dns"},
+		"app/api-1": {"sg-shared", "sg-dns"},
+	}
+	if !reflect.DeepEqual(podSGs, wantSGs) {
+		t.Errorf("pod security groups = %v, want %v", podSGs, wantSGs)
+	}
+}
@@ -4,6 +4,7 @@ import (
 	"encoding/json"
 	"fmt"
 	"github.com/joshisumit/eks-dns-troubleshooter/pkg/aws"
+	"github.com/joshisumit/eks-dns-troubleshooter/pkg/findings"
 	"github.com/joshisumit/eks-dns-troubleshooter/version"
 	log "github.com/sirupsen/logrus"
 	"io/ioutil"
@@ -17,11 +18,23 @@ type DiagnosisSummary struct {
 	IsDiagComplete bool                 `json:"diagnosisCompletion"`
 	DiagToolInfo   version.DiagToolInfo `json:"diagnosisToolInfo"`
 	//IsDiagSuccessful bool                   `json:"diagnosisResult"`
-	DiagError   string                 `json:"diagnosisError,omitempty"`
-	Result      map[string]interface{} `json:"Analysis,omitempty"`
-	EksVersion  string                 `json:"eksVersion"`
-	Coredns     Coredns                `json:"corednsChecks"`
-	ClusterInfo aws.ClusterInfo        `json:"eksClusterChecks"`
+	DiagError         string                 `json:"diagnosisError,omitempty"`
+	Result            map[string]interface{} `json:"Analysis,omitempty"`
+	EksVersion        string                 `json:"eksVersion"`
+	Coredns           Coredns                `json:"corednsChecks"`
+	ComponentVersions []VersionCheck         `json:"componentVersions,omitempty"`
+	AddonDrift        []AddonDrift           `json:"addonDrift,omitempty"`
+	KubeProxy         *KubeProxyCheck        `json:"kubeProxyChecks,omitempty"`
+	DNSPolicyAudit    *DNSPolicyAudit        `json:"dnsPolicyAudit,omitempty"`
+	NetworkPolicies   *NetworkPolicyCheck    `json:"networkPolicyChecks,omitempty"`
+	Fargate           *FargateCheck          `json:"fargateChecks,omitempty"`
+	IMDS              *aws.IMDSCheck         `json:"imdsChecks,omitempty"`
+	PodSecurityGroups *SGPolicyCheck         `json:"securityGroupPolicyChecks,omitempty"`
+	Upstreams         *UpstreamCheck         `json:"upstreamChecks,omitempty"`
+	DomainResolution  []DomainResolutionPath `json:"domainResolution,omitempty"`
+	EndpointDNS       []EndpointResolution   `json:"vpcEndpointResolution,omitempty"`
+	ClusterInfo       aws.ClusterInfo        `json:"eksClusterChecks"`
+	Findings          []findings.Finding     `json:"findings,omitempty"`
 	//RecommendedVersion bool
 }
 
@@ -35,16 +48,43 @@ func (ds *DiagnosisSummary) evalDiagResult() map[string]interface{} {
 	} else {
 		res["dnstest"] = "DNS resolution is NOT working correctly in the cluster, DNS queries are failing"
 	}
-	if ds.ClusterInfo.NaclRulesCheck {
-		res["naclRules"] = "naclRules are configured correctly...NOT blocking any DNS communication"
-	} else {
-		res["naclRules"] = "naclRules are NOT configured correctly...blocking DNS communication"
-	}
-	if ds.ClusterInfo.SgRulesCheck.IsClusterSGRuleCorrect {
-		res["securityGroupConfigurations"] = "securityGroups are configured correctly...not blocking any DNS communication"
-	} else {
-		res["securityGroupConfigurations"] = ds.ClusterInfo.SgRulesCheck
+	if ds.Coredns.Dnstest.Diagnosis != nil {
+		res["dnstestVerdict"] = ds.Coredns.Dnstest.Diagnosis.Verdict
 	}
+	res["naclRules"] = naclVerdict(ds.ClusterInfo.DNSNetworkACLs)
+	res["securityGroupConfigurations"] = securityGroupVerdict(ds.ClusterInfo.DNSSecurityGroups)
+
+	return res
+}
+
+// collectFindings gathers the findings reported by each check
+func (ds *DiagnosisSummary) collectFindings() []findings.Finding {
+	res := make([]findings.Finding, 0)
+
+	res = append(res, dnsDiagnosisFindings(ds.Coredns.Dnstest.Diagnosis)...)
+	res = append(res, serviceEndpointsFindings(ds.Coredns.ServiceEndpoints)...)
+	res = append(res, versionFindings(ds.ComponentVersions)...)
+	res = append(res, deploymentHealthFindings(ds.Coredns.DeploymentHealth)...)
+	res = append(res, topologyFindings(ds.Coredns.Topology)...)
+	res = append(res, kubeProxyFindings(ds.KubeProxy, ds.Coredns.Dnstest.Diagnosis)...)
+	res = append(res, nodeLocalDNSFindings(ds.Coredns.NodeLocalDNSCache, ds.Coredns.ClusterIP)...)
+	res = append(res, logSignatureFindings(ds.Coredns.LogSignatureMatches)...)
+	res = append(res, metricsFindings(ds.Coredns.Metrics)...)
+	res = append(res, dnsPolicyAuditFindings(ds.DNSPolicyAudit)...)
+	res = append(res, networkPolicyFindings(ds.NetworkPolicies)...)
+	res = append(res, imdsFindings(ds.IMDS)...)
+	res = append(res, securityGroupFindings(ds.ClusterInfo.DNSSecurityGroups)...)
+	res = append(res, naclFindings(ds.ClusterInfo.DNSNetworkACLs)...)
+	res = append(res, vpcDNSFindings(ds.ClusterInfo.VPCDNS, &ds.Coredns)...)
+	res = append(res, upstreamFindings(ds.Upstreams)...)
+	res = append(res, linkLocalFindings(ds.Coredns.LinkLocal, &ds.Coredns)...)
+	res = append(res, route53Findings(ds.ClusterInfo.Route53, ds.DomainResolution)...)
+	res = append(res, resolverEndpointFindings(ds.ClusterInfo.Route53)...)
+	res = append(res, routeTableFindings(ds.ClusterInfo.RouteTables)...)
+	res = append(res, vpcEndpointFindings(ds.ClusterInfo.VPCEndpoints, ds.EndpointDNS, ds.ClusterInfo.Route53)...)
+	res = append(res, securityGroupPolicyFindings(ds.PodSecurityGroups)...)
+	res = append(res, fargateFindings(ds.Fargate, ds.ClusterInfo.FargateProfiles)...)
+	res = append(res, addonFindings(ds.ClusterInfo.Addons, ds.ClusterInfo.AddonsError, ds.AddonDrift)...)
 
 	return res
 }
@@ -60,7 +100,12 @@ func (ds *DiagnosisSummary) printSummary() error {
 		}
 	}
 
-	// 2. Create JSON Marshal
+	//2. gather findings of all the checks which were executed so far
+	if f := ds.collectFindings(); len(f) != 0 {
+		ds.Findings = f
+	}
+
+	// 3. Create JSON Marshal
 	fmt.Printf("Inside sum Type: %T value: %+v \n coredns struct: Type: %T value: %+v\n\n\n", ds, ds, ds.Coredns, ds.Coredns)
 	report, err := json.Marshal(ds)
 	if err != nil {
@@ -68,7 +113,7 @@ func (ds *DiagnosisSummary) printSummary() error {
 		return fmt.Errorf("Failed to Marshal: %v", err)
 	}
 
-	//3. write JSON to file
+	//4. write JSON to file
 	log.Printf("JSON formatted report output")
 	fmt.Println(string(report))
 	err = ioutil.WriteFile(summaryFilePath, report, 0644)
@@ -0,0 +1,237 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+
+	"github.com/joshisumit/eks-dns-troubleshooter/pkg/findings"
+	log "github.com/sirupsen/logrus"
+	v1 "k8s.io/api/core/v1"
+	policyv1beta1 "k8s.io/api/policy/v1beta1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+)
+
+const (
+	labelZone           = "topology.kubernetes.io/zone"
+	labelZoneDeprecated = "failure-domain.beta.kubernetes.io/zone"
+	policyGroup         = "policy"
+)
+
+//pdbVersions are the PodDisruptionBudget API versions in order of preference, policy/v1beta1 is not served since 1.25
+var pdbVersions = []string{"v1", "v1beta1"}
+
+//TopologyCheck stores placement and disruption settings of the coredns replicas
+type TopologyCheck struct {
+	Replicas                  int32           `json:"replicas"`
+	Placement                 []PodPlacement  `json:"placement"`
+	Nodes                     []string        `json:"nodes"`
+	Zones                     []string        `json:"zones"`
+	PodAntiAffinity           []string        `json:"podAntiAffinity,omitempty"`
+	TopologySpreadConstraints []string        `json:"topologySpreadConstraints,omitempty"`
+	PodDisruptionBudget       *PDBDescription `json:"podDisruptionBudget,omitempty"`
+	PDBListError              string          `json:"pdbListError,omitempty"`
+}
+
+//PodPlacement stores the node and availability zone of a coredns pod
+type PodPlacement struct {
+	Pod  string `json:"pod"`
+	Node string `json:"node"`
+	Zone string `json:"zone"`
+}
+
+//PDBDescription stores the PodDisruptionBudget which covers the coredns pods
+type PDBDescription struct {
+	Name               string `json:"name"`
+	MinAvailable       string `json:"minAvailable,omitempty"`
+	MaxUnavailable     string `json:"maxUnavailable,omitempty"`
+	DisruptionsAllowed int32  `json:"disruptionsAllowed"`
+}
+
+//nodeZone returns the availability zone of a node from its topology labels
+func nodeZone(node *v1.Node) string {
+	if zone, ok := node.Labels[labelZone]; ok {
+		return zone
+	}
+	return node.Labels[labelZoneDeprecated]
+}
+
+//describeAntiAffinity returns the pod anti-affinity terms of the pod template
+func describeAntiAffinity(affinity *v1.Affinity) []string {
+	res := make([]string, 0)
+	if affinity == nil || affinity.PodAntiAffinity == nil {
+		return res
+	}
+	for _, term := range affinity.PodAntiAffinity.RequiredDuringSchedulingIgnoredDuringExecution {
+		res = append(res, fmt.Sprintf("required topologyKey=%s selector=%s", term.TopologyKey, metav1.FormatLabelSelector(term.LabelSelector)))
+	}
+	for _, term := range affinity.PodAntiAffinity.PreferredDuringSchedulingIgnoredDuringExecution {
+		res = append(res, fmt.Sprintf("preferred(weight=%d) topologyKey=%s selector=%s", term.Weight, term.PodAffinityTerm.TopologyKey, metav1.FormatLabelSelector(term.PodAffinityTerm.LabelSelector)))
+	}
+	return res
+}
+
+//checkTopology inspects how the coredns replicas are spread across nodes and availability zones
+func checkTopology(ns string) (*TopologyCheck, error) {
+	dep, err := Clientset.AppsV1().Deployments(ns).Get("coredns", metav1.GetOptions{})
+	if err != nil {
+		log.Errorf("Failed to check coredns deployment %s", err)
+		return nil, fmt.Errorf("Failed to check coredns deployment: %v", err)
+	}
+
+	tc := &TopologyCheck{
+		Replicas:                  Int32Value(dep.Spec.Replicas),
+		Placement:                 make([]PodPlacement, 0),
+		PodAntiAffinity:           describeAntiAffinity(dep.Spec.Template.Spec.Affinity),
+		TopologySpreadConstraints: make([]string, 0),
+	}
+	for _, c := range dep.Spec.Template.Spec.TopologySpreadConstraints {
+		tc.TopologySpreadConstraints = append(tc.TopologySpreadConstraints,
+			fmt.Sprintf("topologyKey=%s maxSkew=%d whenUnsatisfiable=%s", c.TopologyKey, c.MaxSkew, c.WhenUnsatisfiable))
+	}
+
+	//1. Placement of the running pods
+	pods, err := getDeploymentPods(dep)
+	if err != nil {
+		log.Errorf("%v", err)
+		return nil, err
+	}
+
+	nodes, zones := make(map[string]bool), make(map[string]bool)
+	nodeZones := make(map[string]string)
+	for _, pod := range pods {
+		if pod.Spec.NodeName == "" || pod.DeletionTimestamp != nil {
+			continue
+		}
+		zone, ok := nodeZones[pod.Spec.NodeName]
+		if !ok {
+			node, err := Clientset.CoreV1().Nodes().Get(pod.Spec.NodeName, metav1.GetOptions{})
+			if err != nil {
+				log.Warnf("Failed to get node %s of pod %s: %v", pod.Spec.NodeName, pod.Name, err)
+			} else {
+				zone = nodeZone(node)
+			}
+			nodeZones[pod.Spec.NodeName] = zone
+		}
+		tc.Placement = append(tc.Placement, PodPlacement{Pod: pod.Name, Node: pod.Spec.NodeName, Zone: zone})
+		nodes[pod.Spec.NodeName] = true
+		if zone != "" {
+			zones[zone] = true
+		}
+	}
+	tc.Nodes, tc.Zones = sortedKeys(nodes), sortedKeys(zones)
+
+	//2. PodDisruptionBudget selecting the coredns pods
+	pdbList, err := listPodDisruptionBudgets(ns)
+	if err != nil {
+		log.Warnf("Failed to list PodDisruptionBudgets in %s namespace: %v", ns, err)
+		tc.PDBListError = err.Error()
+	} else {
+		podLabels := labels.Set(dep.Spec.Template.Labels)
+		for _, pdb := range pdbList.Items {
+			selector, err := metav1.LabelSelectorAsSelector(pdb.Spec.Selector)
+			if err != nil || selector.Empty() || !selector.Matches(podLabels) {
+				continue
+			}
+			desc := &PDBDescription{
+				Name:               pdb.Name,
+				DisruptionsAllowed: pdb.Status.PodDisruptionsAllowed,
+			}
+			if pdb.Spec.MinAvailable != nil {
+				desc.MinAvailable = pdb.Spec.MinAvailable.String()
+			}
+			if pdb.Spec.MaxUnavailable != nil {
+				desc.MaxUnavailable = pdb.Spec.MaxUnavailable.String()
+			}
+			tc.PodDisruptionBudget = desc
+			break
+		}
+	}
+
+	log.Infof("coredns topology: %+v", tc)
+	return tc, nil
+}
+
+//listPodDisruptionBudgets lists the PodDisruptionBudgets of the namespace with the newest served policy API version.
+//The fields used are identical in policy/v1 and policy/v1beta1.
+func listPodDisruptionBudgets(ns string) (*policyv1beta1.PodDisruptionBudgetList, error) {
+	for _, version := range pdbVersions {
+		if !hasServerResource(policyGroup+"/"+version, "poddisruptionbudgets") {
+			continue
+		}
+		raw, err := Clientset.CoreV1().RESTClient().Get().
+			AbsPath("/apis", policyGroup, version, "namespaces", ns, "poddisruptionbudgets").
+			DoRaw()
+		if err != nil {
+			return nil, fmt.Errorf("Failed to list PodDisruptionBudgets (%s/%s): %v", policyGroup, version, err)
+		}
+		list := &policyv1beta1.PodDisruptionBudgetList{}
+		if err := json.Unmarshal(raw, list); err != nil {
+			return nil, fmt.Errorf("Failed to decode PodDisruptionBudgets: %v", err)
+		}
+		return list, nil
+	}
+	return nil, fmt.Errorf("PodDisruptionBudget API is not available")
+}
+
+//sortedKeys returns the keys of a set in sorted order
+func sortedKeys(set map[string]bool) []string {
+	keys := make([]string, 0, len(set))
+	for k := range set {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+//topologyFindings reports single points of failure of the coredns replicas
+func topologyFindings(tc *TopologyCheck) []findings.Finding {
+	res := make([]findings.Finding, 0)
+	if tc == nil {
+		return res
+	}
+	const source = "corednsTopology"
+
+	placement := make([]string, 0, len(tc.Placement))
+	for _, p := range tc.Placement {
+		placement = append(placement, fmt.Sprintf("%s node=%s zone=%s", p.Pod, p.Node, p.Zone))
+	}
+
+	if tc.Replicas < 2 {
+		res = append(res, findings.New("COREDNS-HA-REPLICAS", findings.SeverityCritical, source,
+			fmt.Sprintf("coredns deployment has %d replica(s), a single failure or a node drain stops DNS resolution in the cluster", tc.Replicas),
+			"Run at least 2 coredns replicas (kubectl scale deployment coredns -n kube-system --replicas=2) or use the cluster-proportional-autoscaler.",
+			placement...))
+	}
+	if len(tc.Placement) >= 2 && len(tc.Nodes) == 1 {
+		res = append(res, findings.New("COREDNS-HA-SINGLE-NODE", findings.SeverityCritical, source,
+			fmt.Sprintf("All coredns replicas are running on the same node %s, DNS resolution fails if this node goes down", tc.Nodes[0]),
+			"Add a podAntiAffinity on kubernetes.io/hostname to the coredns deployment and restart it so that replicas are spread across nodes.",
+			placement...))
+	}
+	if len(tc.Placement) >= 2 && len(tc.Zones) == 1 {
+		res = append(res, findings.New("COREDNS-HA-SINGLE-AZ", findings.SeverityWarning, source,
+			fmt.Sprintf("All coredns replicas are running in the same availability zone %s, an AZ outage stops DNS resolution in the cluster", tc.Zones[0]),
+			"Add a topologySpreadConstraint (or preferred podAntiAffinity) on topology.kubernetes.io/zone and make sure the node groups span multiple AZs.",
+			placement...))
+	}
+	if len(tc.PodAntiAffinity) == 0 && len(tc.TopologySpreadConstraints) == 0 {
+		res = append(res, findings.New("COREDNS-HA-NO-SPREAD", findings.SeverityInfo, source,
+			"coredns deployment does not define podAntiAffinity or topologySpreadConstraints, the scheduler may place all the replicas on the same node or AZ",
+			"Add a preferred podAntiAffinity on kubernetes.io/hostname and a topologySpreadConstraint on topology.kubernetes.io/zone."))
+	}
+	//without the list of PodDisruptionBudgets a missing one cannot be told apart from an unreadable one
+	if tc.PodDisruptionBudget == nil && tc.PDBListError == "" {
+		res = append(res, findings.New("COREDNS-HA-NO-PDB", findings.SeverityWarning, source,
+			"No PodDisruptionBudget covers the coredns pods, a node drain or upgrade can evict all the replicas at the same time",
+			"Create a PodDisruptionBudget for the coredns pods with maxUnavailable: 1."))
+	} else if tc.PodDisruptionBudget != nil && tc.PodDisruptionBudget.DisruptionsAllowed == 0 {
+		pdb := tc.PodDisruptionBudget
+		res = append(res, findings.New("COREDNS-HA-PDB-NO-DISRUPTIONS", findings.SeverityInfo, source,
+			fmt.Sprintf("PodDisruptionBudget %s currently allows no disruptions of coredns pods, node drains will be blocked", pdb.Name),
+			"Make sure enough coredns replicas are healthy, or relax the PodDisruptionBudget.",
+			fmt.Sprintf("minAvailable=%s maxUnavailable=%s", pdb.MinAvailable, pdb.MaxUnavailable)))
+	}
+	return res
+}
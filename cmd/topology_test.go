@@ -0,0 +1,43 @@
+package main
+
+import (
+	"reflect"
+	"sort"
+	"testing"
+)
+
+func TestTopologyFindings(t *testing.T) {
+	spread := []PodPlacement{
+		{Pod: "coredns-a", Node: "node-1", Zone: "us-east-1a"},
+		{Pod: "coredns-b", Node: "node-2", Zone: "us-east-1b"},
+	}
+	affinity := []string{"preferred(weight=100) topologyKey=kubernetes.io/hostname selector=k8s-app=kube-dns"}
+	tests := []struct {
+		name string
+		tc   *TopologyCheck
+		want []string
+	}{
+		{"healthy", &TopologyCheck{Replicas: 2, Placement: spread, Nodes: []string{"node-1", "node-2"}, Zones: []string{"us-east-1a", "us-east-1b"}, PodAntiAffinity: affinity,
+			PodDisruptionBudget: &PDBDescription{Name: "coredns", MaxUnavailable: "1", DisruptionsAllowed: 1}}, []string{}},
+		{"no pdb", &TopologyCheck{Replicas: 2, Placement: spread, Nodes: []string{"node-1", "node-2"}, Zones: []string{"us-east-1a", "us-east-1b"}, PodAntiAffinity: affinity},
+			[]string{"COREDNS-HA-NO-PDB"}},
+		{"pdb list error", &TopologyCheck{Replicas: 2, Placement: spread, Nodes: []string{"node-1", "node-2"}, Zones: []string{"us-east-1a", "us-east-1b"}, PodAntiAffinity: affinity,
+			PDBListError: "poddisruptionbudgets.policy is forbidden"}, []string{}},
+		{"pdb without disruptions", &TopologyCheck{Replicas: 2, Placement: spread, Nodes: []string{"node-1", "node-2"}, Zones: []string{"us-east-1a", "us-east-1b"}, PodAntiAffinity: affinity,
+			PodDisruptionBudget: &PDBDescription{Name: "coredns", MinAvailable: "2"}}, []string{"COREDNS-HA-PDB-NO-DISRUPTIONS"}},
+		{"single replica", &TopologyCheck{Replicas: 1, Placement: spread[:1], Nodes: []string{"node-1"}, Zones: []string{"us-east-1a"},
+			PDBListError: "the server could not find the requested resource"}, []string{"COREDNS-HA-NO-SPREAD", "COREDNS-HA-REPLICAS"}},
+		{"same node and zone", &TopologyCheck{Replicas: 2, Placement: spread, Nodes: []string{"node-1"}, Zones: []string{"us-east-1a"}, PodAntiAffinity: affinity,
+			PodDisruptionBudget: &PDBDescription{Name: "coredns", MaxUnavailable: "1", DisruptionsAllowed: 1}}, []string{"COREDNS-HA-SINGLE-AZ", "COREDNS-HA-SINGLE-NODE"}},
+	}
+	for _, tt := range tests {
+		got := make([]string, 0)
+		for _, f := range topologyFindings(tt.tc) {
+			got = append(got, f.ID)
+		}
+		sort.Strings(got)
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: got findings %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
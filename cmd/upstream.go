@@ -0,0 +1,262 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"sort"
+	"strings"
+
+	"github.com/joshisumit/eks-dns-troubleshooter/pkg/aws"
+	"github.com/joshisumit/eks-dns-troubleshooter/pkg/findings"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	sourceCorefile   = "Corefile"
+	sourceResolvConf = "/etc/resolv.conf of the node"
+
+	upstreamVerdictOK      = "ok"
+	upstreamVerdictCoredns = "coredns"
+	upstreamVerdictPartial = "upstream-partial"
+	upstreamVerdictDown    = "upstream"
+)
+
+//ForwardTarget is an upstream server which coredns forwards a zone to
+type ForwardTarget struct {
+	Zone    string `json:"zone"`
+	Address string `json:"address"`
+	Source  string `json:"source"`
+}
+
+//UpstreamComparison compares the resolution of a domain through coredns with direct queries to its forward targets
+type UpstreamComparison struct {
+	Domain        string            `json:"domain"`
+	CorednsResult string            `json:"corednsResult"`
+	Upstreams     map[string]string `json:"upstreamResults"`
+	AnswersDiffer bool              `json:"answersDiffer,omitempty"`
+	//CorednsAnswer and DifferingAnswers are only set when the answers differ
+	CorednsAnswer    []string `json:"corednsAnswer,omitempty"`
+	DifferingAnswers []string `json:"differingAnswers,omitempty"`
+	Verdict          string   `json:"verdict"`
+}
+
+//UpstreamCheck stores the forward targets of coredns and the comparison of direct queries with queries through coredns
+type UpstreamCheck struct {
+	Targets    []ForwardTarget          `json:"targets"`
+	Skipped    []string                 `json:"skippedTargets,omitempty"`
+	Results    []DnsTestResultForDomain `json:"results,omitempty"`
+	Comparison []UpstreamComparison     `json:"comparison,omitempty"`
+}
+
+//sameAnswers returns true if both answers hold the same records, DNS servers rotate the order of A records
+func sameAnswers(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	sortedA, sortedB := append([]string(nil), a...), append([]string(nil), b...)
+	sort.Strings(sortedA)
+	sort.Strings(sortedB)
+	for i := range sortedA {
+		if sortedA[i] != sortedB[i] {
+			return false
+		}
+	}
+	return true
+}
+
+//nodeResolvers returns the nameservers of /etc/resolv.conf on the nodes, derived from the DHCP options set of the VPC
+func nodeResolvers(vpc *aws.VPCDNSCheck) []string {
+	if vpc != nil && !vpc.UsesAmazonProvidedDNS && len(vpc.DomainNameServers) > 0 {
+		return vpc.DomainNameServers
+	}
+	resolvers := make([]string, 0, 2)
+	if vpc != nil && vpc.AmazonDNSIP != "" {
+		resolvers = append(resolvers, vpc.AmazonDNSIP)
+	}
+	return append(resolvers, aws.AmazonDNSLinkLocalIP)
+}
+
+//forwardTargets returns the targets of every forward plugin of the Corefile, resolving /etc/resolv.conf to the node resolvers
+func forwardTargets(corefile string, vpc *aws.VPCDNSCheck) ([]ForwardTarget, []string, error) {
+	blocks, err := parseServerBlocks("Corefile", corefile)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	targets, skipped := make([]ForwardTarget, 0), make([]string, 0)
+	for _, block := range blocks {
+		blockZone := "."
+		if len(block.Keys) > 0 {
+			blockZone = strings.SplitN(strings.TrimPrefix(block.Keys[0], "dns://"), ":", 2)[0]
+		}
+		for _, args := range directiveArgs(block, "forward") {
+			if len(args) < 2 {
+				continue
+			}
+			zone := args[0]
+			if zone == "." {
+				zone = blockZone
+			}
+			for _, to := range args[1:] {
+				switch {
+				case strings.HasPrefix(to, "/"):
+					for _, ip := range nodeResolvers(vpc) {
+						targets = append(targets, ForwardTarget{Zone: zone, Address: ip, Source: fmt.Sprintf("%s (%s)", sourceResolvConf, to)})
+					}
+				case strings.Contains(to, "://") && !strings.HasPrefix(to, "dns://"):
+					skipped = append(skipped, fmt.Sprintf("%s %s: only plain DNS targets are queried", zone, to))
+				default:
+					addr := strings.TrimPrefix(to, "dns://")
+					if host, port, err := net.SplitHostPort(addr); err == nil && port == "53" {
+						addr = host
+					}
+					targets = append(targets, ForwardTarget{Zone: zone, Address: addr, Source: sourceCorefile})
+				}
+			}
+		}
+	}
+	return targets, skipped, nil
+}
+
+//zoneMatches returns true if the domain belongs to the zone
+func zoneMatches(domain, zone string) bool {
+	zone = strings.TrimSuffix(zone, ".")
+	domain = strings.TrimSuffix(domain, ".")
+	return zone == "" || domain == zone || strings.HasSuffix(domain, "."+zone)
+}
+
+//targetsFor returns the targets of the most specific zone which contains the domain
+func targetsFor(targets []ForwardTarget, domain string) []ForwardTarget {
+	best, res := -1, make([]ForwardTarget, 0)
+	for _, t := range targets {
+		if !zoneMatches(domain, t.Zone) {
+			continue
+		}
+		l := len(strings.TrimSuffix(t.Zone, "."))
+		if l > best {
+			best, res = l, res[:0]
+		}
+		if l == best {
+			res = append(res, t)
+		}
+	}
+	return res
+}
+
+//checkUpstreams queries the forward targets of coredns directly with the external test domains
+//and compares the results with the queries sent through the coredns ClusterIP
+func checkUpstreams(ns string, cd *Coredns, vpc *aws.VPCDNSCheck) (*UpstreamCheck, error) {
+	corefile, err := getCorefile(ns)
+	if err != nil {
+		return nil, err
+	}
+	check := &UpstreamCheck{}
+	check.Targets, check.Skipped, err = forwardTargets(corefile, vpc)
+	if err != nil {
+		return nil, err
+	}
+
+	//results through coredns (ClusterIP over UDP) for every external test domain
+	clusterDomain := cd.ResolvConf.clusterDomain()
+	viaCoredns := make(map[string]DnsTestResultForDomain)
+	for _, r := range cd.Dnstest.DnsTestResultForDomains {
+		if r.ServerType == serverTypeClusterIP && r.Transport == transportUDP && !isClusterName(r.DomainName, clusterDomain) {
+			viaCoredns[r.DomainName] = r
+		}
+	}
+	domains := make([]string, 0, len(viaCoredns))
+	for d := range viaCoredns {
+		domains = append(domains, d)
+	}
+	sort.Strings(domains)
+
+	for _, domain := range domains {
+		coredns := viaCoredns[domain]
+		cmp := UpstreamComparison{Domain: domain, CorednsResult: coredns.Result, Upstreams: make(map[string]string)}
+		ok := 0
+		targets := targetsFor(check.Targets, domain)
+		for _, t := range targets {
+			result := lookupIP(domain, t.Address, transportUDP)
+			result.ServerType = "upstream"
+			check.Results = append(check.Results, *result)
+			cmp.Upstreams[t.Address] = result.Result
+			if result.Result == "success" {
+				ok++
+				if coredns.Result == "success" && !sameAnswers(result.Answer, coredns.Answer) {
+					cmp.AnswersDiffer = true
+					cmp.DifferingAnswers = append(cmp.DifferingAnswers, fmt.Sprintf("direct to %s: %s", t.Address, strings.Join(result.Answer, ",")))
+				}
+			}
+		}
+
+		if cmp.AnswersDiffer {
+			cmp.CorednsAnswer = coredns.Answer
+		}
+		switch {
+		case len(targets) == 0:
+			continue
+		case coredns.Result == "success":
+			cmp.Verdict = upstreamVerdictOK
+		case ok == len(targets):
+			cmp.Verdict = upstreamVerdictCoredns
+		case ok > 0:
+			cmp.Verdict = upstreamVerdictPartial
+		default:
+			cmp.Verdict = upstreamVerdictDown
+		}
+		check.Comparison = append(check.Comparison, cmp)
+	}
+
+	log.Infof("Upstream check: %+v", check)
+	return check, nil
+}
+
+//upstreamFindings tells whether coredns or its upstream servers are the cause of failed external names
+func upstreamFindings(check *UpstreamCheck) []findings.Finding {
+	res := make([]findings.Finding, 0)
+	if check == nil {
+		return res
+	}
+	const source = "upstream"
+
+	for _, c := range check.Comparison {
+		evidence := []string{fmt.Sprintf("via coredns: %s", c.CorednsResult)}
+		for _, addr := range sortedStringKeys(c.Upstreams) {
+			evidence = append(evidence, fmt.Sprintf("direct to %s: %s", addr, c.Upstreams[addr]))
+		}
+		switch c.Verdict {
+		case upstreamVerdictCoredns:
+			res = append(res, findings.New("UPSTREAM-OK-COREDNS-FAILS", findings.SeverityCritical, source,
+				fmt.Sprintf("%s resolves when querying the upstream servers directly but fails through coredns, the problem is in coredns or the path from the coredns pods to the upstream", c.Domain),
+				"Check the forward plugin configuration, the coredns logs (i/o timeout, loop) and the security groups/NACLs of the coredns nodes towards the upstream servers.",
+				evidence...))
+		case upstreamVerdictDown:
+			res = append(res, findings.New("UPSTREAM-FAILS", findings.SeverityCritical, source,
+				fmt.Sprintf("%s fails both through coredns and when querying every upstream server directly, the upstream DNS servers are the problem", c.Domain),
+				"Check the VPC DNS attributes, the DHCP options set, Route 53 Resolver rules and the reachability of custom DNS servers.",
+				evidence...))
+		case upstreamVerdictPartial:
+			res = append(res, findings.New("UPSTREAM-PARTIALLY-FAILS", findings.SeverityWarning, source,
+				fmt.Sprintf("%s fails through coredns and only some upstream servers answer when queried directly", c.Domain),
+				"Remove or fix the failing upstream servers in the forward plugin, or the DHCP options set of the VPC.",
+				evidence...))
+		}
+		if c.AnswersDiffer {
+			res = append(res, findings.New("UPSTREAM-ANSWERS-DIFFER", findings.SeverityInfo, source,
+				fmt.Sprintf("%s resolves to different records through coredns than when querying the upstream servers directly, coredns may serve a stale cache or the upstream servers disagree (split-horizon zones, geo or weighted records)", c.Domain),
+				"Compare the answers of every upstream server, check the cache plugin TTL and make sure all the forward targets serve the same zones.",
+				append([]string{fmt.Sprintf("via coredns: %s", strings.Join(c.CorednsAnswer, ","))}, c.DifferingAnswers...)...))
+		}
+	}
+	return res
+}
+
+//sortedStringKeys returns the sorted keys of a map of strings
+func sortedStringKeys(m map[string]string) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,74 @@
+package main
+
+import "testing"
+
+func TestTargetsFor(t *testing.T) {
+	targets := []ForwardTarget{
+		{Zone: ".", Address: "10.0.0.2:53"},
+		{Zone: "corp.example.com.", Address: "172.16.0.10:53"},
+		{Zone: "corp.example.com.", Address: "172.16.0.11:53"},
+		{Zone: "dev.corp.example.com", Address: "172.16.1.10:53"},
+	}
+	tests := []struct {
+		domain string
+		want   []string
+	}{
+		{"amazon.com", []string{"10.0.0.2:53"}},
+		{"host.corp.example.com", []string{"172.16.0.10:53", "172.16.0.11:53"}},
+		{"corp.example.com.", []string{"172.16.0.10:53", "172.16.0.11:53"}},
+		{"api.dev.corp.example.com", []string{"172.16.1.10:53"}},
+		{"notcorp.example.com", []string{"10.0.0.2:53"}},
+	}
+	for _, tt := range tests {
+		got := targetsFor(targets, tt.domain)
+		addresses := make([]string, 0, len(got))
+		for _, target := range got {
+			addresses = append(addresses, target.Address)
+		}
+		if len(addresses) != len(tt.want) {
+			t.Errorf("targetsFor(%q) = %v, want %v", tt.domain, addresses, tt.want)
+			continue
+		}
+		for i := range addresses {
+			if addresses[i] != tt.want[i] {
+				t.Errorf("targetsFor(%q) = %v, want %v", tt.domain, addresses, tt.want)
+				break
+			}
+		}
+	}
+	if got := targetsFor(targets[1:], "amazon.com"); len(got) != 0 {
+		t.Errorf("expected no targets without a root zone, got %v", got)
+	}
+}
+
+func TestSameAnswers(t *testing.T) {
+	tests := []struct {
+		a, b []string
+		want bool
+	}{
+		{[]string{"52.94.236.248", "54.239.28.85", "205.251.242.103"}, []string{"205.251.242.103", "52.94.236.248", "54.239.28.85"}, true},
+		{[]string{"52.94.236.248"}, []string{"54.239.28.85"}, false},
+		{[]string{"52.94.236.248"}, []string{"52.94.236.248", "54.239.28.85"}, false},
+		{nil, nil, true},
+	}
+	for _, tt := range tests {
+		if got := sameAnswers(tt.a, tt.b); got != tt.want {
+			t.Errorf("sameAnswers(%v, %v) = %t, want %t", tt.a, tt.b, got, tt.want)
+		}
+	}
+}
+
+func TestUpstreamFindingsAnswersDiffer(t *testing.T) {
+	check := &UpstreamCheck{Comparison: []UpstreamComparison{
+		{Domain: "amazon.com", CorednsResult: "success", Upstreams: map[string]string{"10.0.0.2:53": "success"}, Verdict: upstreamVerdictOK},
+		{Domain: "corp.example.com", CorednsResult: "success", Upstreams: map[string]string{"172.16.0.10:53": "success"}, Verdict: upstreamVerdictOK,
+			AnswersDiffer: true, CorednsAnswer: []string{"10.1.0.5"}, DifferingAnswers: []string{"direct to 172.16.0.10:53: 10.1.0.6"}},
+	}}
+	res := upstreamFindings(check)
+	if len(res) != 1 || res[0].ID != "UPSTREAM-ANSWERS-DIFFER" {
+		t.Fatalf("got findings %+v, want UPSTREAM-ANSWERS-DIFFER", res)
+	}
+	if want := []string{"via coredns: 10.1.0.5", "direct to 172.16.0.10:53: 10.1.0.6"}; len(res[0].Evidence) != 2 || res[0].Evidence[0] != want[0] || res[0].Evidence[1] != want[1] {
+		t.Errorf("evidence = %v, want %v", res[0].Evidence, want)
+	}
+}
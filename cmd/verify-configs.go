@@ -1,6 +1,7 @@
 package main
 
 import (
+	"os"
 	"strings"
 
 	log "github.com/sirupsen/logrus"
@@ -23,37 +24,6 @@ func getClusterIP(ns string) (string, error) {
 	return clusterIP, err
 }
 
-//checkServieEndpoint
-func checkServieEndpoint(ns string) ([]string, []string, error) {
-	api := Clientset.CoreV1()
-
-	endpoints, err := api.Endpoints(ns).Get("kube-dns", metav1.GetOptions{})
-	if err != nil {
-		log.Fatalf("kube-dns endpoints does not exist %s", err)
-		return nil, nil, err
-		//redirect to central suggestion function
-	}
-
-	eips := make([]string, 0)
-	notReadyEIP := make([]string, 0)
-
-	log.Infof("Endpoints addresses: %v", endpoints.Subsets[0])
-
-	for _, addr := range endpoints.Subsets[0].Addresses {
-		log.Infof("Endpoints endpoints.Subsets[0].Addresses: %v", endpoints.Subsets[0].Addresses)
-		eips = append(eips, addr.IP)
-	}
-	log.Infof("kube-dns endpoint IPs: %v length: %d", eips, len(eips))
-	for _, addr := range endpoints.Subsets[0].NotReadyAddresses {
-		log.Infof("Endpoints endpoints.Subsets[0].NotReadyAddresses: %v", endpoints.Subsets[0].NotReadyAddresses)
-		notReadyEIP = append(notReadyEIP, addr.IP)
-		log.Infof("Coredns pod IPs which are not ready: %s", addr.IP)
-		//redirect to main...where inform that multiple Coredns pods are in notReady status
-	}
-	log.Infof("kube-dns notReadyEIPs: %v length: %d", notReadyEIP, len(notReadyEIP))
-	return eips, notReadyEIP, err
-}
-
 // Int32Value returns the value of the int pointer passed in or
 // 0 if the pointer is nil.
 func Int32Value(i *int32) int32 {
@@ -73,16 +43,21 @@ func checkPodVersion(ns string, cd *Coredns) (string, []string, int32, error) {
 
 	dep, err := Clientset.AppsV1().Deployments(ns).Get("coredns", getOptions)
 	if err != nil {
-		log.Fatalf("Failed to check coredns deployment %s", err)
+		log.Errorf("Failed to check coredns deployment %s", err)
+		return "", nil, 0, err
 	}
 
 	replicas := Int32Value(dep.Spec.Replicas)
 	log.Infof("There are %d replicas of coredns pods running:", replicas)
 
 	img := dep.Spec.Template.Spec.Containers[0].Image
-	image := strings.Split(img, ":")
+	ref, err := parseImageRef(img)
+	if err != nil {
+		log.Errorf("Failed to parse coredns image %q: %s", img, err)
+		return "", nil, replicas, err
+	}
 
-	name, tag := image[0], image[1]
+	name, tag := ref.Repository, ref.Tag
 	cd.ImageVersion = tag
 
 	log.Infof("Image version: %s %s", name, tag)
@@ -94,7 +69,8 @@ func checkPodVersion(ns string, cd *Coredns) (string, []string, int32, error) {
 
 	podList, err := Clientset.CoreV1().Pods(ns).List(listOptions)
 	if err != nil {
-		log.Fatalf("Failed to check coredns pod List %s", err)
+		log.Errorf("Failed to check coredns pod List %s", err)
+		return tag, nil, replicas, err
 	}
 
 	podNames := make([]string, 0)
@@ -129,7 +105,7 @@ func (cd *Coredns) testDNS() {
 
 	//2. Match nameserver in /etc/resolv.conf with ClusterIP ->it should match
 	//from the nameserver IP -> check its coredns or nodeLocalDNSCache
-	dnstest.Description = "tests the internal and external DNS queries against ClusterIP and two Coredns Pod IPs"
+	dnstest.Description = "tests the internal and external DNS queries against ClusterIP and every Coredns Pod IP over UDP and TCP"
 
 	if rc.Nameserver[0] == cd.ClusterIP {
 		log.Infof("Pod's nameserver is matching to ClusterIP: %s", rc.Nameserver[0])
@@ -145,20 +121,29 @@ func (cd *Coredns) testDNS() {
 	//Fqdn() just adds . at the end of the query
 	//If you make query for "kuberenetes" then query will be sent to COREDNS as "kubernetes."
 	//Due to that used FQDN for kubernetes like kubernetes.default.svc.cluster.local
-	domains := []string{"amazon.com", "kubernetes.default.svc.cluster.local"}
+	clusterDomain := rc.clusterDomain()
+	domains := testDomains(clusterDomain)
 	dnstest.DomainsTested = domains
 
-	nameservers := make([]string, 0, 3)
-	nameservers = append(nameservers, rc.Nameserver...)
-	nameservers = append(nameservers, cd.EndpointsIP[:2]...) //select only 2 endpoints
+	//nameservers to test: ClusterIP, every coredns endpoint and the nameserver of the pod (if different, e.g. NodeLocal DNSCache)
+	nameservers := []DnsTestResultForDomain{{Server: cd.ClusterIP, ServerType: serverTypeClusterIP}}
+	for _, eip := range cd.EndpointsIP {
+		nameservers = append(nameservers, DnsTestResultForDomain{Server: eip, ServerType: serverTypeEndpoint})
+	}
+	if len(rc.Nameserver) > 0 && rc.Nameserver[0] != cd.ClusterIP {
+		nameservers = append(nameservers, DnsTestResultForDomain{Server: rc.Nameserver[0], ServerType: serverTypeNameserver})
+	}
 
-	//tests each DOMAIN against 3 NAMESERVERS (i.e. 1 ClusterIP and 2 COREDNS ENDPOINTS)
+	//tests each DOMAIN against each NAMESERVER over UDP and TCP (server x domain x transport)
 	dnstest.DnsTestResultForDomains = make([]DnsTestResultForDomain, 0)
 
 	for _, dom := range domains {
 		for _, ns := range nameservers {
-			result := lookupIP(dom, []string{ns})
-			dnstest.DnsTestResultForDomains = append(dnstest.DnsTestResultForDomains, *result)
+			for _, transport := range []string{transportUDP, transportTCP} {
+				result := lookupIP(dom, ns.Server, transport)
+				result.ServerType = ns.ServerType
+				dnstest.DnsTestResultForDomains = append(dnstest.DnsTestResultForDomains, *result)
+			}
 		}
 	}
 
@@ -169,11 +154,34 @@ func (cd *Coredns) testDNS() {
 	}
 	if successCount != len(dnstest.DnsTestResultForDomains) {
 		dnstest.DnsResolution = "failed"
+	} else {
+		dnstest.DnsResolution = "success"
 	}
-	dnstest.DnsResolution = "success"
+
+	//4. Infer the failing layer from the result matrix
+	dnstest.Diagnosis = diagnoseDNSResults(dnstest.DnsTestResultForDomains, clusterDomain)
+	log.Infof("DNS test diagnosis: %+v", dnstest.Diagnosis)
 
 	cd.Dnstest = *dnstest
 	//cd.Dnstest = success
 	log.Debugf("DNS test completed: %v *dnstest: %v", cd.Dnstest, *dnstest)
 
 }
+
+//testDomains returns the domains used for DNS tests: one external name, the kubernetes service and the names from envTestDomains
+func testDomains(clusterDomain string) []string {
+	domains := []string{"amazon.com", "kubernetes.default.svc." + clusterDomain}
+	return append(domains, configuredTestDomains()...)
+}
+
+//configuredTestDomains returns the additional domains configured in envTestDomains (e.g. internal domains)
+func configuredTestDomains() []string {
+	domains := make([]string, 0)
+	for _, d := range strings.Split(os.Getenv(envTestDomains), ",") {
+		d = strings.TrimSpace(d)
+		if d != "" {
+			domains = append(domains, d)
+		}
+	}
+	return domains
+}
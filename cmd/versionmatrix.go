@@ -0,0 +1,307 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+
+	"github.com/joshisumit/eks-dns-troubleshooter/pkg/findings"
+	log "github.com/sirupsen/logrus"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/yaml"
+)
+
+const (
+	//envVersionMatrix points to a YAML/JSON file which overrides entries of the built-in version matrix
+	envVersionMatrix = "EKS_DNS_VERSION_MATRIX"
+
+	componentCoredns   = "coredns"
+	componentKubeProxy = "kube-proxy"
+	componentVpcCni    = "vpc-cni"
+
+	versionStatusRecommended  = "recommended"
+	versionStatusOutdated     = "outdated"
+	versionStatusNewer        = "newer"
+	versionStatusBelowMinimum = "belowMinimum"
+	versionStatusKnownBad     = "knownBad"
+	versionStatusUnknown      = "unknown"
+)
+
+//ComponentVersions stores the recommended, minimum and known-bad versions of an add-on for a Kubernetes version
+type ComponentVersions struct {
+	Recommended string            `json:"recommended"`
+	Minimum     string            `json:"minimum"`
+	KnownBad    map[string]string `json:"knownBad,omitempty"`
+}
+
+//VersionMatrix is keyed by Kubernetes minor version (e.g. "1.16") and add-on type (e.g. "coredns")
+type VersionMatrix map[string]map[string]ComponentVersions
+
+//corednsKnownBad lists coredns versions with well known problems on EKS
+var corednsKnownBad = map[string]string{
+	"v1.5.0": "proxy plugin was removed, Corefiles which still use proxy fail to load",
+}
+
+//defaultVersionMatrix contains the versions published in the EKS user guide.
+//Update this table when new versions are released, or override entries with EKS_DNS_VERSION_MATRIX.
+var defaultVersionMatrix = VersionMatrix{
+	"1.14": {
+		componentCoredns:   {Recommended: "v1.6.6", Minimum: "v1.3.1", KnownBad: corednsKnownBad},
+		componentKubeProxy: {Recommended: "v1.14.9", Minimum: "v1.14.0"},
+		componentVpcCni:    {Recommended: "v1.7.5", Minimum: "v1.6.3"},
+	},
+	"1.15": {
+		componentCoredns:   {Recommended: "v1.6.6", Minimum: "v1.6.6", KnownBad: corednsKnownBad},
+		componentKubeProxy: {Recommended: "v1.15.11", Minimum: "v1.15.0"},
+		componentVpcCni:    {Recommended: "v1.7.5", Minimum: "v1.6.3"},
+	},
+	"1.16": {
+		componentCoredns:   {Recommended: "v1.6.6", Minimum: "v1.6.6", KnownBad: corednsKnownBad},
+		componentKubeProxy: {Recommended: "v1.16.13", Minimum: "v1.16.0"},
+		componentVpcCni:    {Recommended: "v1.7.5", Minimum: "v1.6.3"},
+	},
+	"1.17": {
+		componentCoredns:   {Recommended: "v1.6.6", Minimum: "v1.6.6", KnownBad: corednsKnownBad},
+		componentKubeProxy: {Recommended: "v1.17.9", Minimum: "v1.17.0"},
+		componentVpcCni:    {Recommended: "v1.7.5", Minimum: "v1.6.3"},
+	},
+	"1.18": {
+		componentCoredns:   {Recommended: "v1.7.0", Minimum: "v1.6.6", KnownBad: corednsKnownBad},
+		componentKubeProxy: {Recommended: "v1.18.8", Minimum: "v1.18.0"},
+		componentVpcCni:    {Recommended: "v1.7.5", Minimum: "v1.7.5"},
+	},
+	"1.19": {
+		componentCoredns:   {Recommended: "v1.8.0", Minimum: "v1.7.0", KnownBad: corednsKnownBad},
+		componentKubeProxy: {Recommended: "v1.19.6", Minimum: "v1.19.0"},
+		componentVpcCni:    {Recommended: "v1.7.5", Minimum: "v1.7.5"},
+	},
+	"1.20": {
+		componentCoredns:   {Recommended: "v1.8.3", Minimum: "v1.8.0", KnownBad: corednsKnownBad},
+		componentKubeProxy: {Recommended: "v1.20.4", Minimum: "v1.20.0"},
+		componentVpcCni:    {Recommended: "v1.10.1", Minimum: "v1.7.5"},
+	},
+	"1.21": {
+		componentCoredns:   {Recommended: "v1.8.4", Minimum: "v1.8.3", KnownBad: corednsKnownBad},
+		componentKubeProxy: {Recommended: "v1.21.2", Minimum: "v1.21.0"},
+		componentVpcCni:    {Recommended: "v1.10.1", Minimum: "v1.7.5"},
+	},
+	"1.22": {
+		componentCoredns:   {Recommended: "v1.8.7", Minimum: "v1.8.4", KnownBad: corednsKnownBad},
+		componentKubeProxy: {Recommended: "v1.22.6", Minimum: "v1.22.0"},
+		componentVpcCni:    {Recommended: "v1.11.4", Minimum: "v1.10.1"},
+	},
+	"1.23": {
+		componentCoredns:   {Recommended: "v1.8.7", Minimum: "v1.8.7", KnownBad: corednsKnownBad},
+		componentKubeProxy: {Recommended: "v1.23.7", Minimum: "v1.23.0"},
+		componentVpcCni:    {Recommended: "v1.11.4", Minimum: "v1.10.1"},
+	},
+	"1.24": {
+		componentCoredns:   {Recommended: "v1.9.3", Minimum: "v1.8.7", KnownBad: corednsKnownBad},
+		componentKubeProxy: {Recommended: "v1.24.7", Minimum: "v1.24.0"},
+		componentVpcCni:    {Recommended: "v1.12.0", Minimum: "v1.11.4"},
+	},
+	"1.25": {
+		componentCoredns:   {Recommended: "v1.9.3", Minimum: "v1.9.3", KnownBad: corednsKnownBad},
+		componentKubeProxy: {Recommended: "v1.25.6", Minimum: "v1.25.0"},
+		componentVpcCni:    {Recommended: "v1.12.2", Minimum: "v1.12.0"},
+	},
+	"1.26": {
+		componentCoredns:   {Recommended: "v1.9.3", Minimum: "v1.9.3", KnownBad: corednsKnownBad},
+		componentKubeProxy: {Recommended: "v1.26.2", Minimum: "v1.26.0"},
+		componentVpcCni:    {Recommended: "v1.12.5", Minimum: "v1.12.0"},
+	},
+	"1.27": {
+		componentCoredns:   {Recommended: "v1.10.1", Minimum: "v1.9.3", KnownBad: corednsKnownBad},
+		componentKubeProxy: {Recommended: "v1.27.1", Minimum: "v1.27.0"},
+		componentVpcCni:    {Recommended: "v1.13.0", Minimum: "v1.12.0"},
+	},
+	"1.28": {
+		componentCoredns:   {Recommended: "v1.10.1", Minimum: "v1.10.1", KnownBad: corednsKnownBad},
+		componentKubeProxy: {Recommended: "v1.28.1", Minimum: "v1.28.0"},
+		componentVpcCni:    {Recommended: "v1.15.0", Minimum: "v1.13.0"},
+	},
+	"1.29": {
+		componentCoredns:   {Recommended: "v1.11.1", Minimum: "v1.10.1", KnownBad: corednsKnownBad},
+		componentKubeProxy: {Recommended: "v1.29.0", Minimum: "v1.29.0"},
+		componentVpcCni:    {Recommended: "v1.16.0", Minimum: "v1.15.0"},
+	},
+	"1.30": {
+		componentCoredns:   {Recommended: "v1.11.1", Minimum: "v1.11.1", KnownBad: corednsKnownBad},
+		componentKubeProxy: {Recommended: "v1.30.0", Minimum: "v1.30.0"},
+		componentVpcCni:    {Recommended: "v1.18.1", Minimum: "v1.16.0"},
+	},
+}
+
+//VersionCheck stores the running version of an add-on compared with the version matrix
+type VersionCheck struct {
+	Component         string   `json:"component"`
+	Image             string   `json:"image"`
+	ImageRef          ImageRef `json:"imageRef"`
+	CurrentVersion    string   `json:"currentVersion"`
+	KubernetesVersion string   `json:"kubernetesVersion"`
+	Recommended       string   `json:"recommendedVersion,omitempty"`
+	Minimum           string   `json:"minimumVersion,omitempty"`
+	Status            string   `json:"status"`
+	Reason            string   `json:"reason,omitempty"`
+}
+
+//loadVersionMatrix returns the built-in version matrix merged with the entries of envVersionMatrix
+func loadVersionMatrix() (VersionMatrix, error) {
+	matrix := make(VersionMatrix)
+	for k8sVersion, components := range defaultVersionMatrix {
+		matrix[k8sVersion] = make(map[string]ComponentVersions)
+		for name, cv := range components {
+			matrix[k8sVersion][name] = cv
+		}
+	}
+
+	path := os.Getenv(envVersionMatrix)
+	if path == "" {
+		return matrix, nil
+	}
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to read version matrix file %q: %v", path, err)
+	}
+	custom := make(VersionMatrix)
+	err = yaml.Unmarshal(content, &custom)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to parse version matrix file %q: %v", path, err)
+	}
+	log.Infof("Loaded version matrix overrides for %d Kubernetes versions from %s", len(custom), path)
+	for k8sVersion, components := range custom {
+		if _, ok := matrix[k8sVersion]; !ok {
+			matrix[k8sVersion] = make(map[string]ComponentVersions)
+		}
+		for name, cv := range components {
+			matrix[k8sVersion][name] = cv
+		}
+	}
+	return matrix, nil
+}
+
+//evaluate compares the image of an add-on with the version matrix for the given Kubernetes version
+func (m VersionMatrix) evaluate(component, image, k8sGitVersion string) VersionCheck {
+	vc := VersionCheck{
+		Component: component,
+		Image:     image,
+		Status:    versionStatusUnknown,
+	}
+
+	ref, err := parseImageRef(image)
+	if err != nil {
+		vc.Reason = err.Error()
+		return vc
+	}
+	vc.ImageRef, vc.CurrentVersion = ref, ref.Tag
+
+	k8sVersion, err := parseVersion(k8sGitVersion)
+	if err != nil {
+		vc.Reason = fmt.Sprintf("unable to parse Kubernetes version: %v", err)
+		return vc
+	}
+	vc.KubernetesVersion = k8sVersion.minorVersion()
+
+	cv, ok := m[vc.KubernetesVersion][component]
+	if !ok {
+		vc.Reason = fmt.Sprintf("version matrix has no entry for %s on Kubernetes %s", component, vc.KubernetesVersion)
+		return vc
+	}
+	vc.Recommended, vc.Minimum = cv.Recommended, cv.Minimum
+
+	current, err := parseVersion(ref.Tag)
+	if err != nil {
+		//image pinned by digest only or with a custom tag
+		vc.Reason = fmt.Sprintf("unable to detect version from image tag %q", ref.Tag)
+		return vc
+	}
+
+	for bad, reason := range cv.KnownBad {
+		if badVersion, err := parseVersion(bad); err == nil && badVersion.compare(current) == 0 {
+			vc.Status, vc.Reason = versionStatusKnownBad, reason
+			return vc
+		}
+	}
+
+	if minimum, err := parseVersion(cv.Minimum); err == nil && current.compare(minimum) < 0 {
+		vc.Status = versionStatusBelowMinimum
+		vc.Reason = fmt.Sprintf("%s is older than the minimum version %s for Kubernetes %s", current, cv.Minimum, vc.KubernetesVersion)
+		return vc
+	}
+
+	recommended, err := parseVersion(cv.Recommended)
+	if err != nil {
+		vc.Reason = fmt.Sprintf("invalid recommended version %q in version matrix", cv.Recommended)
+		return vc
+	}
+	switch current.compare(recommended) {
+	case 0:
+		vc.Status = versionStatusRecommended
+	case -1:
+		vc.Status = versionStatusOutdated
+		vc.Reason = fmt.Sprintf("%s is older than the recommended version %s for Kubernetes %s", current, cv.Recommended, vc.KubernetesVersion)
+	default:
+		vc.Status = versionStatusNewer
+	}
+	return vc
+}
+
+//checkComponentVersions compares coredns, kube-proxy and VPC CNI images with the version matrix
+func checkComponentVersions(ns string, k8sGitVersion string) ([]VersionCheck, error) {
+	matrix, err := loadVersionMatrix()
+	if err != nil {
+		return nil, err
+	}
+
+	checks := make([]VersionCheck, 0, 3)
+
+	dep, err := Clientset.AppsV1().Deployments(ns).Get("coredns", metav1.GetOptions{})
+	if err != nil {
+		log.Warnf("Failed to get coredns deployment: %v", err)
+	} else if len(dep.Spec.Template.Spec.Containers) > 0 {
+		checks = append(checks, matrix.evaluate(componentCoredns, dep.Spec.Template.Spec.Containers[0].Image, k8sGitVersion))
+	}
+
+	daemonsets := []struct{ component, name string }{
+		{componentKubeProxy, "kube-proxy"},
+		{componentVpcCni, "aws-node"},
+	}
+	for _, d := range daemonsets {
+		ds, err := Clientset.AppsV1().DaemonSets(ns).Get(d.name, metav1.GetOptions{})
+		if err != nil {
+			log.Warnf("Failed to get %s daemonset: %v", d.name, err)
+			continue
+		}
+		for _, c := range ds.Spec.Template.Spec.Containers {
+			if c.Name == d.name {
+				checks = append(checks, matrix.evaluate(d.component, c.Image, k8sGitVersion))
+			}
+		}
+	}
+
+	log.Infof("Component versions: %+v", checks)
+	return checks, nil
+}
+
+//versionFindings reports add-ons which are not running a recommended version
+func versionFindings(checks []VersionCheck) []findings.Finding {
+	res := make([]findings.Finding, 0)
+	for _, vc := range checks {
+		evidence := fmt.Sprintf("image=%s kubernetes=%s recommended=%s minimum=%s", vc.Image, vc.KubernetesVersion, vc.Recommended, vc.Minimum)
+		switch vc.Status {
+		case versionStatusKnownBad:
+			res = append(res, findings.New("VERSION-KNOWN-BAD", findings.SeverityCritical, "componentVersions",
+				fmt.Sprintf("%s %s has a known problem: %s", vc.Component, vc.CurrentVersion, vc.Reason),
+				fmt.Sprintf("Upgrade %s to %s.", vc.Component, vc.Recommended), evidence))
+		case versionStatusBelowMinimum:
+			res = append(res, findings.New("VERSION-BELOW-MINIMUM", findings.SeverityCritical, "componentVersions",
+				fmt.Sprintf("%s: %s", vc.Component, vc.Reason),
+				fmt.Sprintf("Upgrade %s to %s.", vc.Component, vc.Recommended), evidence))
+		case versionStatusOutdated:
+			res = append(res, findings.New("VERSION-OUTDATED", findings.SeverityWarning, "componentVersions",
+				fmt.Sprintf("%s: %s", vc.Component, vc.Reason),
+				fmt.Sprintf("Upgrade %s to %s.", vc.Component, vc.Recommended), evidence))
+		}
+	}
+	return res
+}
@@ -0,0 +1,46 @@
+package main
+
+import "testing"
+
+func TestVersionMatrixEvaluate(t *testing.T) {
+	const registry = "602401143452.dkr.ecr.us-west-2.amazonaws.com/eks/"
+	matrix := VersionMatrix{"1.28": {
+		componentCoredns: {Recommended: "v1.10.1", Minimum: "v1.9.3", KnownBad: map[string]string{"v1.9.4": "broken"}},
+	}}
+	tests := []struct {
+		name, image, k8s, want string
+	}{
+		{"recommended", registry + "coredns:v1.10.1-eksbuild.4", "v1.28.3-eks-4f4795d", versionStatusRecommended},
+		{"outdated", registry + "coredns:v1.9.3-eksbuild.1", "v1.28.3-eks-4f4795d", versionStatusOutdated},
+		{"below minimum", registry + "coredns:v1.8.7-eksbuild.3", "v1.28.3-eks-4f4795d", versionStatusBelowMinimum},
+		{"known bad", registry + "coredns:v1.9.4", "v1.28.3-eks-4f4795d", versionStatusKnownBad},
+		{"newer", registry + "coredns:v1.11.1-eksbuild.4", "v1.28.3-eks-4f4795d", versionStatusNewer},
+		{"digest only", registry + "coredns@sha256:abc", "v1.28.3-eks-4f4795d", versionStatusUnknown},
+		{"kubernetes version without entry", registry + "coredns:v1.10.1", "v1.99.0", versionStatusUnknown},
+	}
+	for _, tt := range tests {
+		if got := matrix.evaluate(componentCoredns, tt.image, tt.k8s); got.Status != tt.want {
+			t.Errorf("%s: status = %q (%s), want %q", tt.name, got.Status, got.Reason, tt.want)
+		}
+	}
+}
+
+func TestDefaultVersionMatrixIsValid(t *testing.T) {
+	for k8s, components := range defaultVersionMatrix {
+		for name, cv := range components {
+			recommended, err := parseVersion(cv.Recommended)
+			if err != nil {
+				t.Errorf("%s %s: invalid recommended version: %v", k8s, name, err)
+				continue
+			}
+			minimum, err := parseVersion(cv.Minimum)
+			if err != nil {
+				t.Errorf("%s %s: invalid minimum version: %v", k8s, name, err)
+				continue
+			}
+			if recommended.compare(minimum) < 0 {
+				t.Errorf("%s %s: recommended %s is older than minimum %s", k8s, name, cv.Recommended, cv.Minimum)
+			}
+		}
+	}
+}
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/joshisumit/eks-dns-troubleshooter/pkg/aws"
+	"github.com/joshisumit/eks-dns-troubleshooter/pkg/findings"
+)
+
+//failedExternalDomains returns the names outside the cluster domain which failed to resolve
+func failedExternalDomains(results []DnsTestResultForDomain, clusterDomain string) []string {
+	failed := make(map[string]bool)
+	for _, r := range results {
+		if r.Result != "success" && !isClusterName(r.DomainName, clusterDomain) {
+			failed[r.DomainName] = true
+		}
+	}
+	return sortedKeys(failed)
+}
+
+//vpcDNSFindings reports VPC DNS attributes and DHCP options which break forwarding of external names by coredns,
+//escalated when external names failed in the DNS tests
+func vpcDNSFindings(check *aws.VPCDNSCheck, cd *Coredns) []findings.Finding {
+	res := make([]findings.Finding, 0)
+	if check == nil {
+		return res
+	}
+	const source = "vpcDns"
+
+	failed := failedExternalDomains(cd.Dnstest.DnsTestResultForDomains, cd.ResolvConf.clusterDomain())
+	severity, impact := findings.SeverityWarning, "external names may fail to resolve"
+	if len(failed) > 0 {
+		severity, impact = findings.SeverityCritical, fmt.Sprintf("this explains the failed resolution of external names %v", failed)
+	}
+	evidence := []string{
+		fmt.Sprintf("VPC %s: enableDnsSupport=%v enableDnsHostnames=%v", check.VpcID, check.EnableDNSSupport, check.EnableDNSHostnames),
+		fmt.Sprintf("DHCP options set %s: domain-name-servers=%v domain-name=%v", check.DhcpOptionsID, check.DomainNameServers, check.DomainName),
+	}
+
+	if !check.EnableDNSSupport {
+		res = append(res, findings.New("VPC-DNS-SUPPORT-DISABLED", findings.SeverityCritical, source,
+			fmt.Sprintf("enableDnsSupport is disabled on VPC %s, the Amazon provided DNS server (%s, %s) does not answer and coredns cannot forward external names via /etc/resolv.conf; %s", check.VpcID, check.AmazonDNSIP, aws.AmazonDNSLinkLocalIP, impact),
+			fmt.Sprintf("Enable it: aws ec2 modify-vpc-attribute --vpc-id %s --enable-dns-support \"{\\\"Value\\\":true}\"", check.VpcID),
+			evidence...))
+	}
+	if !check.EnableDNSHostnames {
+		res = append(res, findings.New("VPC-DNS-HOSTNAMES-DISABLED", findings.SeverityWarning, source,
+			fmt.Sprintf("enableDnsHostnames is disabled on VPC %s, private hosted zones, interface VPC endpoint private DNS and instance hostnames do not resolve", check.VpcID),
+			fmt.Sprintf("Enable it: aws ec2 modify-vpc-attribute --vpc-id %s --enable-dns-hostnames \"{\\\"Value\\\":true}\"", check.VpcID),
+			evidence...))
+	}
+	if !check.UsesAmazonProvidedDNS {
+		res = append(res, findings.New("VPC-DHCP-CUSTOM-DNS", severity, source,
+			fmt.Sprintf("The DHCP options set %s of VPC %s points domain-name-servers to %v instead of AmazonProvidedDNS, nodes (and coredns forwarding to /etc/resolv.conf) use these servers; %s", check.DhcpOptionsID, check.VpcID, check.DomainNameServers, impact),
+			"Make sure the custom DNS servers are reachable from the nodes and resolve both external and VPC private names (or forward to the Route 53 Resolver), or use a DHCP options set with AmazonProvidedDNS.",
+			evidence...))
+	}
+	return res
+}
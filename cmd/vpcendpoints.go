@@ -0,0 +1,132 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/joshisumit/eks-dns-troubleshooter/pkg/aws"
+	"github.com/joshisumit/eks-dns-troubleshooter/pkg/findings"
+)
+
+const (
+	endpointAnswerFailed = "failed"
+	vpcEndpointAvailable = "available"
+	vpcEndpointInterface = "Interface"
+)
+
+//EndpointResolution stores the resolution of a VPC interface endpoint hostname through coredns
+type EndpointResolution struct {
+	EndpointID        string   `json:"endpointId"`
+	ServiceName       string   `json:"serviceName"`
+	Hostname          string   `json:"hostname"`
+	PrivateDNSEnabled bool     `json:"privateDnsEnabled"`
+	Answers           []string `json:"answers,omitempty"`
+	ENIIPs            []string `json:"eniIps,omitempty"`
+	Verdict           string   `json:"verdict"`
+	Error             string   `json:"error,omitempty"`
+}
+
+//resolveVPCEndpoints resolves the hostname of every interface endpoint through the coredns ClusterIP
+//and compares the answers with the endpoint ENI IPs
+func resolveVPCEndpoints(clusterIP string, check *aws.VPCEndpointsCheck) []EndpointResolution {
+	if check == nil || clusterIP == "" {
+		return nil
+	}
+	res := make([]EndpointResolution, 0)
+	for i := range check.Endpoints {
+		ep := &check.Endpoints[i]
+		if ep.Hostname == "" {
+			continue
+		}
+		r := EndpointResolution{
+			EndpointID:        ep.ID,
+			ServiceName:       ep.ServiceName,
+			Hostname:          ep.Hostname,
+			PrivateDNSEnabled: ep.PrivateDNSEnabled,
+			ENIIPs:            ep.ENIIPs,
+		}
+		result := lookupIP(ep.Hostname, clusterIP, transportUDP)
+		r.Answers = result.Answer
+		if result.Result != "success" || len(result.Answer) == 0 {
+			r.Verdict, r.Error = endpointAnswerFailed, result.Error
+		} else {
+			r.Verdict = ep.Classify(result.Answer)
+		}
+		res = append(res, r)
+	}
+	return res
+}
+
+//overridingHostedZones returns the private hosted zones associated with the VPC which contain the hostname
+func overridingHostedZones(r53 *aws.Route53Check, hostname string) []string {
+	zones := make([]string, 0)
+	if r53 == nil {
+		return zones
+	}
+	for _, z := range r53.HostedZones {
+		if z.AssociatedWithVpc && zoneMatches(hostname, z.Name) {
+			zones = append(zones, fmt.Sprintf("%s (%s)", z.Name, z.ID))
+		}
+	}
+	return zones
+}
+
+//vpcEndpointFindings reports interface endpoints which pods do not reach through private DNS
+func vpcEndpointFindings(check *aws.VPCEndpointsCheck, resolutions []EndpointResolution, r53 *aws.Route53Check) []findings.Finding {
+	res := make([]findings.Finding, 0)
+	if check == nil {
+		return res
+	}
+	const source = "vpcEndpoints"
+
+	for _, ep := range check.Endpoints {
+		if ep.Type == vpcEndpointInterface && ep.State != vpcEndpointAvailable {
+			res = append(res, findings.New("VPCE-NOT-AVAILABLE", findings.SeverityWarning, source,
+				fmt.Sprintf("VPC endpoint %s for %s is in state %s", ep.ID, ep.ServiceName, ep.State),
+				"Only available endpoints answer requests. Check the endpoint in the VPC console (pending acceptance, failed or deleted).",
+				"subnets: "+strings.Join(ep.SubnetIDs, ", ")))
+		}
+	}
+
+	for _, r := range resolutions {
+		evidence := []string{
+			fmt.Sprintf("endpoint: %s (%s), private DNS enabled: %t", r.EndpointID, r.ServiceName, r.PrivateDNSEnabled),
+			fmt.Sprintf("answers through coredns: %v", r.Answers),
+			fmt.Sprintf("endpoint ENI IPs: %v", r.ENIIPs),
+		}
+		zones := overridingHostedZones(r53, r.Hostname)
+		if len(zones) > 0 {
+			evidence = append(evidence, "private hosted zones associated with the VPC: "+strings.Join(zones, ", "))
+		}
+
+		switch {
+		case r.Verdict == endpointAnswerFailed:
+			res = append(res, findings.New("VPCE-DNS-RESOLUTION-FAILED", findings.SeverityCritical, source,
+				fmt.Sprintf("%s (VPC endpoint %s) does not resolve through coredns: %s", r.Hostname, r.EndpointID, r.Error),
+				"Pods cannot reach the AWS service. Check the upstream resolution of coredns and any private hosted zone with the same name.",
+				evidence...))
+		case r.Verdict == aws.EndpointAnswerPublic && r.PrivateDNSEnabled:
+			res = append(res, findings.New("VPCE-PRIVATE-DNS-NOT-EFFECTIVE", findings.SeverityCritical, source,
+				fmt.Sprintf("%s resolves to public IPs through coredns although private DNS is enabled on VPC endpoint %s", r.Hostname, r.EndpointID),
+				"Private DNS of the endpoint is only answered by the VPC resolver. Make sure coredns forwards amazonaws.com to the VPC resolver (not a custom or on-prem resolver) and enableDnsSupport and enableDnsHostnames are enabled on the VPC.",
+				evidence...))
+		case r.Verdict == aws.EndpointAnswerPublic:
+			res = append(res, findings.New("VPCE-PRIVATE-DNS-DISABLED", findings.SeverityWarning, source,
+				fmt.Sprintf("%s resolves to public IPs because private DNS is disabled on VPC endpoint %s", r.Hostname, r.EndpointID),
+				"Pods bypass the endpoint and need a NAT gateway to reach the service. Enable private DNS on the endpoint (aws ec2 modify-vpc-endpoint --private-dns-enabled) or use the endpoint specific DNS names.",
+				evidence...))
+		case r.Verdict == aws.EndpointAnswerOtherPrivate:
+			res = append(res, findings.New("VPCE-DNS-OVERRIDDEN", findings.SeverityWarning, source,
+				fmt.Sprintf("%s resolves to private IPs which do not belong to VPC endpoint %s", r.Hostname, r.EndpointID),
+				"A private hosted zone, a Resolver rule or a coredns rewrite/hosts entry overrides the endpoint private DNS. Remove the override or point it to the endpoint.",
+				evidence...))
+		}
+	}
+	if len(check.Errors) > 0 {
+		res = append(res, findings.New("VPCE-EVALUATION-INCOMPLETE", findings.SeverityInfo, source,
+			"Some VPC endpoint details could not be read, the VPC endpoint results may be incomplete",
+			"Grant ec2:DescribeVpcEndpoints, ec2:DescribeVpcEndpointServices and ec2:DescribeNetworkInterfaces to the troubleshooter.",
+			check.Errors...))
+	}
+	return res
+}
@@ -0,0 +1,119 @@
+package aws
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/eks"
+	"github.com/aws/aws-sdk-go/service/eks/eksiface"
+	log "github.com/sirupsen/logrus"
+)
+
+//dnsAddons are the EKS add-ons which take part in DNS resolution
+var dnsAddons = []string{"coredns", "kube-proxy", "vpc-cni"}
+
+type eksClient struct {
+	eksServiceClient eksiface.EKSAPI
+}
+
+func newEKSClient(region string) *eksClient {
+	eksSession := session.Must(session.NewSession())
+	eksCl := eks.New(eksSession, aws.NewConfig().WithMaxRetries(maxRetries).WithRegion(region))
+
+	return &eksClient{
+		eksServiceClient: eksCl,
+	}
+}
+
+//AddonInfo stores whether an add-on is an EKS managed add-on along with its status
+type AddonInfo struct {
+	Name                string   `json:"name"`
+	IsManaged           bool     `json:"isManaged"`
+	Version             string   `json:"version,omitempty"`
+	Status              string   `json:"status,omitempty"`
+	HealthIssues        []string `json:"healthIssues,omitempty"`
+	ConfigurationValues string   `json:"configurationValues,omitempty"`
+	Error               string   `json:"error,omitempty"`
+}
+
+//logAWSError prints the error code and message of an AWS API error
+func logAWSError(err error) {
+	if aerr, ok := err.(awserr.Error); ok {
+		switch aerr.Code() {
+		default:
+			log.Println(aerr.Error())
+		}
+	} else {
+		// Print the error, cast err to awserr.Error to get the Code and
+		// Message from an error.
+		log.Println(err.Error())
+	}
+}
+
+//listAddons returns names of all the managed add-ons installed in the cluster
+func (e *eksClient) listAddons(clusterName string) (map[string]bool, error) {
+	installed := make(map[string]bool)
+
+	input := &eks.ListAddonsInput{
+		ClusterName: aws.String(clusterName),
+	}
+	err := e.eksServiceClient.ListAddonsPages(input, func(page *eks.ListAddonsOutput, lastPage bool) bool {
+		for _, name := range page.Addons {
+			installed[aws.StringValue(name)] = true
+		}
+		return !lastPage
+	})
+	if err != nil {
+		logAWSError(err)
+		return nil, err
+	}
+	return installed, nil
+}
+
+//describeAddons returns managed add-on details of coredns, kube-proxy and vpc-cni
+func (e *eksClient) describeAddons(clusterName string) ([]AddonInfo, error) {
+	installed, err := e.listAddons(clusterName)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to list add-ons of cluster %s: %v", clusterName, err)
+	}
+
+	addons := make([]AddonInfo, 0, len(dnsAddons))
+	for _, name := range dnsAddons {
+		info := AddonInfo{Name: name}
+		if !installed[name] {
+			log.Infof("%s is not installed as an EKS managed add-on (self-managed)", name)
+			addons = append(addons, info)
+			continue
+		}
+
+		result, err := e.eksServiceClient.DescribeAddon(&eks.DescribeAddonInput{
+			AddonName:   aws.String(name),
+			ClusterName: aws.String(clusterName),
+		})
+		info.IsManaged = true
+		if err != nil {
+			//keep the other add-ons, the failed one is reported with its error
+			logAWSError(err)
+			info.Error = fmt.Sprintf("Failed to describe add-on %s: %v", name, err)
+			addons = append(addons, info)
+			continue
+		}
+
+		addon := result.Addon
+		info.Version = aws.StringValue(addon.AddonVersion)
+		info.Status = aws.StringValue(addon.Status)
+		info.ConfigurationValues = aws.StringValue(addon.ConfigurationValues)
+		if addon.Health != nil {
+			for _, issue := range addon.Health.Issues {
+				info.HealthIssues = append(info.HealthIssues, fmt.Sprintf("%s: %s (%s)",
+					aws.StringValue(issue.Code), aws.StringValue(issue.Message), strings.Join(aws.StringValueSlice(issue.ResourceIds), ",")))
+			}
+		}
+		log.Infof("Managed add-on %s: %+v", name, info)
+		addons = append(addons, info)
+	}
+	return addons, nil
+}
@@ -0,0 +1,91 @@
+package aws
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/eks"
+	"github.com/aws/aws-sdk-go/service/eks/eksiface"
+)
+
+//fakeEKS is a stand-in for the EKS API serving ListAddons and DescribeAddon from memory
+type fakeEKS struct {
+	eksiface.EKSAPI
+	addons      map[string]*eks.Addon
+	listErr     error
+	describeErr map[string]error
+}
+
+func (f *fakeEKS) ListAddonsPages(input *eks.ListAddonsInput, fn func(*eks.ListAddonsOutput, bool) bool) error {
+	if f.listErr != nil {
+		return f.listErr
+	}
+	out := &eks.ListAddonsOutput{}
+	for name := range f.addons {
+		out.Addons = append(out.Addons, aws.String(name))
+	}
+	fn(out, true)
+	return nil
+}
+
+func (f *fakeEKS) DescribeAddon(input *eks.DescribeAddonInput) (*eks.DescribeAddonOutput, error) {
+	name := aws.StringValue(input.AddonName)
+	if err := f.describeErr[name]; err != nil {
+		return nil, err
+	}
+	return &eks.DescribeAddonOutput{Addon: f.addons[name]}, nil
+}
+
+func TestDescribeAddons(t *testing.T) {
+	fake := &fakeEKS{
+		addons: map[string]*eks.Addon{
+			"coredns": {
+				AddonVersion: aws.String("v1.11.1-eksbuild.4"),
+				Status:       aws.String(eks.AddonStatusDegraded),
+				Health: &eks.AddonHealth{Issues: []*eks.AddonIssue{{
+					Code:        aws.String("InsufficientNumberOfReplicas"),
+					Message:     aws.String("not enough replicas"),
+					ResourceIds: []*string{aws.String("coredns")},
+				}}},
+			},
+			"kube-proxy": {AddonVersion: aws.String("v1.29.0-eksbuild.1"), Status: aws.String(eks.AddonStatusActive)},
+		},
+		describeErr: map[string]error{"kube-proxy": errors.New("AccessDenied")},
+	}
+	e := &eksClient{eksServiceClient: fake}
+
+	addons, err := e.describeAddons("test")
+	if err != nil {
+		t.Fatalf("describeAddons returned error: %v", err)
+	}
+	if len(addons) != len(dnsAddons) {
+		t.Fatalf("got %d add-ons, want %d", len(addons), len(dnsAddons))
+	}
+	byName := make(map[string]AddonInfo)
+	for _, a := range addons {
+		byName[a.Name] = a
+	}
+
+	coredns := byName["coredns"]
+	if !coredns.IsManaged || coredns.Version != "v1.11.1-eksbuild.4" || coredns.Status != eks.AddonStatusDegraded {
+		t.Errorf("unexpected coredns add-on: %+v", coredns)
+	}
+	if len(coredns.HealthIssues) != 1 || coredns.HealthIssues[0] != "InsufficientNumberOfReplicas: not enough replicas (coredns)" {
+		t.Errorf("unexpected coredns health issues: %v", coredns.HealthIssues)
+	}
+	//a failing DescribeAddon is recorded on the add-on without aborting the others
+	if kp := byName["kube-proxy"]; !kp.IsManaged || kp.Error == "" || kp.Version != "" {
+		t.Errorf("expected kube-proxy to carry the describe error: %+v", kp)
+	}
+	if cni := byName["vpc-cni"]; cni.IsManaged || cni.Error != "" {
+		t.Errorf("expected self-managed vpc-cni: %+v", cni)
+	}
+}
+
+func TestDescribeAddonsListError(t *testing.T) {
+	e := &eksClient{eksServiceClient: &fakeEKS{listErr: errors.New("AccessDenied")}}
+	if _, err := e.describeAddons("test"); err == nil {
+		t.Fatal("expected an error when ListAddons fails")
+	}
+}
@@ -45,37 +45,24 @@ type ClusterInfo struct {
 	TagList                  []map[string]string                     `json:"tagList,omitempty"`
 	InstanceIdentityDocument ec2metadata.EC2InstanceIdentityDocument `json:"-"`
 	ClusterDetails           *eks.Cluster                            `json:"-"`
+	Identity                 ClusterIdentity                         `json:"clusterIdentity"`
+	Addons                   []AddonInfo                             `json:"addons,omitempty"`
+	AddonsError              string                                  `json:"addonsError,omitempty"`
+	FargateProfiles          []FargateProfileInfo                    `json:"fargateProfiles,omitempty"`
+	DNSSecurityGroups        *SGEvaluation                           `json:"dnsSecurityGroupChecks,omitempty"`
+	DNSNetworkACLs           *NACLEvaluation                         `json:"dnsNetworkAclChecks,omitempty"`
+	VPCDNS                   *VPCDNSCheck                            `json:"vpcDnsChecks,omitempty"`
+	Route53                  *Route53Check                           `json:"route53Checks,omitempty"`
+	RouteTables              *RouteTableCheck                        `json:"routeTableChecks,omitempty"`
+	VPCEndpoints             *VPCEndpointsCheck                      `json:"vpcEndpointChecks,omitempty"`
 }
 
-func getInstanceIdentityDocument() (*ClusterInfo, error) {
-	//create session
-	sess := session.Must(session.NewSession())
-	log.Printf("Session: %v\n", *sess)
-
-	//create  metadata client
-	metadataClient := ec2metadata.New(sess)
-
-	doc, err := metadataClient.GetInstanceIdentityDocument()
-	if err != nil {
-		if aerr, ok := err.(awserr.Error); ok {
-			switch aerr.Code() {
-			default:
-				log.Println(aerr.Error())
-			}
-		} else {
-			// Print the error, cast err to awserr.Error to get the Code and
-			// Message from an error.
-			log.Println(err.Error())
-		}
-		return &ClusterInfo{}, err
+//VpcID returns the VPC ID of the cluster
+func (w *ClusterInfo) VpcID() string {
+	if w.ClusterDetails == nil || w.ClusterDetails.ResourcesVpcConfig == nil {
+		return ""
 	}
-	log.Printf("EC2 Instacne ID doc: %+v\n\n", doc)
-	log.Printf("Region from EC2 Instacne ID doc: %+v\n", doc.Region)
-
-	return &ClusterInfo{
-		InstanceIdentityDocument: doc,
-	}, nil
-
+	return aws.StringValue(w.ClusterDetails.ResourcesVpcConfig.VpcId)
 }
 
 func newEC2Client(region string) (*ec2Client, error) {
@@ -87,51 +74,32 @@ func newEC2Client(region string) (*ec2Client, error) {
 	}, nil
 }
 
-//getClusterName fetches EKS cluster name from the EC2 worker node tags
-func (e *ec2Client) getClusterName(resourceId string) (string, error) {
-	var clusterName string
-
-	input := &ec2.DescribeTagsInput{
-		Filters: []*ec2.Filter{
-			{
-				Name: aws.String(resourceID),
-				Values: []*string{
-					aws.String(resourceId),
-				},
-			},
-		},
+//getInstanceSGs returns the SGs attached to an EC2 instance using DescribeInstances
+func getInstanceSGs(instanceID string, region string) ([]string, error) {
+	if instanceID == "" {
+		return nil, errors.New("Instance ID of the node is unknown")
 	}
-
-	//Describe Tags of a EC2 worker node
-	tagsList, err := e.ec2ServiceClient.DescribeTags(input)
+	ec2Client, _ := newEC2Client(region)
+	result, err := ec2Client.ec2ServiceClient.DescribeInstances(&ec2.DescribeInstancesInput{
+		InstanceIds: []*string{aws.String(instanceID)},
+	})
 	if err != nil {
-		if aerr, ok := err.(awserr.Error); ok {
-			switch aerr.Code() {
-			default:
-				log.Println(aerr.Error())
-			}
-		} else {
-			// Print the error, cast err to awserr.Error to get the Code and
-			// Message from an error.
-			log.Println(err.Error())
-		}
-		return "", err
-	}
-	if len(tagsList.Tags) < 1 {
-		return "", fmt.Errorf("There are no tags attached to EC2 instance")
+		logAWSError(err)
+		return nil, err
 	}
 
-	//parse all the tags & fetch clusterName from the tag "kubernetes.io/cluster/"
-	for _, tagDetails := range tagsList.Tags {
-		if strings.Contains(aws.StringValue(tagDetails.Key), tagKeyMatchValue) {
-			clusterName = strings.Split(aws.StringValue(tagDetails.Key), "/")[2]
+	secGroupIds := make([]string, 0)
+	for _, reservation := range result.Reservations {
+		for _, instance := range reservation.Instances {
+			for _, sg := range instance.SecurityGroups {
+				secGroupIds = append(secGroupIds, aws.StringValue(sg.GroupId))
+			}
 		}
 	}
-	if clusterName == "" {
-		return "", fmt.Errorf("Error finding clustername...Reuired tag not found")
+	if len(secGroupIds) == 0 {
+		return nil, fmt.Errorf("No SGs found for instance %s", instanceID)
 	}
-
-	return clusterName, nil
+	return secGroupIds, nil
 }
 
 func (w *ClusterInfo) getAttachedSG() ([]string, error) {
@@ -381,43 +349,32 @@ func makeRange(min, max int64) []int64 {
 
 //DiscoverClusterInfo checks EKS cluster resources
 //like ClusterSecurityGroup, NACL
-func DiscoverClusterInfo() (*ClusterInfo, error) {
-
-	wkr1, err := getInstanceIdentityDocument()
-	if err != nil {
-		log.Errorf("Failed to fetch instanceID document")
-		return nil, err
+//Cluster name and region come from the discovery chain in id
+func DiscoverClusterInfo(id *ClusterIdentity) (*ClusterInfo, error) {
+	if !id.Complete() {
+		return nil, fmt.Errorf("Unable to discover cluster name and region (cluster name: %q, region: %q), attempts: %v", id.ClusterName, id.Region, id.Attempts)
 	}
-	log.Infof("Worker node Info: %v", wkr1)
-	log.Infof("Worker node Info: %+v", wkr1.InstanceIdentityDocument)
-
-	region := wkr1.InstanceIdentityDocument.Region
-	log.Infof("Region is: %v", region)
-
-	// err = getTags(region, wkr.instanceIdentityDocument.InstanceID)
-	// if err != nil {
-	// 	log.Errorf("Failed to fetch tags of an instance : ", err)
-	// }
-
-	ec2Client, _ := newEC2Client(region)
-	fmt.Printf("EC2 Client: %v %T\n\n", ec2Client, ec2Client)
+	clusterName, region := id.ClusterName, id.Region
+	log.Infof("Clustername is: %v (%s), Region is: %v (%s)", clusterName, id.ClusterNameSource, region, id.RegionSource)
 
-	wkr := ClusterInfo{}
-	log.Infof("Fetching Clustername...")
-	clusterName, err := ec2Client.getClusterName(wkr1.InstanceIdentityDocument.InstanceID)
-	if err != nil {
-		log.Errorf("Error finding required tag %q on EC2 instance: %v", "kubernetes.io/cluster/clusterName", err)
-		return nil, err
+	wkr := ClusterInfo{
+		ClusterName: clusterName,
+		Region:      region,
+		Identity:    *id,
+	}
+	if id.identityDocument != nil {
+		wkr.InstanceIdentityDocument = *id.identityDocument
 	}
-	wkr.ClusterName = clusterName
-	wkr.Region = region
-	log.Infof("Clustername is: %v", clusterName)
 
 	log.Infof("Fetching SGs attached to an EC2 instance")
 	sgID, err := wkr.getAttachedSG()
 	if err != nil {
-		log.Printf("Unable to retrieve the SGs attached to the EC2 instance %v\n", err)
-		return nil, err
+		log.Warnf("Unable to retrieve the SGs attached to the EC2 instance from metadata %v, falling back to DescribeInstances", err)
+		sgID, err = getInstanceSGs(id.InstanceID, region)
+		if err != nil {
+			log.Printf("Unable to retrieve the SGs attached to the EC2 instance %v\n", err)
+			return nil, err
+		}
 	}
 	log.Infof("SGs attached to instance: %v", sgID)
 	wkr.SecurityGroupIds = sgID
@@ -431,17 +388,38 @@ func DiscoverClusterInfo() (*ClusterInfo, error) {
 	}
 	log.Infof("details: %v %T", *wkr.ClusterDetails, wkr.ClusterDetails)
 
+	//Check whether coredns, kube-proxy and vpc-cni are EKS managed add-ons
+	log.Infof("Fetching EKS managed add-ons of cluster %q", clusterName)
+	eksCl := newEKSClient(region)
+	wkr.Addons, err = eksCl.describeAddons(clusterName)
+	if err != nil {
+		log.Errorf("Unable to retrieve EKS add-ons %v", err)
+		wkr.AddonsError = err.Error()
+	}
+
+	//Fargate profiles decide where coredns can be scheduled even when the tool runs on EC2
+	log.Infof("Fetching Fargate profiles of cluster %q", clusterName)
+	wkr.FargateProfiles, err = eksCl.describeFargateProfiles(clusterName)
+	if err != nil {
+		log.Errorf("Unable to retrieve Fargate profiles %v", err)
+	} else if err := describeFargateSubnets(wkr.FargateProfiles, aws.StringValue(wkr.ClusterDetails.ResourcesVpcConfig.VpcId), region); err != nil {
+		log.Errorf("Unable to retrieve subnets of Fargate profiles %v", err)
+	}
+
 	log.Infof("Evaluating Cluster Security-Group ID")
 	inbound, outbound, err := verifyClusterSGRules(wkr.ClusterSGID, region)
 	if err != nil {
+		//the DNS security group evaluation reports security group problems, this check alone must not abort the discovery
 		log.Printf("Unable to evaluate the rules of Cluster SG %v\n", err)
-		return nil, err
 	}
 
 	//wkr.isClusterSGRulesCorrect = make(map[bool]string)
 	wkr.SgRulesCheck.InboundRule = make(map[string]string)
 	wkr.SgRulesCheck.OutboundRule = make(map[string]string)
-	if !inbound {
+	if err != nil {
+		wkr.SgRulesCheck.InboundRule["isValid"] = "unknown"
+		wkr.SgRulesCheck.OutboundRule["isValid"] = "unknown"
+	} else if !inbound {
 		wkr.SgRulesCheck.IsClusterSGRuleCorrect = false
 		wkr.SgRulesCheck.InboundRule["isValid"] = "false"
 		wkr.SgRulesCheck.InboundRule["details"] = fmt.Sprintf(`cluster Security Group %q is not configured correctly, 
@@ -464,11 +442,12 @@ func DiscoverClusterInfo() (*ClusterInfo, error) {
 
 	isNaclOk, err := verifyNaclRules(region, *wkr.ClusterDetails.ResourcesVpcConfig.VpcId)
 	if err != nil {
+		//the DNS NACL evaluation reports NACL problems, this check alone must not abort the discovery
 		log.Errorf("Unable to retrieve NACL rules %v\n", err)
-		return nil, err
+	} else {
+		wkr.NaclRulesCheck = isNaclOk
+		log.Infof("NACL rules are: %v", isNaclOk)
 	}
-	wkr.NaclRulesCheck = isNaclOk
-	log.Infof("NACL rules are: %v", isNaclOk)
 
 	log.Infof("worker node struct: %+v", wkr)
 
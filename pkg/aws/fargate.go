@@ -0,0 +1,191 @@
+package aws
+
+import (
+	"fmt"
+	"path"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ec2"
+	"github.com/aws/aws-sdk-go/service/eks"
+	log "github.com/sirupsen/logrus"
+)
+
+//FargateSelector is a namespace/labels selector of a Fargate profile
+type FargateSelector struct {
+	Namespace string            `json:"namespace"`
+	Labels    map[string]string `json:"labels,omitempty"`
+}
+
+//FargateSubnet stores details of a subnet of a Fargate profile
+type FargateSubnet struct {
+	SubnetID            string `json:"subnetId"`
+	VpcID               string `json:"vpcId,omitempty"`
+	AvailabilityZone    string `json:"availabilityZone,omitempty"`
+	AvailableIPs        int64  `json:"availableIpAddressCount"`
+	MapPublicIPOnLaunch bool   `json:"mapPublicIpOnLaunch"`
+	InClusterVpc        bool   `json:"inClusterVpc"`
+}
+
+//FargateProfileInfo stores details of a Fargate profile of the cluster
+type FargateProfileInfo struct {
+	Name                string            `json:"name"`
+	Status              string            `json:"status"`
+	PodExecutionRoleArn string            `json:"podExecutionRoleArn"`
+	Selectors           []FargateSelector `json:"selectors"`
+	Subnets             []FargateSubnet   `json:"subnets,omitempty"`
+}
+
+//Selects returns true if one of the selectors of the profile matches a pod with the given namespace and labels.
+//Namespaces of selectors may contain the wildcards * and ?
+func (p *FargateProfileInfo) Selects(namespace string, labels map[string]string) bool {
+	for _, sel := range p.Selectors {
+		if ok, err := path.Match(sel.Namespace, namespace); err != nil || !ok {
+			continue
+		}
+		matches := true
+		for k, v := range sel.Labels {
+			if ok, err := path.Match(v, labels[k]); err != nil || !ok {
+				matches = false
+				break
+			}
+		}
+		if matches {
+			return true
+		}
+	}
+	return false
+}
+
+//describeFargateProfiles returns all Fargate profiles of the cluster
+func (e *eksClient) describeFargateProfiles(clusterName string) ([]FargateProfileInfo, error) {
+	names := make([]string, 0)
+	input := &eks.ListFargateProfilesInput{
+		ClusterName: aws.String(clusterName),
+	}
+	err := e.eksServiceClient.ListFargateProfilesPages(input, func(page *eks.ListFargateProfilesOutput, lastPage bool) bool {
+		names = append(names, aws.StringValueSlice(page.FargateProfileNames)...)
+		return !lastPage
+	})
+	if err != nil {
+		logAWSError(err)
+		return nil, fmt.Errorf("Failed to list Fargate profiles of cluster %s: %v", clusterName, err)
+	}
+
+	profiles := make([]FargateProfileInfo, 0, len(names))
+	for _, name := range names {
+		result, err := e.eksServiceClient.DescribeFargateProfile(&eks.DescribeFargateProfileInput{
+			ClusterName:        aws.String(clusterName),
+			FargateProfileName: aws.String(name),
+		})
+		if err != nil {
+			logAWSError(err)
+			return nil, fmt.Errorf("Failed to describe Fargate profile %s: %v", name, err)
+		}
+
+		fp := result.FargateProfile
+		info := FargateProfileInfo{
+			Name:                aws.StringValue(fp.FargateProfileName),
+			Status:              aws.StringValue(fp.Status),
+			PodExecutionRoleArn: aws.StringValue(fp.PodExecutionRoleArn),
+		}
+		for _, sel := range fp.Selectors {
+			info.Selectors = append(info.Selectors, FargateSelector{
+				Namespace: aws.StringValue(sel.Namespace),
+				Labels:    aws.StringValueMap(sel.Labels),
+			})
+		}
+		for _, subnet := range fp.Subnets {
+			info.Subnets = append(info.Subnets, FargateSubnet{SubnetID: aws.StringValue(subnet)})
+		}
+		log.Infof("Fargate profile %s: %+v", name, info)
+		profiles = append(profiles, info)
+	}
+	return profiles, nil
+}
+
+//describeFargateSubnets fills VPC, AZ and free IP details of the subnets of the Fargate profiles
+func describeFargateSubnets(profiles []FargateProfileInfo, vpcID, region string) error {
+	ids := make([]*string, 0)
+	for _, p := range profiles {
+		for _, s := range p.Subnets {
+			ids = append(ids, aws.String(s.SubnetID))
+		}
+	}
+	if len(ids) == 0 {
+		return nil
+	}
+
+	ec2Client, _ := newEC2Client(region)
+	result, err := ec2Client.ec2ServiceClient.DescribeSubnets(&ec2.DescribeSubnetsInput{SubnetIds: ids})
+	if err != nil {
+		logAWSError(err)
+		return fmt.Errorf("Failed to describe subnets of Fargate profiles: %v", err)
+	}
+	subnets := make(map[string]*ec2.Subnet)
+	for _, s := range result.Subnets {
+		subnets[aws.StringValue(s.SubnetId)] = s
+	}
+
+	for i := range profiles {
+		for j := range profiles[i].Subnets {
+			fs := &profiles[i].Subnets[j]
+			s, ok := subnets[fs.SubnetID]
+			if !ok {
+				continue
+			}
+			fs.VpcID = aws.StringValue(s.VpcId)
+			fs.AvailabilityZone = aws.StringValue(s.AvailabilityZone)
+			fs.AvailableIPs = aws.Int64Value(s.AvailableIpAddressCount)
+			fs.MapPublicIPOnLaunch = aws.BoolValue(s.MapPublicIpOnLaunch)
+			fs.InClusterVpc = fs.VpcID == vpcID
+		}
+	}
+	return nil
+}
+
+//DiscoverFargateClusterInfo checks EKS cluster resources when the tool runs on Fargate.
+//Instance metadata is not available on Fargate, so cluster name and region must come from the other discovery sources.
+func DiscoverFargateClusterInfo(id *ClusterIdentity) (*ClusterInfo, error) {
+	if !id.Complete() {
+		return nil, fmt.Errorf("Cluster name and region are required on Fargate (cluster name: %q, region: %q), attempts: %v", id.ClusterName, id.Region, id.Attempts)
+	}
+	clusterName, region := id.ClusterName, id.Region
+
+	wkr := ClusterInfo{ClusterName: clusterName, Region: region, Identity: *id}
+	var err error
+
+	//Get EKS cluster details
+	log.Infof("Fetching details of EKS cluster %q using DescribeCluster API", clusterName)
+	wkr.ClusterDetails, wkr.ClusterSGID, err = wkr.getClusterDetails(clusterName, region)
+	if err != nil {
+		log.Printf("Unable to retrieve cluster Details %v\n", err)
+		return nil, err
+	}
+
+	eksCl := newEKSClient(region)
+	log.Infof("Fetching EKS managed add-ons of cluster %q", clusterName)
+	wkr.Addons, err = eksCl.describeAddons(clusterName)
+	if err != nil {
+		log.Errorf("Unable to retrieve EKS add-ons %v", err)
+		wkr.AddonsError = err.Error()
+	}
+
+	log.Infof("Fetching Fargate profiles of cluster %q", clusterName)
+	wkr.FargateProfiles, err = eksCl.describeFargateProfiles(clusterName)
+	if err != nil {
+		log.Errorf("Unable to retrieve Fargate profiles %v", err)
+	} else if err := describeFargateSubnets(wkr.FargateProfiles, aws.StringValue(wkr.ClusterDetails.ResourcesVpcConfig.VpcId), region); err != nil {
+		log.Errorf("Unable to retrieve subnets of Fargate profiles %v", err)
+	}
+
+	isNaclOk, err := verifyNaclRules(region, aws.StringValue(wkr.ClusterDetails.ResourcesVpcConfig.VpcId))
+	if err != nil {
+		//the DNS NACL evaluation reports NACL problems, this check alone must not abort the discovery
+		log.Errorf("Unable to retrieve NACL rules %v\n", err)
+	} else {
+		wkr.NaclRulesCheck = isNaclOk
+		log.Infof("NACL rules are: %v", isNaclOk)
+	}
+
+	return &wkr, nil
+}
@@ -0,0 +1,166 @@
+package aws
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/ec2metadata"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/ec2"
+	"github.com/aws/aws-sdk-go/service/eks"
+	log "github.com/sirupsen/logrus"
+)
+
+//tags which carry the EKS cluster name on instances of managed node groups, Karpenter and eksctl
+var clusterNameTagKeys = []string{"eks:cluster-name", "aws:eks:cluster-name"}
+
+//ClusterIdentity stores the cluster name, region and instance ID along with the source each one was discovered from
+type ClusterIdentity struct {
+	ClusterName       string   `json:"clusterName"`
+	ClusterNameSource string   `json:"clusterNameSource,omitempty"`
+	Region            string   `json:"region"`
+	RegionSource      string   `json:"regionSource,omitempty"`
+	InstanceID        string   `json:"instanceId,omitempty"`
+	InstanceIDSource  string   `json:"instanceIdSource,omitempty"`
+	APIServerEndpoint string   `json:"apiServerEndpoint,omitempty"`
+	Attempts          []string `json:"attempts,omitempty"`
+
+	identityDocument *ec2metadata.EC2InstanceIdentityDocument
+}
+
+//SetClusterName sets the cluster name if it is not discovered yet
+func (id *ClusterIdentity) SetClusterName(name, source string) {
+	if id.ClusterName != "" || name == "" {
+		return
+	}
+	id.ClusterName, id.ClusterNameSource = name, source
+	id.Attempt(source, fmt.Sprintf("cluster name %q", name))
+}
+
+//SetRegion sets the region if it is not discovered yet
+func (id *ClusterIdentity) SetRegion(region, source string) {
+	if id.Region != "" || region == "" {
+		return
+	}
+	id.Region, id.RegionSource = region, source
+	id.Attempt(source, fmt.Sprintf("region %q", region))
+}
+
+//SetInstanceID sets the EC2 instance ID of the node if it is not discovered yet
+func (id *ClusterIdentity) SetInstanceID(instanceID, source string) {
+	if id.InstanceID != "" || instanceID == "" {
+		return
+	}
+	id.InstanceID, id.InstanceIDSource = instanceID, source
+	id.Attempt(source, fmt.Sprintf("instance ID %q", instanceID))
+}
+
+//Attempt records the outcome of a discovery source
+func (id *ClusterIdentity) Attempt(source, result string) {
+	log.Infof("Cluster discovery via %s: %s", source, result)
+	id.Attempts = append(id.Attempts, fmt.Sprintf("%s: %s", source, result))
+}
+
+//Complete returns true when both cluster name and region are known
+func (id *ClusterIdentity) Complete() bool {
+	return id.ClusterName != "" && id.Region != ""
+}
+
+//ResolveFromTags looks up the cluster name in the tags of the node instance
+func (id *ClusterIdentity) ResolveFromTags() {
+	const source = "EC2 instance tags"
+	if id.ClusterName != "" {
+		return
+	}
+	if id.InstanceID == "" || id.Region == "" {
+		id.Attempt(source, "skipped, instance ID or region unknown")
+		return
+	}
+
+	ec2Client, _ := newEC2Client(id.Region)
+	result, err := ec2Client.ec2ServiceClient.DescribeTags(&ec2.DescribeTagsInput{
+		Filters: []*ec2.Filter{
+			{
+				Name:   aws.String(resourceID),
+				Values: []*string{aws.String(id.InstanceID)},
+			},
+		},
+	})
+	if err != nil {
+		logAWSError(err)
+		id.Attempt(source, fmt.Sprintf("failed: %v", err))
+		return
+	}
+
+	tags := make(map[string]string)
+	for _, tag := range result.Tags {
+		tags[aws.StringValue(tag.Key)] = aws.StringValue(tag.Value)
+	}
+	for _, key := range clusterNameTagKeys {
+		if name := tags[key]; name != "" {
+			id.SetClusterName(name, fmt.Sprintf("%s tag %q", source, key))
+			return
+		}
+	}
+	for key := range tags {
+		if strings.HasPrefix(key, tagKeyMatchValue) {
+			id.SetClusterName(strings.TrimPrefix(key, tagKeyMatchValue), fmt.Sprintf("%s tag %q", source, tagKeyMatchValue+"<name>"))
+			return
+		}
+	}
+	id.Attempt(source, "no cluster name tag found")
+}
+
+//ResolveFromEndpoint finds the cluster whose API server endpoint matches the endpoint found in the cluster
+func (id *ClusterIdentity) ResolveFromEndpoint() {
+	const source = "EKS ListClusters (API server endpoint match)"
+	if id.ClusterName != "" || id.APIServerEndpoint == "" || id.Region == "" {
+		return
+	}
+
+	eksCl := newEKSClient(id.Region)
+	names := make([]string, 0)
+	err := eksCl.eksServiceClient.ListClustersPages(&eks.ListClustersInput{}, func(page *eks.ListClustersOutput, lastPage bool) bool {
+		names = append(names, aws.StringValueSlice(page.Clusters)...)
+		return !lastPage
+	})
+	if err != nil {
+		logAWSError(err)
+		id.Attempt(source, fmt.Sprintf("failed: %v", err))
+		return
+	}
+	for _, name := range names {
+		result, err := eksCl.eksServiceClient.DescribeCluster(&eks.DescribeClusterInput{Name: aws.String(name)})
+		if err != nil {
+			logAWSError(err)
+			continue
+		}
+		if strings.EqualFold(aws.StringValue(result.Cluster.Endpoint), id.APIServerEndpoint) {
+			id.SetClusterName(name, source)
+			return
+		}
+	}
+	id.Attempt(source, fmt.Sprintf("no cluster with endpoint %s among %d clusters", id.APIServerEndpoint, len(names)))
+}
+
+//ResolveFromIMDS reads region and instance ID from the instance identity document.
+//The SDK fetches an IMDSv2 session token first, which fails when the hop limit is 1 and the pod is not using the host network.
+func (id *ClusterIdentity) ResolveFromIMDS() {
+	const source = "IMDSv2 instance identity document"
+	if id.Region != "" && id.InstanceID != "" {
+		return
+	}
+
+	sess := session.Must(session.NewSession())
+	metadataClient := ec2metadata.New(sess, aws.NewConfig().WithMaxRetries(2))
+	doc, err := metadataClient.GetInstanceIdentityDocument()
+	if err != nil {
+		logAWSError(err)
+		id.Attempt(source, fmt.Sprintf("failed: %v", err))
+		return
+	}
+	id.identityDocument = &doc
+	id.SetRegion(doc.Region, source)
+	id.SetInstanceID(doc.InstanceID, source)
+}
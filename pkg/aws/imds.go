@@ -0,0 +1,171 @@
+package aws
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ec2"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	imdsEndpoint       = "http://169.254.169.254"
+	imdsTokenPath      = "/latest/api/token"
+	imdsInstanceIDPath = "/latest/meta-data/instance-id"
+	imdsTokenTTLHeader = "X-aws-ec2-metadata-token-ttl-seconds"
+	imdsTokenHeader    = "X-aws-ec2-metadata-token"
+	imdsProbeTimeout   = 2 * time.Second
+	//imdsMaxTokenSize bounds the read of the session token, tokens are around 60 bytes
+	imdsMaxTokenSize = 4096
+
+	imdsOK           = "ok"
+	imdsTimeout      = "timeout"
+	imdsUnreachable  = "unreachable"
+	imdsUnauthorized = "unauthorized"
+	imdsForbidden    = "forbidden"
+	imdsNotProbed    = "not probed"
+)
+
+//IMDSCheck stores how instance metadata behaves from the troubleshooter pod and the MetadataOptions of the instance
+type IMDSCheck struct {
+	V1                      string   `json:"imdsV1"`
+	V2Token                 string   `json:"imdsV2Token"`
+	V2                      string   `json:"imdsV2"`
+	HostNetwork             bool     `json:"hostNetwork"`
+	HttpEndpoint            string   `json:"httpEndpoint,omitempty"`
+	HttpTokens              string   `json:"httpTokens,omitempty"`
+	HttpPutResponseHopLimit int64    `json:"httpPutResponseHopLimit,omitempty"`
+	MetadataOptionsError    string   `json:"metadataOptionsError,omitempty"`
+	Reason                  string   `json:"reason,omitempty"`
+	Impact                  []string `json:"impact,omitempty"`
+}
+
+//imdsStatus converts the outcome of a metadata request into a status
+func imdsStatus(resp *http.Response, err error) string {
+	if err != nil {
+		if nerr, ok := err.(net.Error); ok && nerr.Timeout() {
+			return imdsTimeout
+		}
+		return imdsUnreachable
+	}
+	defer resp.Body.Close()
+	switch resp.StatusCode {
+	case http.StatusOK:
+		return imdsOK
+	case http.StatusUnauthorized:
+		return imdsUnauthorized
+	case http.StatusForbidden:
+		return imdsForbidden
+	default:
+		return fmt.Sprintf("HTTP %d", resp.StatusCode)
+	}
+}
+
+//probeIMDS requests the instance ID without a token (IMDSv1) and with a session token (IMDSv2)
+func (c *IMDSCheck) probeIMDS() {
+	client := &http.Client{Timeout: imdsProbeTimeout}
+
+	//1. IMDSv1: plain GET, answered with 401 when HttpTokens is required
+	req, _ := http.NewRequest(http.MethodGet, imdsEndpoint+imdsInstanceIDPath, nil)
+	c.V1 = imdsStatus(client.Do(req))
+
+	//2. IMDSv2: PUT for a session token, the response is dropped when the hop limit is too low for the pod
+	req, _ = http.NewRequest(http.MethodPut, imdsEndpoint+imdsTokenPath, nil)
+	req.Header.Set(imdsTokenTTLHeader, "60")
+	resp, err := client.Do(req)
+	token := ""
+	var readErr error
+	if err == nil && resp.StatusCode == http.StatusOK {
+		var body []byte
+		body, readErr = ioutil.ReadAll(io.LimitReader(resp.Body, imdsMaxTokenSize))
+		token = string(body)
+	}
+	c.V2Token = imdsStatus(resp, err)
+	if readErr != nil {
+		log.Warnf("Failed to read the IMDSv2 session token: %v", readErr)
+		c.V2Token = imdsStatus(nil, readErr)
+		token = ""
+	}
+
+	c.V2 = imdsNotProbed
+	if token != "" {
+		req, _ = http.NewRequest(http.MethodGet, imdsEndpoint+imdsInstanceIDPath, nil)
+		req.Header.Set(imdsTokenHeader, token)
+		c.V2 = imdsStatus(client.Do(req))
+	}
+}
+
+//describeMetadataOptions reads the MetadataOptions of the instance
+func (c *IMDSCheck) describeMetadataOptions(instanceID, region string) {
+	if instanceID == "" || region == "" {
+		c.MetadataOptionsError = "instance ID or region unknown"
+		return
+	}
+	ec2Client, _ := newEC2Client(region)
+	result, err := ec2Client.ec2ServiceClient.DescribeInstances(&ec2.DescribeInstancesInput{
+		InstanceIds: []*string{aws.String(instanceID)},
+	})
+	if err != nil {
+		logAWSError(err)
+		c.MetadataOptionsError = err.Error()
+		return
+	}
+	for _, reservation := range result.Reservations {
+		for _, instance := range reservation.Instances {
+			if opts := instance.MetadataOptions; opts != nil {
+				c.HttpEndpoint = aws.StringValue(opts.HttpEndpoint)
+				c.HttpTokens = aws.StringValue(opts.HttpTokens)
+				c.HttpPutResponseHopLimit = aws.Int64Value(opts.HttpPutResponseHopLimit)
+			}
+		}
+	}
+}
+
+//explain sets the reason why metadata based discovery failed and its impact on the diagnosis
+func (c *IMDSCheck) explain(id *ClusterIdentity) {
+	v1ok, v2ok := c.V1 == imdsOK, c.V2 == imdsOK
+	switch {
+	case v1ok || v2ok:
+		c.Reason = ""
+	case c.HttpEndpoint == ec2.InstanceMetadataEndpointStateDisabled:
+		c.Reason = "The instance metadata endpoint is disabled on the instance (HttpEndpoint=disabled)"
+	case c.V2Token == imdsTimeout && !c.HostNetwork && c.HttpPutResponseHopLimit == 1:
+		c.Reason = "The IMDSv2 token response is dropped because the hop limit is 1 and the pod is not using the host network"
+	case c.V2Token == imdsTimeout && c.HttpTokens == ec2.HttpTokensStateRequired:
+		c.Reason = fmt.Sprintf("IMDSv1 is disabled (HttpTokens=required) and the IMDSv2 token request timed out (hop limit %d)", c.HttpPutResponseHopLimit)
+	case c.V1 == imdsUnauthorized && c.V2Token != imdsOK:
+		c.Reason = "IMDSv1 is disabled (HttpTokens=required) and no IMDSv2 token could be obtained"
+	case c.V1 == imdsUnreachable || c.V1 == imdsTimeout:
+		c.Reason = "Instance metadata is not reachable from the pod (blocked by a NetworkPolicy/iptables rule, or the pod runs on Fargate)"
+	default:
+		c.Reason = fmt.Sprintf("Instance metadata is not usable (IMDSv1: %s, IMDSv2 token: %s)", c.V1, c.V2Token)
+	}
+	if c.Reason == "" {
+		return
+	}
+
+	c.Impact = append(c.Impact, "Security groups of the node are read with DescribeInstances instead of instance metadata")
+	if id.Complete() {
+		c.Impact = append(c.Impact, fmt.Sprintf("Cluster name and region were discovered from %q and %q", id.ClusterNameSource, id.RegionSource))
+	} else {
+		c.Impact = append(c.Impact, "Cluster name or region could not be discovered, AWS side checks (security groups, NACLs, add-ons) are skipped; set EKS_DNS_CLUSTER_NAME and AWS_REGION")
+	}
+	if id.InstanceID == "" {
+		c.Impact = append(c.Impact, "Instance ID of the node is unknown, node security groups and MetadataOptions cannot be checked")
+	}
+}
+
+//CheckIMDS probes instance metadata from the pod and explains why metadata based discovery failed
+func CheckIMDS(id *ClusterIdentity, hostNetwork bool) *IMDSCheck {
+	c := &IMDSCheck{HostNetwork: hostNetwork}
+	c.probeIMDS()
+	c.describeMetadataOptions(id.InstanceID, id.Region)
+	c.explain(id)
+	log.Infof("IMDS check: %+v", c)
+	return c
+}
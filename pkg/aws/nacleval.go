@@ -0,0 +1,303 @@
+package aws
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ec2"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	ephemeralPortFrom = 1024
+	ephemeralPortTo   = 65535
+	//linuxEphemeralFrom and linuxEphemeralTo are the default ip_local_port_range of Linux, which pods and nodes send queries from
+	linuxEphemeralFrom = 32768
+	linuxEphemeralTo   = 60999
+	naclActionAllow    = "allow"
+)
+
+//NACLDecision is the deciding rule of one NACL for one leg of a DNS flow.
+//FullRangeRule is set on response legs which allow the Linux ephemeral ports but not all of 1024-65535.
+type NACLDecision struct {
+	NetworkACL    string `json:"networkAcl"`
+	Leg           string `json:"leg"`
+	Allowed       bool   `json:"allowed"`
+	Rule          string `json:"rule"`
+	FullRangeRule string `json:"fullEphemeralRangeRule,omitempty"`
+}
+
+//NACLFlowResult is the NACL evaluation of DNS from client endpoints in one subnet to a coredns endpoint for one protocol.
+//Traffic within a subnet does not pass a NACL.
+type NACLFlowResult struct {
+	Clients      []string       `json:"clients"`
+	ClientSubnet string         `json:"clientSubnet"`
+	Server       string         `json:"coredns"`
+	ServerSubnet string         `json:"corednsSubnet"`
+	Protocol     string         `json:"protocol"`
+	SameSubnet   bool           `json:"sameSubnet"`
+	Allowed      bool           `json:"allowed"`
+	Partial      bool           `json:"partialEphemeralRange,omitempty"`
+	Decisions    []NACLDecision `json:"decisions,omitempty"`
+}
+
+//NACLEvaluation stores the evaluation of NACLs for 53/UDP and 53/TCP between client and coredns subnets
+type NACLEvaluation struct {
+	Flows  []NACLFlowResult `json:"flows,omitempty"`
+	Errors []string         `json:"errors,omitempty"`
+}
+
+//naclProtocolNumbers maps protocol names to the protocol numbers used by NACL entries
+var naclProtocolNumbers = map[string]string{protocolTCP: "6", protocolUDP: "17"}
+
+//portInterval is an inclusive port range
+type portInterval struct{ from, to int64 }
+
+//subtractInterval removes r from every interval of set
+func subtractInterval(set []portInterval, r portInterval) []portInterval {
+	res := make([]portInterval, 0, len(set))
+	for _, i := range set {
+		if r.to < i.from || r.from > i.to {
+			res = append(res, i)
+			continue
+		}
+		if i.from < r.from {
+			res = append(res, portInterval{i.from, r.from - 1})
+		}
+		if i.to > r.to {
+			res = append(res, portInterval{r.to + 1, i.to})
+		}
+	}
+	return res
+}
+
+//describeNACLEntry renders an entry like "acl-1 rule 100 egress allow udp 53-53 10.0.0.0/16"
+func describeNACLEntry(naclID string, entry *ec2.NetworkAclEntry) string {
+	direction := "ingress"
+	if aws.BoolValue(entry.Egress) {
+		direction = "egress"
+	}
+	ports := "all ports"
+	if entry.PortRange != nil {
+		ports = fmt.Sprintf("%d-%d", aws.Int64Value(entry.PortRange.From), aws.Int64Value(entry.PortRange.To))
+	}
+	cidr := aws.StringValue(entry.CidrBlock)
+	if cidr == "" {
+		cidr = aws.StringValue(entry.Ipv6CidrBlock)
+	}
+	number := fmt.Sprintf("%d", aws.Int64Value(entry.RuleNumber))
+	if aws.Int64Value(entry.RuleNumber) == 32767 {
+		number = "*"
+	}
+	return fmt.Sprintf("%s rule %s %s %s protocol %s %s %s", naclID, number, direction, aws.StringValue(entry.RuleAction),
+		aws.StringValue(entry.Protocol), ports, cidr)
+}
+
+//evaluateNACL walks the entries of a NACL in rule number order for one direction and returns whether
+//traffic to/from ip with the protocol is allowed on every port of [from, to], along with the deciding rule(s)
+func evaluateNACL(nacl *ec2.NetworkAcl, egress bool, ip, protocol string, from, to int64) (bool, string) {
+	naclID := aws.StringValue(nacl.NetworkAclId)
+	entries := make([]*ec2.NetworkAclEntry, 0)
+	for _, entry := range nacl.Entries {
+		if aws.BoolValue(entry.Egress) == egress {
+			entries = append(entries, entry)
+		}
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		return aws.Int64Value(entries[i].RuleNumber) < aws.Int64Value(entries[j].RuleNumber)
+	})
+
+	uncovered := []portInterval{{from, to}}
+	allowedBy := make([]string, 0)
+	for _, entry := range entries {
+		entryProtocol := aws.StringValue(entry.Protocol)
+		if entryProtocol != protocolAll && entryProtocol != naclProtocolNumbers[protocol] {
+			continue
+		}
+		if !cidrContains(aws.StringValue(entry.CidrBlock), ip) && !cidrContains(aws.StringValue(entry.Ipv6CidrBlock), ip) {
+			continue
+		}
+		r := portInterval{0, 65535}
+		if entry.PortRange != nil && entryProtocol != protocolAll {
+			r = portInterval{aws.Int64Value(entry.PortRange.From), aws.Int64Value(entry.PortRange.To)}
+		}
+		if !overlaps(uncovered, r) {
+			continue
+		}
+		//an earlier deny wins over later allow rules, even for a part of the port range
+		if aws.StringValue(entry.RuleAction) != naclActionAllow {
+			return false, describeNACLEntry(naclID, entry)
+		}
+		allowedBy = append(allowedBy, describeNACLEntry(naclID, entry))
+		uncovered = subtractInterval(uncovered, r)
+		if len(uncovered) == 0 {
+			return true, strings.Join(allowedBy, "; ")
+		}
+	}
+	return false, fmt.Sprintf("%s has no rule for ports %v", naclID, uncovered)
+}
+
+//overlaps returns true if r overlaps one of the intervals of set
+func overlaps(set []portInterval, r portInterval) bool {
+	for _, i := range set {
+		if r.from <= i.to && r.to >= i.from {
+			return true
+		}
+	}
+	return false
+}
+
+//vpcFilter returns a filter for resources of the VPC
+func vpcFilter(vpcID string) []*ec2.Filter {
+	return []*ec2.Filter{{Name: aws.String("vpc-id"), Values: []*string{aws.String(vpcID)}}}
+}
+
+//describeVpcSubnets returns all subnets of the VPC
+func describeVpcSubnets(ec2Client *ec2Client, vpcID string) ([]*ec2.Subnet, error) {
+	subnets := make([]*ec2.Subnet, 0)
+	err := ec2Client.ec2ServiceClient.DescribeSubnetsPages(&ec2.DescribeSubnetsInput{Filters: vpcFilter(vpcID)},
+		func(page *ec2.DescribeSubnetsOutput, lastPage bool) bool {
+			subnets = append(subnets, page.Subnets...)
+			return !lastPage
+		})
+	if err != nil {
+		logAWSError(err)
+		return nil, fmt.Errorf("Failed to describe subnets of VPC %s: %v", vpcID, err)
+	}
+	return subnets, nil
+}
+
+//naclBySubnet returns the subnets of the VPC and the NACL associated with each subnet
+func naclBySubnet(region, vpcID string) ([]*ec2.Subnet, map[string]*ec2.NetworkAcl, error) {
+	ec2Client, _ := newEC2Client(region)
+	subnets, err := describeVpcSubnets(ec2Client, vpcID)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	nacls := make(map[string]*ec2.NetworkAcl)
+	err = ec2Client.ec2ServiceClient.DescribeNetworkAclsPages(&ec2.DescribeNetworkAclsInput{Filters: vpcFilter(vpcID)},
+		func(page *ec2.DescribeNetworkAclsOutput, lastPage bool) bool {
+			for _, nacl := range page.NetworkAcls {
+				for _, assoc := range nacl.Associations {
+					nacls[aws.StringValue(assoc.SubnetId)] = nacl
+				}
+			}
+			return !lastPage
+		})
+	if err != nil {
+		logAWSError(err)
+		return nil, nil, fmt.Errorf("Failed to describe NACLs of VPC %s: %v", vpcID, err)
+	}
+	return subnets, nacls, nil
+}
+
+//subnetOf returns the ID of the subnet whose CIDR contains the IP
+func subnetOf(subnets []*ec2.Subnet, ip string) string {
+	for _, s := range subnets {
+		if cidrContains(aws.StringValue(s.CidrBlock), ip) {
+			return aws.StringValue(s.SubnetId)
+		}
+		for _, assoc := range s.Ipv6CidrBlockAssociationSet {
+			if cidrContains(aws.StringValue(assoc.Ipv6CidrBlock), ip) {
+				return aws.StringValue(s.SubnetId)
+			}
+		}
+	}
+	return ""
+}
+
+//EvaluateDNSNetworkACLs evaluates the NACLs of the subnets of client and coredns endpoints for 53/UDP and 53/TCP.
+//NACLs are stateless, so requests (port 53) and responses (ephemeral ports) are checked in both subnets.
+//Responses are blocked when the Linux ephemeral ports 32768-60999 are not allowed, and partial when only part of 1024-65535 is.
+func EvaluateDNSNetworkACLs(region, vpcID string, clients, servers []*SGEndpoint) (*NACLEvaluation, error) {
+	eval := &NACLEvaluation{}
+	subnets, nacls, err := naclBySubnet(region, vpcID)
+	if err != nil {
+		return eval, err
+	}
+	//the same endpoint or subnet fails for every pair and protocol, each error is reported once
+	reported := make(map[string]bool)
+	addError := func(msg string) {
+		if !reported[msg] {
+			reported[msg] = true
+			eval.Errors = append(eval.Errors, msg)
+		}
+	}
+
+	grouped := make(map[string]int)
+	for _, server := range servers {
+		serverSubnet := subnetOf(subnets, server.IP)
+		if serverSubnet == "" {
+			addError(fmt.Sprintf("subnet of coredns %s (%s) not found in VPC %s", server.Node, server.IP, vpcID))
+			continue
+		}
+		for _, client := range clients {
+			clientSubnet := subnetOf(subnets, client.IP)
+			if clientSubnet == "" {
+				addError(fmt.Sprintf("subnet of %s (%s) not found in VPC %s", client.Node, client.IP, vpcID))
+				continue
+			}
+			for _, protocol := range []string{protocolUDP, protocolTCP} {
+				flow := NACLFlowResult{
+					ClientSubnet: clientSubnet,
+					Server:       server.Node,
+					ServerSubnet: serverSubnet,
+					Protocol:     protocol,
+					SameSubnet:   clientSubnet == serverSubnet,
+					Allowed:      true,
+				}
+				if !flow.SameSubnet {
+					clientNACL, serverNACL := nacls[clientSubnet], nacls[serverSubnet]
+					if clientNACL == nil || serverNACL == nil {
+						addError(fmt.Sprintf("NACL of subnet %s or %s not found", clientSubnet, serverSubnet))
+						continue
+					}
+					legs := []struct {
+						nacl     *ec2.NetworkAcl
+						egress   bool
+						ip       string
+						from, to int64
+						leg      string
+					}{
+						{clientNACL, true, server.IP, dnsPort, dnsPort, "query leaving client subnet"},
+						{serverNACL, false, client.IP, dnsPort, dnsPort, "query entering coredns subnet"},
+						{serverNACL, true, client.IP, linuxEphemeralFrom, linuxEphemeralTo, "response leaving coredns subnet (ephemeral ports)"},
+						{clientNACL, false, server.IP, linuxEphemeralFrom, linuxEphemeralTo, "response entering client subnet (ephemeral ports)"},
+					}
+					for _, l := range legs {
+						d := NACLDecision{NetworkACL: aws.StringValue(l.nacl.NetworkAclId), Leg: l.leg}
+						d.Allowed, d.Rule = evaluateNACL(l.nacl, l.egress, l.ip, protocol, l.from, l.to)
+						//clients with another ephemeral port range (e.g. Windows 49152-65535) may still be blocked
+						if d.Allowed && l.from == linuxEphemeralFrom {
+							if full, rule := evaluateNACL(l.nacl, l.egress, l.ip, protocol, ephemeralPortFrom, ephemeralPortTo); !full {
+								d.FullRangeRule = rule
+								flow.Partial = true
+							}
+						}
+						flow.Allowed = flow.Allowed && d.Allowed
+						flow.Decisions = append(flow.Decisions, d)
+					}
+				}
+
+				rules := make([]string, 0, len(flow.Decisions))
+				for _, d := range flow.Decisions {
+					rules = append(rules, d.Rule, d.FullRangeRule)
+				}
+				key := strings.Join([]string{clientSubnet, server.Node, protocol, strings.Join(rules, "|")}, "|")
+				if i, ok := grouped[key]; ok {
+					eval.Flows[i].Clients = append(eval.Flows[i].Clients, client.Node)
+					continue
+				}
+				flow.Clients = []string{client.Node}
+				grouped[key] = len(eval.Flows)
+				eval.Flows = append(eval.Flows, flow)
+			}
+		}
+	}
+
+	log.Infof("NACL evaluation for DNS: %+v", eval)
+	return eval, nil
+}
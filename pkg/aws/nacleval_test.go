@@ -0,0 +1,87 @@
+package aws
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ec2"
+)
+
+func TestSubtractInterval(t *testing.T) {
+	tests := []struct {
+		name string
+		set  []portInterval
+		r    portInterval
+		want []portInterval
+	}{
+		{"disjoint", []portInterval{{1024, 2048}}, portInterval{53, 53}, []portInterval{{1024, 2048}}},
+		{"covers all", []portInterval{{1024, 65535}}, portInterval{0, 65535}, []portInterval{}},
+		{"middle", []portInterval{{1024, 65535}}, portInterval{32768, 60999}, []portInterval{{1024, 32767}, {61000, 65535}}},
+		{"lower part", []portInterval{{1024, 65535}}, portInterval{0, 2047}, []portInterval{{2048, 65535}}},
+		{"upper part", []portInterval{{1024, 65535}}, portInterval{49152, 65535}, []portInterval{{1024, 49151}}},
+		{"several intervals", []portInterval{{1024, 2047}, {4096, 8191}}, portInterval{2000, 5000}, []portInterval{{1024, 1999}, {5001, 8191}}},
+	}
+	for _, tt := range tests {
+		if got := subtractInterval(tt.set, tt.r); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: subtractInterval(%v, %v) = %v, want %v", tt.name, tt.set, tt.r, got, tt.want)
+		}
+	}
+}
+
+//naclEntry builds a NACL entry, nil ports means all ports
+func naclEntry(number int64, egress bool, protocol, action, cidr string, ports *ec2.PortRange) *ec2.NetworkAclEntry {
+	return &ec2.NetworkAclEntry{
+		RuleNumber: aws.Int64(number),
+		Egress:     aws.Bool(egress),
+		Protocol:   aws.String(protocol),
+		RuleAction: aws.String(action),
+		CidrBlock:  aws.String(cidr),
+		PortRange:  ports,
+	}
+}
+
+func portRange(from, to int64) *ec2.PortRange {
+	return &ec2.PortRange{From: aws.Int64(from), To: aws.Int64(to)}
+}
+
+func TestEvaluateNACL(t *testing.T) {
+	denyAll := naclEntry(32767, false, protocolAll, "deny", "0.0.0.0/0", nil)
+	tests := []struct {
+		name     string
+		entries  []*ec2.NetworkAclEntry
+		from, to int64
+		want     bool
+		rule     string
+	}{
+		{"default allow all",
+			[]*ec2.NetworkAclEntry{naclEntry(100, false, protocolAll, "allow", "0.0.0.0/0", nil), denyAll},
+			53, 53, true, "rule 100"},
+		{"deny before allow wins",
+			[]*ec2.NetworkAclEntry{naclEntry(200, false, "17", "allow", "10.0.0.0/16", portRange(53, 53)), naclEntry(100, false, "17", "deny", "10.0.1.0/24", portRange(0, 1023)), denyAll},
+			53, 53, false, "rule 100"},
+		{"allow for another protocol",
+			[]*ec2.NetworkAclEntry{naclEntry(100, false, "6", "allow", "10.0.0.0/16", portRange(53, 53)), denyAll},
+			53, 53, false, "rule *"},
+		{"allow for another CIDR",
+			[]*ec2.NetworkAclEntry{naclEntry(100, false, "17", "allow", "192.168.0.0/16", portRange(53, 53)), denyAll},
+			53, 53, false, "rule *"},
+		{"ephemeral range from two rules",
+			[]*ec2.NetworkAclEntry{naclEntry(100, false, "17", "allow", "10.0.0.0/16", portRange(1024, 40000)), naclEntry(110, false, "17", "allow", "10.0.0.0/16", portRange(40001, 65535)), denyAll},
+			1024, 65535, true, "rule 110"},
+		{"partial ephemeral range",
+			[]*ec2.NetworkAclEntry{naclEntry(100, false, "17", "allow", "10.0.0.0/16", portRange(32768, 60999))},
+			1024, 65535, false, "no rule for ports"},
+		{"egress rules are ignored for ingress",
+			[]*ec2.NetworkAclEntry{naclEntry(100, true, protocolAll, "allow", "0.0.0.0/0", nil)},
+			53, 53, false, "no rule for ports"},
+	}
+	for _, tt := range tests {
+		nacl := &ec2.NetworkAcl{NetworkAclId: aws.String("acl-1"), Entries: tt.entries}
+		got, rule := evaluateNACL(nacl, false, "10.0.1.10", protocolUDP, tt.from, tt.to)
+		if got != tt.want || !strings.Contains(rule, tt.rule) {
+			t.Errorf("%s: evaluateNACL = %t, %q, want %t and a rule containing %q", tt.name, got, rule, tt.want, tt.rule)
+		}
+	}
+}
@@ -0,0 +1,160 @@
+package aws
+
+import (
+	"fmt"
+	"net"
+	"sort"
+	"strconv"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/route53resolver"
+	log "github.com/sirupsen/logrus"
+)
+
+//ResolverEndpointIP stores an ENI IP address of a Resolver endpoint
+type ResolverEndpointIP struct {
+	IP            string `json:"ip"`
+	SubnetID      string `json:"subnetId"`
+	Status        string `json:"status"`
+	StatusMessage string `json:"statusMessage,omitempty"`
+}
+
+//ResolverTargetCheck is the security group decision for DNS from a Resolver endpoint to a rule target
+type ResolverTargetCheck struct {
+	Target   string `json:"target"`
+	Protocol string `json:"protocol"`
+	Allowed  bool   `json:"allowed"`
+	Rule     string `json:"rule"`
+}
+
+//ResolverEndpointInfo stores the health and security group evaluation of a Resolver endpoint referenced by the VPC rules
+type ResolverEndpointInfo struct {
+	ID               string                `json:"id"`
+	Name             string                `json:"name,omitempty"`
+	Direction        string                `json:"direction"`
+	Status           string                `json:"status"`
+	StatusMessage    string                `json:"statusMessage,omitempty"`
+	SecurityGroupIDs []string              `json:"securityGroupIds"`
+	IPAddresses      []ResolverEndpointIP  `json:"ipAddresses"`
+	Rules            []string              `json:"rules"`
+	TargetChecks     []ResolverTargetCheck `json:"targetChecks,omitempty"`
+	Errors           []string              `json:"errors,omitempty"`
+}
+
+//describeResolverEndpoint returns the status, security groups and IP addresses of a Resolver endpoint
+func describeResolverEndpoint(client *route53resolver.Route53Resolver, id string) (*ResolverEndpointInfo, error) {
+	result, err := client.GetResolverEndpoint(&route53resolver.GetResolverEndpointInput{ResolverEndpointId: aws.String(id)})
+	if err != nil {
+		logAWSError(err)
+		return nil, fmt.Errorf("Failed to get Resolver endpoint %s: %v", id, err)
+	}
+	ep := result.ResolverEndpoint
+	info := &ResolverEndpointInfo{
+		ID:               id,
+		Name:             aws.StringValue(ep.Name),
+		Direction:        aws.StringValue(ep.Direction),
+		Status:           aws.StringValue(ep.Status),
+		StatusMessage:    aws.StringValue(ep.StatusMessage),
+		SecurityGroupIDs: aws.StringValueSlice(ep.SecurityGroupIds),
+	}
+
+	err = client.ListResolverEndpointIpAddressesPages(&route53resolver.ListResolverEndpointIpAddressesInput{ResolverEndpointId: aws.String(id)},
+		func(page *route53resolver.ListResolverEndpointIpAddressesOutput, lastPage bool) bool {
+			for _, ip := range page.IpAddresses {
+				addr := aws.StringValue(ip.Ip)
+				if addr == "" {
+					addr = aws.StringValue(ip.Ipv6)
+				}
+				info.IPAddresses = append(info.IPAddresses, ResolverEndpointIP{
+					IP:            addr,
+					SubnetID:      aws.StringValue(ip.SubnetId),
+					Status:        aws.StringValue(ip.Status),
+					StatusMessage: aws.StringValue(ip.StatusMessage),
+				})
+			}
+			return !lastPage
+		})
+	if err != nil {
+		logAWSError(err)
+		return info, fmt.Errorf("Failed to list IP addresses of Resolver endpoint %s: %v", id, err)
+	}
+	return info, nil
+}
+
+//CheckResolverEndpoints describes the outbound Resolver endpoints referenced by the forward rules associated with the VPC
+//and evaluates their security groups for 53/UDP and 53/TCP egress to the rule targets.
+//Endpoints of rules shared by another account (AWS RAM) cannot be described from this account and are skipped.
+func CheckResolverEndpoints(region string, rules []ResolverRuleInfo) ([]ResolverEndpointInfo, error) {
+	targets := make(map[string]map[string]bool)
+	ruleNames := make(map[string][]string)
+	for _, r := range rules {
+		if !r.AssociatedWithVpc || r.ResolverEndpointID == "" {
+			continue
+		}
+		if r.ShareStatus == route53resolver.ShareStatusSharedWithMe {
+			log.Infof("Skipping Resolver endpoint %s of rule %s shared by account %s", r.ResolverEndpointID, r.ID, r.OwnerID)
+			continue
+		}
+		if targets[r.ResolverEndpointID] == nil {
+			targets[r.ResolverEndpointID] = make(map[string]bool)
+		}
+		for _, t := range r.TargetIPs {
+			targets[r.ResolverEndpointID][t] = true
+		}
+		ruleNames[r.ResolverEndpointID] = append(ruleNames[r.ResolverEndpointID], fmt.Sprintf("%s (%s)", r.ID, r.DomainName))
+	}
+	if len(targets) == 0 {
+		return nil, nil
+	}
+	ids := make([]string, 0, len(targets))
+	for id := range targets {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+
+	client := route53resolver.New(session.Must(session.NewSession()), aws.NewConfig().WithMaxRetries(maxRetries).WithRegion(region))
+	endpoints := make([]ResolverEndpointInfo, 0, len(ids))
+	for _, id := range ids {
+		info, err := describeResolverEndpoint(client, id)
+		if info == nil {
+			//the other endpoints are still evaluated
+			endpoints = append(endpoints, ResolverEndpointInfo{ID: id, Rules: ruleNames[id], Errors: []string{err.Error()}})
+			continue
+		}
+		if err != nil {
+			info.Errors = append(info.Errors, err.Error())
+		}
+		info.Rules = ruleNames[id]
+
+		e, err := newSGEvaluator(region, info.SecurityGroupIDs)
+		if err != nil {
+			info.Errors = append(info.Errors, err.Error())
+			endpoints = append(endpoints, *info)
+			continue
+		}
+		//the endpoint ENIs share the security groups, its IP is irrelevant for egress rules
+		source := &SGEndpoint{Node: id, SecurityGroups: info.SecurityGroupIDs}
+		targetList := make([]string, 0, len(targets[id]))
+		for t := range targets[id] {
+			targetList = append(targetList, t)
+		}
+		sort.Strings(targetList)
+		for _, target := range targetList {
+			host, portStr, err := net.SplitHostPort(target)
+			if err != nil {
+				continue
+			}
+			port, _ := strconv.ParseInt(portStr, 10, 64)
+			dst := &SGEndpoint{Node: target, IP: host}
+			for _, protocol := range []string{protocolUDP, protocolTCP} {
+				check := ResolverTargetCheck{Target: target, Protocol: protocol}
+				check.Allowed, check.Rule = e.evaluate(source, dst, directionOut, protocol, port)
+				info.TargetChecks = append(info.TargetChecks, check)
+			}
+		}
+		log.Infof("Resolver endpoint %s: %+v", id, info)
+		endpoints = append(endpoints, *info)
+	}
+	return endpoints, nil
+}
@@ -0,0 +1,171 @@
+package aws
+
+import (
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/route53"
+	"github.com/aws/aws-sdk-go/service/route53resolver"
+	log "github.com/sirupsen/logrus"
+)
+
+//ResolverRuleInfo stores a Route 53 Resolver rule and whether it is associated with the cluster VPC
+type ResolverRuleInfo struct {
+	ID                 string   `json:"id"`
+	Name               string   `json:"name,omitempty"`
+	DomainName         string   `json:"domainName"`
+	RuleType           string   `json:"ruleType"`
+	Status             string   `json:"status"`
+	TargetIPs          []string `json:"targetIps,omitempty"`
+	ResolverEndpointID string   `json:"resolverEndpointId,omitempty"`
+	OwnerID            string   `json:"ownerId,omitempty"`
+	ShareStatus        string   `json:"shareStatus,omitempty"`
+	AssociatedWithVpc  bool     `json:"associatedWithVpc"`
+	AssociationStatus  string   `json:"associationStatus,omitempty"`
+}
+
+//HostedZoneInfo stores a private hosted zone and whether it is associated with the cluster VPC
+type HostedZoneInfo struct {
+	ID                string `json:"id"`
+	Name              string `json:"name"`
+	AssociatedWithVpc bool   `json:"associatedWithVpc"`
+}
+
+//Route53Check stores the Resolver rules and private hosted zones relevant to the cluster VPC.
+//RulesListed and HostedZonesListed are false when the list failed, the corresponding slice is then incomplete.
+type Route53Check struct {
+	VpcID             string                 `json:"vpcId"`
+	Rules             []ResolverRuleInfo     `json:"resolverRules,omitempty"`
+	RulesListed       bool                   `json:"resolverRulesListed"`
+	HostedZones       []HostedZoneInfo       `json:"privateHostedZones,omitempty"`
+	HostedZonesListed bool                   `json:"privateHostedZonesListed"`
+	Endpoints         []ResolverEndpointInfo `json:"resolverEndpoints,omitempty"`
+	Errors            []string               `json:"errors,omitempty"`
+}
+
+//listResolverRules returns all Resolver rules of the account/region with their association to the VPC
+func listResolverRules(client *route53resolver.Route53Resolver, vpcID string) ([]ResolverRuleInfo, error) {
+	associations := make(map[string]string)
+	err := client.ListResolverRuleAssociationsPages(&route53resolver.ListResolverRuleAssociationsInput{
+		Filters: []*route53resolver.Filter{{Name: aws.String("VPCId"), Values: []*string{aws.String(vpcID)}}},
+	}, func(page *route53resolver.ListResolverRuleAssociationsOutput, lastPage bool) bool {
+		for _, assoc := range page.ResolverRuleAssociations {
+			associations[aws.StringValue(assoc.ResolverRuleId)] = aws.StringValue(assoc.Status)
+		}
+		return !lastPage
+	})
+	if err != nil {
+		logAWSError(err)
+		return nil, fmt.Errorf("Failed to list Resolver rule associations of VPC %s: %v", vpcID, err)
+	}
+
+	rules := make([]ResolverRuleInfo, 0)
+	err = client.ListResolverRulesPages(&route53resolver.ListResolverRulesInput{},
+		func(page *route53resolver.ListResolverRulesOutput, lastPage bool) bool {
+			for _, r := range page.ResolverRules {
+				info := ResolverRuleInfo{
+					ID:                 aws.StringValue(r.Id),
+					Name:               aws.StringValue(r.Name),
+					DomainName:         aws.StringValue(r.DomainName),
+					RuleType:           aws.StringValue(r.RuleType),
+					Status:             aws.StringValue(r.Status),
+					ResolverEndpointID: aws.StringValue(r.ResolverEndpointId),
+					OwnerID:            aws.StringValue(r.OwnerId),
+					ShareStatus:        aws.StringValue(r.ShareStatus),
+				}
+				for _, t := range r.TargetIps {
+					ip := aws.StringValue(t.Ip)
+					if ip == "" {
+						ip = aws.StringValue(t.Ipv6)
+					}
+					info.TargetIPs = append(info.TargetIPs, net.JoinHostPort(ip, strconv.FormatInt(aws.Int64Value(t.Port), 10)))
+				}
+				info.AssociationStatus, info.AssociatedWithVpc = associations[info.ID]
+				rules = append(rules, info)
+			}
+			return !lastPage
+		})
+	if err != nil {
+		logAWSError(err)
+		return nil, fmt.Errorf("Failed to list Resolver rules: %v", err)
+	}
+	return rules, nil
+}
+
+//listPrivateHostedZones returns the private hosted zones of the account and those associated with the VPC (also from other accounts)
+func listPrivateHostedZones(client *route53.Route53, vpcID, region string) ([]HostedZoneInfo, error) {
+	zones := make(map[string]*HostedZoneInfo)
+	order := make([]string, 0)
+
+	input := &route53.ListHostedZonesByVPCInput{VPCId: aws.String(vpcID), VPCRegion: aws.String(region)}
+	for {
+		page, err := client.ListHostedZonesByVPC(input)
+		if err != nil {
+			logAWSError(err)
+			return nil, fmt.Errorf("Failed to list hosted zones associated with VPC %s: %v", vpcID, err)
+		}
+		for _, z := range page.HostedZoneSummaries {
+			id := strings.TrimPrefix(aws.StringValue(z.HostedZoneId), "/hostedzone/")
+			zones[id] = &HostedZoneInfo{ID: id, Name: aws.StringValue(z.Name), AssociatedWithVpc: true}
+			order = append(order, id)
+		}
+		if aws.StringValue(page.NextToken) == "" {
+			break
+		}
+		input.NextToken = page.NextToken
+	}
+
+	//private zones of the account which are not associated with the VPC
+	err := client.ListHostedZonesPages(&route53.ListHostedZonesInput{}, func(page *route53.ListHostedZonesOutput, lastPage bool) bool {
+		for _, z := range page.HostedZones {
+			id := strings.TrimPrefix(aws.StringValue(z.Id), "/hostedzone/")
+			if z.Config == nil || !aws.BoolValue(z.Config.PrivateZone) {
+				continue
+			}
+			if _, ok := zones[id]; !ok {
+				zones[id] = &HostedZoneInfo{ID: id, Name: aws.StringValue(z.Name)}
+				order = append(order, id)
+			}
+		}
+		return !lastPage
+	})
+	if err != nil {
+		logAWSError(err)
+		return nil, fmt.Errorf("Failed to list hosted zones: %v", err)
+	}
+
+	res := make([]HostedZoneInfo, 0, len(order))
+	for _, id := range order {
+		res = append(res, *zones[id])
+	}
+	return res, nil
+}
+
+//CheckRoute53 lists Route 53 Resolver rules and private hosted zones with their association to the cluster VPC
+func CheckRoute53(region, vpcID string) (*Route53Check, error) {
+	check := &Route53Check{VpcID: vpcID}
+	sess := session.Must(session.NewSession())
+	cfg := aws.NewConfig().WithMaxRetries(maxRetries).WithRegion(region)
+
+	rules, err := listResolverRules(route53resolver.New(sess, cfg), vpcID)
+	if err != nil {
+		check.Errors = append(check.Errors, err.Error())
+	}
+	check.Rules, check.RulesListed = rules, err == nil
+
+	zones, err := listPrivateHostedZones(route53.New(sess, cfg), vpcID, region)
+	if err != nil {
+		check.Errors = append(check.Errors, err.Error())
+	}
+	check.HostedZones, check.HostedZonesListed = zones, err == nil
+
+	if !check.RulesListed && !check.HostedZonesListed {
+		return check, fmt.Errorf("Failed to check Route 53 configuration: %v", check.Errors)
+	}
+	log.Infof("Route 53 check: %+v", check)
+	return check, nil
+}
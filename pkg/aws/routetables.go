@@ -0,0 +1,383 @@
+package aws
+
+import (
+	"fmt"
+	"net"
+	"sort"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ec2"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	//RouteStateOK means the target is routed to an active next hop
+	RouteStateOK = "ok"
+	//RouteStateNoRoute means no route of the route table covers the target
+	RouteStateNoRoute = "no-route"
+	//RouteStateBlackhole means the matching route points to a deleted next hop
+	RouteStateBlackhole = "blackhole"
+	//RouteStateNATUnavailable means the matching route points to a NAT gateway which is not available
+	RouteStateNATUnavailable = "nat-unavailable"
+	//RouteStatePrivateViaInternet means a private (on-prem) target is only covered by a route to the internet
+	RouteStatePrivateViaInternet = "private-target-via-internet"
+	//RouteStateIGWWithoutPublicIP means the target is routed to an internet gateway from a subnet without public IPs
+	RouteStateIGWWithoutPublicIP = "igw-without-public-ip"
+
+	defaultRouteV4      = "0.0.0.0/0"
+	natGatewayAvailable = "available"
+)
+
+//privateCidrs are the RFC 1918 ranges, targets in them are expected to be reached through a TGW, VGW or peering
+var privateCidrs = []string{"10.0.0.0/8", "172.16.0.0/12", "192.168.0.0/16"}
+
+//RouteTarget is a destination which the subnets of a RouteScope must be able to reach
+type RouteTarget struct {
+	IP      string `json:"ip"`
+	Purpose string `json:"purpose"`
+}
+
+//RouteScope groups the subnets (given by IP or subnet ID) of one role with the targets they forward DNS to
+type RouteScope struct {
+	Role      string
+	IPs       []string
+	SubnetIDs []string
+	Targets   []RouteTarget
+}
+
+//TargetRoute is the route selected (longest prefix match) for a target in the route table of a subnet
+type TargetRoute struct {
+	Target      string `json:"target"`
+	Purpose     string `json:"purpose"`
+	Destination string `json:"destination,omitempty"`
+	Via         string `json:"via,omitempty"`
+	State       string `json:"state"`
+	Reachable   bool   `json:"reachable"`
+}
+
+//SubnetRoutes stores the routes of a subnet relevant to DNS forwarding
+type SubnetRoutes struct {
+	SubnetID             string        `json:"subnetId"`
+	Roles                []string      `json:"roles"`
+	RouteTableID         string        `json:"routeTableId"`
+	MainRouteTable       bool          `json:"mainRouteTable"`
+	MapPublicIPOnLaunch  bool          `json:"mapPublicIpOnLaunch"`
+	DefaultRoute         string        `json:"defaultRoute,omitempty"`
+	DefaultRouteState    string        `json:"defaultRouteState,omitempty"`
+	Blackholes           []string      `json:"blackholeRoutes,omitempty"`
+	GatewayEndpoints     []string      `json:"gatewayEndpoints,omitempty"`
+	TransitGatewayRoutes []string      `json:"transitGatewayRoutes,omitempty"`
+	TargetRoutes         []TargetRoute `json:"targetRoutes,omitempty"`
+
+	targets map[string]RouteTarget
+}
+
+//RouteTableCheck stores the route table evaluation of the node, coredns and Resolver endpoint subnets
+type RouteTableCheck struct {
+	VpcID   string         `json:"vpcId"`
+	Subnets []SubnetRoutes `json:"subnets"`
+	Errors  []string       `json:"errors,omitempty"`
+}
+
+//routeVia returns the next hop of a route
+func routeVia(r *ec2.Route) string {
+	for _, via := range []*string{r.GatewayId, r.NatGatewayId, r.TransitGatewayId, r.VpcPeeringConnectionId,
+		r.NetworkInterfaceId, r.InstanceId, r.EgressOnlyInternetGatewayId, r.LocalGatewayId, r.CarrierGatewayId, r.CoreNetworkArn} {
+		if aws.StringValue(via) != "" {
+			return aws.StringValue(via)
+		}
+	}
+	return ""
+}
+
+//routeDestination returns the destination CIDR or prefix list of a route
+func routeDestination(r *ec2.Route) string {
+	for _, dst := range []*string{r.DestinationCidrBlock, r.DestinationIpv6CidrBlock, r.DestinationPrefixListId} {
+		if aws.StringValue(dst) != "" {
+			return aws.StringValue(dst)
+		}
+	}
+	return ""
+}
+
+//isPrivateIP returns true if the IP is in one of the RFC 1918 ranges
+func isPrivateIP(ip string) bool {
+	for _, cidr := range privateCidrs {
+		if cidrContains(cidr, ip) {
+			return true
+		}
+	}
+	return false
+}
+
+//routeTableBySubnet maps every subnet of the VPC to its explicitly associated route table or the main route table
+func routeTableBySubnet(ec2Client *ec2Client, vpcID string, subnets []*ec2.Subnet) (map[string]*ec2.RouteTable, map[string]bool, error) {
+	tables := make([]*ec2.RouteTable, 0)
+	err := ec2Client.ec2ServiceClient.DescribeRouteTablesPages(&ec2.DescribeRouteTablesInput{Filters: vpcFilter(vpcID)},
+		func(page *ec2.DescribeRouteTablesOutput, lastPage bool) bool {
+			tables = append(tables, page.RouteTables...)
+			return !lastPage
+		})
+	if err != nil {
+		logAWSError(err)
+		return nil, nil, fmt.Errorf("Failed to describe route tables of VPC %s: %v", vpcID, err)
+	}
+
+	var mainTable *ec2.RouteTable
+	bySubnet := make(map[string]*ec2.RouteTable)
+	for _, table := range tables {
+		for _, assoc := range table.Associations {
+			if aws.BoolValue(assoc.Main) {
+				mainTable = table
+			}
+			if subnetID := aws.StringValue(assoc.SubnetId); subnetID != "" {
+				bySubnet[subnetID] = table
+			}
+		}
+	}
+
+	isMain := make(map[string]bool)
+	for _, s := range subnets {
+		subnetID := aws.StringValue(s.SubnetId)
+		if _, ok := bySubnet[subnetID]; !ok && mainTable != nil {
+			bySubnet[subnetID] = mainTable
+			isMain[subnetID] = true
+		}
+	}
+	return bySubnet, isMain, nil
+}
+
+//natGatewayStates returns the state of the NAT gateways referenced by the route tables
+func natGatewayStates(ec2Client *ec2Client, tables map[string]*ec2.RouteTable) (map[string]string, error) {
+	ids := make(map[string]bool)
+	for _, table := range tables {
+		for _, r := range table.Routes {
+			if id := aws.StringValue(r.NatGatewayId); id != "" {
+				ids[id] = true
+			}
+		}
+	}
+	states := make(map[string]string)
+	if len(ids) == 0 {
+		return states, nil
+	}
+	input := &ec2.DescribeNatGatewaysInput{}
+	for id := range ids {
+		input.NatGatewayIds = append(input.NatGatewayIds, aws.String(id))
+	}
+	err := ec2Client.ec2ServiceClient.DescribeNatGatewaysPages(input, func(page *ec2.DescribeNatGatewaysOutput, lastPage bool) bool {
+		for _, nat := range page.NatGateways {
+			states[aws.StringValue(nat.NatGatewayId)] = aws.StringValue(nat.State)
+		}
+		return !lastPage
+	})
+	if err != nil {
+		logAWSError(err)
+		return states, fmt.Errorf("Failed to describe NAT gateways: %v", err)
+	}
+	return states, nil
+}
+
+//routeEvaluator selects routes by longest prefix match, resolving prefix list destinations to their CIDRs
+type routeEvaluator struct {
+	ec2Client    *ec2Client
+	prefixLists  map[string][]string
+	prefixErrors map[string]error
+	natStates    map[string]string
+}
+
+//routeCidrs returns the CIDRs covered by the destination of a route
+func (e *routeEvaluator) routeCidrs(r *ec2.Route) []string {
+	if pl := aws.StringValue(r.DestinationPrefixListId); pl != "" {
+		if _, ok := e.prefixLists[pl]; !ok {
+			cidrs, err := getPrefixListCidrs(e.ec2Client, pl)
+			if err != nil {
+				e.prefixErrors[pl] = err
+			}
+			e.prefixLists[pl] = cidrs
+		}
+		return e.prefixLists[pl]
+	}
+	if dst := aws.StringValue(r.DestinationCidrBlock); dst != "" {
+		return []string{dst}
+	}
+	if dst := aws.StringValue(r.DestinationIpv6CidrBlock); dst != "" {
+		return []string{dst}
+	}
+	return nil
+}
+
+//lookup returns the most specific route of the table covering the IP
+func (e *routeEvaluator) lookup(table *ec2.RouteTable, ip string) *ec2.Route {
+	var best *ec2.Route
+	bestLen := -1
+	for _, r := range table.Routes {
+		for _, cidr := range e.routeCidrs(r) {
+			_, ipNet, err := net.ParseCIDR(cidr)
+			if err != nil || !ipNet.Contains(net.ParseIP(ip)) {
+				continue
+			}
+			if ones, _ := ipNet.Mask.Size(); ones > bestLen {
+				best, bestLen = r, ones
+			}
+		}
+	}
+	return best
+}
+
+//evaluate decides whether the target is reachable from a subnet using the route table
+func (e *routeEvaluator) evaluate(table *ec2.RouteTable, subnet *SubnetRoutes, target RouteTarget) TargetRoute {
+	res := TargetRoute{Target: target.IP, Purpose: target.Purpose, State: RouteStateNoRoute}
+	r := e.lookup(table, target.IP)
+	if r == nil {
+		return res
+	}
+	res.Destination = routeDestination(r)
+	res.Via = routeVia(r)
+
+	switch {
+	case aws.StringValue(r.State) == ec2.RouteStateBlackhole:
+		res.State = RouteStateBlackhole
+	case strings.HasPrefix(res.Via, "nat-") && e.natStates[res.Via] != "" && e.natStates[res.Via] != natGatewayAvailable:
+		res.State = RouteStateNATUnavailable
+	case isPrivateIP(target.IP) && (strings.HasPrefix(res.Via, "igw-") || strings.HasPrefix(res.Via, "nat-")):
+		res.State = RouteStatePrivateViaInternet
+	case strings.HasPrefix(res.Via, "igw-") && !subnet.MapPublicIPOnLaunch:
+		//instances may still have an Elastic IP, pods using the node IP through SNAT do not get one
+		res.State = RouteStateIGWWithoutPublicIP
+		res.Reachable = true
+	default:
+		res.State = RouteStateOK
+		res.Reachable = true
+	}
+	return res
+}
+
+//summarizeRoutes records the default route, blackhole routes, gateway endpoints and TGW routes of the table
+func (e *routeEvaluator) summarizeRoutes(table *ec2.RouteTable, subnet *SubnetRoutes) {
+	for _, r := range table.Routes {
+		dst, via := routeDestination(r), routeVia(r)
+		if aws.StringValue(r.State) == ec2.RouteStateBlackhole {
+			subnet.Blackholes = append(subnet.Blackholes, fmt.Sprintf("%s via %s", dst, via))
+		}
+		if strings.HasPrefix(via, "vpce-") {
+			subnet.GatewayEndpoints = append(subnet.GatewayEndpoints, fmt.Sprintf("%s via %s", dst, via))
+		}
+		if aws.StringValue(r.TransitGatewayId) != "" {
+			subnet.TransitGatewayRoutes = append(subnet.TransitGatewayRoutes, fmt.Sprintf("%s via %s", dst, via))
+		}
+		if dst == defaultRouteV4 {
+			subnet.DefaultRoute = via
+			subnet.DefaultRouteState = aws.StringValue(r.State)
+			if strings.HasPrefix(via, "nat-") && e.natStates[via] != "" {
+				subnet.DefaultRouteState = e.natStates[via]
+			}
+		}
+	}
+}
+
+//CheckRouteTables evaluates the route tables of the subnets of every scope, and the routes towards the scope targets
+func CheckRouteTables(region, vpcID string, scopes []RouteScope) (*RouteTableCheck, error) {
+	check := &RouteTableCheck{VpcID: vpcID}
+	ec2Client, _ := newEC2Client(region)
+	subnets, err := describeVpcSubnets(ec2Client, vpcID)
+	if err != nil {
+		return check, err
+	}
+	tables, isMain, err := routeTableBySubnet(ec2Client, vpcID, subnets)
+	if err != nil {
+		return check, err
+	}
+	e := &routeEvaluator{
+		ec2Client:    ec2Client,
+		prefixLists:  make(map[string][]string),
+		prefixErrors: make(map[string]error),
+	}
+	if e.natStates, err = natGatewayStates(ec2Client, tables); err != nil {
+		check.Errors = append(check.Errors, err.Error())
+	}
+
+	publicIP := make(map[string]bool)
+	for _, s := range subnets {
+		publicIP[aws.StringValue(s.SubnetId)] = aws.BoolValue(s.MapPublicIpOnLaunch)
+	}
+
+	//collect the roles and targets of every subnet, a subnet can host nodes, coredns and Resolver endpoints
+	bySubnet := make(map[string]*SubnetRoutes)
+	addSubnet := func(subnetID, role string, targets []RouteTarget) {
+		s, ok := bySubnet[subnetID]
+		if !ok {
+			s = &SubnetRoutes{SubnetID: subnetID, MapPublicIPOnLaunch: publicIP[subnetID], targets: make(map[string]RouteTarget)}
+			bySubnet[subnetID] = s
+		}
+		if !containsString(s.Roles, role) {
+			s.Roles = append(s.Roles, role)
+		}
+		for _, t := range targets {
+			if _, ok := s.targets[t.IP]; !ok {
+				s.targets[t.IP] = t
+			}
+		}
+	}
+	for _, scope := range scopes {
+		for _, ip := range scope.IPs {
+			subnetID := subnetOf(subnets, ip)
+			if subnetID == "" {
+				check.Errors = append(check.Errors, fmt.Sprintf("subnet of %s %s not found in VPC %s", scope.Role, ip, vpcID))
+				continue
+			}
+			addSubnet(subnetID, scope.Role, scope.Targets)
+		}
+		for _, subnetID := range scope.SubnetIDs {
+			addSubnet(subnetID, scope.Role, scope.Targets)
+		}
+	}
+
+	for _, subnetID := range sortedSubnetKeys(bySubnet) {
+		s := bySubnet[subnetID]
+		table, ok := tables[subnetID]
+		if !ok {
+			check.Errors = append(check.Errors, fmt.Sprintf("no route table found for subnet %s", subnetID))
+			continue
+		}
+		s.RouteTableID = aws.StringValue(table.RouteTableId)
+		s.MainRouteTable = isMain[subnetID]
+		e.summarizeRoutes(table, s)
+
+		ips := make([]string, 0, len(s.targets))
+		for ip := range s.targets {
+			ips = append(ips, ip)
+		}
+		sort.Strings(ips)
+		for _, ip := range ips {
+			s.TargetRoutes = append(s.TargetRoutes, e.evaluate(table, s, s.targets[ip]))
+		}
+		check.Subnets = append(check.Subnets, *s)
+	}
+	for _, pl := range sortedErrorKeys(e.prefixErrors) {
+		check.Errors = append(check.Errors, fmt.Sprintf("Failed to read prefix list %s: %v", pl, e.prefixErrors[pl]))
+	}
+	log.Infof("Route table check: %+v", check)
+	return check, nil
+}
+
+//sortedSubnetKeys returns the subnet IDs in sorted order
+func sortedSubnetKeys(m map[string]*SubnetRoutes) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+//containsString returns true if the slice contains the value
+func containsString(slice []string, value string) bool {
+	for _, s := range slice {
+		if s == value {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,345 @@
+package aws
+
+import (
+	"fmt"
+	"net"
+	"sort"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ec2"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	dnsPort      = 53
+	protocolUDP  = "udp"
+	protocolTCP  = "tcp"
+	protocolAll  = "-1"
+	directionIn  = "inbound"
+	directionOut = "outbound"
+)
+
+//protocolNumbers maps protocol names to the numbers which may also appear in IpProtocol
+var protocolNumbers = map[string]string{protocolTCP: "6", protocolUDP: "17"}
+
+//SGEndpoint is a node taking part in DNS traffic, either hosting clients or coredns pods
+type SGEndpoint struct {
+	Node           string   `json:"node"`
+	Pod            string   `json:"pod,omitempty"`
+	InstanceID     string   `json:"instanceId"`
+	IP             string   `json:"ip"`
+	SecurityGroups []string `json:"securityGroups,omitempty"`
+}
+
+//SGFlowResult is the security group decision for DNS from client nodes to a coredns node for one protocol.
+//Client nodes with the same security groups and the same decision are grouped together.
+type SGFlowResult struct {
+	ClientNodes    []string `json:"clientNodes"`
+	ClientSGs      []string `json:"clientSecurityGroups"`
+	Server         string   `json:"corednsNode"`
+	ServerPod      string   `json:"corednsPod,omitempty"`
+	ServerSGs      []string `json:"corednsSecurityGroups"`
+	Protocol       string   `json:"protocol"`
+	EgressAllowed  bool     `json:"egressAllowed"`
+	EgressRule     string   `json:"egressRule"`
+	IngressAllowed bool     `json:"ingressAllowed"`
+	IngressRule    string   `json:"ingressRule"`
+}
+
+//SGEvaluation stores the evaluation of node security groups for 53/UDP and 53/TCP
+type SGEvaluation struct {
+	Flows  []SGFlowResult `json:"flows,omitempty"`
+	Errors []string       `json:"errors,omitempty"`
+}
+
+//sgEvaluator holds the security groups and resolved prefix lists needed to evaluate flows
+type sgEvaluator struct {
+	groups       map[string]*ec2.SecurityGroup
+	prefixLists  map[string][]string
+	prefixErrors map[string]error
+}
+
+//describeInstanceSGs fills the security groups of the endpoints from DescribeInstances
+func describeInstanceSGs(region string, endpoints []*SGEndpoint) error {
+	ids := make([]*string, 0)
+	byID := make(map[string][]*SGEndpoint)
+	for _, ep := range endpoints {
+		if ep.InstanceID == "" || len(ep.SecurityGroups) > 0 {
+			continue
+		}
+		if _, ok := byID[ep.InstanceID]; !ok {
+			ids = append(ids, aws.String(ep.InstanceID))
+		}
+		byID[ep.InstanceID] = append(byID[ep.InstanceID], ep)
+	}
+	if len(ids) == 0 {
+		return nil
+	}
+
+	ec2Client, _ := newEC2Client(region)
+	err := ec2Client.ec2ServiceClient.DescribeInstancesPages(&ec2.DescribeInstancesInput{InstanceIds: ids},
+		func(page *ec2.DescribeInstancesOutput, lastPage bool) bool {
+			for _, reservation := range page.Reservations {
+				for _, instance := range reservation.Instances {
+					sgs := make([]string, 0)
+					for _, sg := range instance.SecurityGroups {
+						sgs = append(sgs, aws.StringValue(sg.GroupId))
+					}
+					sort.Strings(sgs)
+					for _, ep := range byID[aws.StringValue(instance.InstanceId)] {
+						ep.SecurityGroups = sgs
+					}
+				}
+			}
+			return !lastPage
+		})
+	if err != nil {
+		logAWSError(err)
+		return fmt.Errorf("Failed to describe instances of the nodes: %v", err)
+	}
+	return nil
+}
+
+//newSGEvaluator describes the security groups and the prefix lists referenced by their rules
+func newSGEvaluator(region string, groupIDs []string) (*sgEvaluator, error) {
+	e := &sgEvaluator{
+		groups:       make(map[string]*ec2.SecurityGroup),
+		prefixLists:  make(map[string][]string),
+		prefixErrors: make(map[string]error),
+	}
+	if len(groupIDs) == 0 {
+		return e, nil
+	}
+
+	ec2Client, _ := newEC2Client(region)
+	result, err := ec2Client.ec2ServiceClient.DescribeSecurityGroups(&ec2.DescribeSecurityGroupsInput{
+		GroupIds: aws.StringSlice(groupIDs),
+	})
+	if err != nil {
+		logAWSError(err)
+		return nil, fmt.Errorf("Failed to describe security groups %v: %v", groupIDs, err)
+	}
+
+	for _, sg := range result.SecurityGroups {
+		e.groups[aws.StringValue(sg.GroupId)] = sg
+		for _, perms := range [][]*ec2.IpPermission{sg.IpPermissions, sg.IpPermissionsEgress} {
+			for _, perm := range perms {
+				for _, pl := range perm.PrefixListIds {
+					id := aws.StringValue(pl.PrefixListId)
+					if _, done := e.prefixLists[id]; done {
+						continue
+					}
+					if _, failed := e.prefixErrors[id]; failed {
+						continue
+					}
+					cidrs, err := getPrefixListCidrs(ec2Client, id)
+					if err != nil {
+						e.prefixErrors[id] = err
+						continue
+					}
+					e.prefixLists[id] = cidrs
+				}
+			}
+		}
+	}
+	return e, nil
+}
+
+//getPrefixListCidrs returns the CIDRs of a managed prefix list
+func getPrefixListCidrs(ec2Client *ec2Client, prefixListID string) ([]string, error) {
+	cidrs := make([]string, 0)
+	err := ec2Client.ec2ServiceClient.GetManagedPrefixListEntriesPages(&ec2.GetManagedPrefixListEntriesInput{
+		PrefixListId: aws.String(prefixListID),
+	}, func(page *ec2.GetManagedPrefixListEntriesOutput, lastPage bool) bool {
+		for _, entry := range page.Entries {
+			cidrs = append(cidrs, aws.StringValue(entry.Cidr))
+		}
+		return !lastPage
+	})
+	if err != nil {
+		logAWSError(err)
+		return nil, err
+	}
+	return cidrs, nil
+}
+
+//protocolMatches returns true if the rule covers protocol and port
+func protocolMatches(perm *ec2.IpPermission, protocol string, port int64) bool {
+	ipProtocol := aws.StringValue(perm.IpProtocol)
+	if ipProtocol == protocolAll {
+		return true
+	}
+	if ipProtocol != protocol && ipProtocol != protocolNumbers[protocol] {
+		return false
+	}
+	//tcp/udp rules without ports do not exist, but be lenient with nil ports
+	if perm.FromPort == nil || perm.ToPort == nil {
+		return true
+	}
+	return aws.Int64Value(perm.FromPort) <= port && port <= aws.Int64Value(perm.ToPort)
+}
+
+//cidrContains returns true if the IP is in the CIDR
+func cidrContains(cidr, ip string) bool {
+	_, ipnet, err := net.ParseCIDR(cidr)
+	addr := net.ParseIP(ip)
+	return err == nil && addr != nil && ipnet.Contains(addr)
+}
+
+//describeRule renders a rule like "sg-1 inbound udp 53-53 from sg-2"
+func describeRule(groupID, direction string, perm *ec2.IpPermission, peer string) string {
+	ports := "all ports"
+	if aws.StringValue(perm.IpProtocol) != protocolAll && perm.FromPort != nil {
+		ports = fmt.Sprintf("%d-%d", aws.Int64Value(perm.FromPort), aws.Int64Value(perm.ToPort))
+	}
+	protocol := aws.StringValue(perm.IpProtocol)
+	if protocol == protocolAll {
+		protocol = "all traffic"
+	}
+	preposition := "from"
+	if direction == directionOut {
+		preposition = "to"
+	}
+	return fmt.Sprintf("%s %s %s %s %s %s", groupID, direction, protocol, ports, preposition, peer)
+}
+
+//matchPeer returns a description of the peer of the rule which matches the other endpoint, or "" if none matches
+func (e *sgEvaluator) matchPeer(perm *ec2.IpPermission, other *SGEndpoint) string {
+	for _, pair := range perm.UserIdGroupPairs {
+		for _, sg := range other.SecurityGroups {
+			if aws.StringValue(pair.GroupId) == sg {
+				return sg
+			}
+		}
+	}
+	for _, r := range perm.IpRanges {
+		if cidrContains(aws.StringValue(r.CidrIp), other.IP) {
+			return aws.StringValue(r.CidrIp)
+		}
+	}
+	for _, r := range perm.Ipv6Ranges {
+		if cidrContains(aws.StringValue(r.CidrIpv6), other.IP) {
+			return aws.StringValue(r.CidrIpv6)
+		}
+	}
+	for _, pl := range perm.PrefixListIds {
+		id := aws.StringValue(pl.PrefixListId)
+		for _, cidr := range e.prefixLists[id] {
+			if cidrContains(cidr, other.IP) {
+				return fmt.Sprintf("%s (%s)", id, cidr)
+			}
+		}
+	}
+	return ""
+}
+
+//evaluate returns whether the security groups of ep allow protocol/port in the given direction to/from other, and the rule deciding it
+func (e *sgEvaluator) evaluate(ep, other *SGEndpoint, direction, protocol string, port int64) (bool, string) {
+	missing := make([]string, 0)
+	for _, groupID := range ep.SecurityGroups {
+		sg, ok := e.groups[groupID]
+		if !ok {
+			missing = append(missing, groupID)
+			continue
+		}
+		perms := sg.IpPermissions
+		if direction == directionOut {
+			perms = sg.IpPermissionsEgress
+		}
+		for _, perm := range perms {
+			if !protocolMatches(perm, protocol, port) {
+				continue
+			}
+			if peer := e.matchPeer(perm, other); peer != "" {
+				return true, describeRule(groupID, direction, perm, peer)
+			}
+		}
+	}
+
+	reason := fmt.Sprintf("no %s rule of %s allows %s %d %s %s (%s)", direction, strings.Join(ep.SecurityGroups, ", "),
+		protocol, port, map[string]string{directionIn: "from", directionOut: "to"}[direction], other.Node, other.IP)
+	if len(missing) > 0 {
+		reason += fmt.Sprintf(", security groups %v could not be described", missing)
+	}
+	if len(e.prefixErrors) > 0 {
+		reason += fmt.Sprintf(", prefix lists %v could not be resolved", sortedErrorKeys(e.prefixErrors))
+	}
+	return false, reason
+}
+
+//sortedErrorKeys returns the sorted keys of a map of errors
+func sortedErrorKeys(m map[string]error) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+//EvaluateDNSSecurityGroups evaluates whether the security groups of every client node and coredns node pair
+//allow 53/UDP and 53/TCP, egress from the client and ingress to the coredns node (security groups are stateful).
+//Pairs on the same node are skipped, their traffic does not leave the instance.
+func EvaluateDNSSecurityGroups(region string, clients, servers []*SGEndpoint) (*SGEvaluation, error) {
+	eval := &SGEvaluation{}
+	if err := describeInstanceSGs(region, append(append([]*SGEndpoint{}, clients...), servers...)); err != nil {
+		return eval, err
+	}
+
+	groupSet := make(map[string]bool)
+	for _, ep := range append(append([]*SGEndpoint{}, clients...), servers...) {
+		for _, sg := range ep.SecurityGroups {
+			groupSet[sg] = true
+		}
+	}
+	groupIDs := make([]string, 0, len(groupSet))
+	for sg := range groupSet {
+		groupIDs = append(groupIDs, sg)
+	}
+	sort.Strings(groupIDs)
+
+	e, err := newSGEvaluator(region, groupIDs)
+	if err != nil {
+		return eval, err
+	}
+	for id, perr := range e.prefixErrors {
+		eval.Errors = append(eval.Errors, fmt.Sprintf("prefix list %s: %v", id, perr))
+	}
+
+	grouped := make(map[string]int)
+	for _, server := range servers {
+		if len(server.SecurityGroups) == 0 {
+			eval.Errors = append(eval.Errors, fmt.Sprintf("security groups of coredns node %s are unknown", server.Node))
+			continue
+		}
+		for _, client := range clients {
+			if len(client.SecurityGroups) == 0 || client.Node == server.Node {
+				continue
+			}
+			for _, protocol := range []string{protocolUDP, protocolTCP} {
+				flow := SGFlowResult{
+					ClientSGs: client.SecurityGroups,
+					Server:    server.Node,
+					ServerPod: server.Pod,
+					ServerSGs: server.SecurityGroups,
+					Protocol:  protocol,
+				}
+				flow.EgressAllowed, flow.EgressRule = e.evaluate(client, server, directionOut, protocol, dnsPort)
+				flow.IngressAllowed, flow.IngressRule = e.evaluate(server, client, directionIn, protocol, dnsPort)
+
+				key := strings.Join([]string{strings.Join(client.SecurityGroups, ","), server.Node, server.Pod, protocol, flow.EgressRule, flow.IngressRule}, "|")
+				if i, ok := grouped[key]; ok {
+					eval.Flows[i].ClientNodes = append(eval.Flows[i].ClientNodes, client.Node)
+					continue
+				}
+				flow.ClientNodes = []string{client.Node}
+				grouped[key] = len(eval.Flows)
+				eval.Flows = append(eval.Flows, flow)
+			}
+		}
+	}
+
+	log.Infof("Security group evaluation for DNS: %+v", eval)
+	return eval, nil
+}
@@ -0,0 +1,51 @@
+package aws
+
+import (
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ec2"
+)
+
+func TestProtocolMatches(t *testing.T) {
+	tests := []struct {
+		name     string
+		perm     *ec2.IpPermission
+		protocol string
+		want     bool
+	}{
+		{"all traffic", &ec2.IpPermission{IpProtocol: aws.String(protocolAll)}, protocolUDP, true},
+		{"udp 53", &ec2.IpPermission{IpProtocol: aws.String("udp"), FromPort: aws.Int64(53), ToPort: aws.Int64(53)}, protocolUDP, true},
+		{"udp number", &ec2.IpPermission{IpProtocol: aws.String("17"), FromPort: aws.Int64(0), ToPort: aws.Int64(65535)}, protocolUDP, true},
+		{"tcp only", &ec2.IpPermission{IpProtocol: aws.String("tcp"), FromPort: aws.Int64(53), ToPort: aws.Int64(53)}, protocolUDP, false},
+		{"other ports", &ec2.IpPermission{IpProtocol: aws.String("udp"), FromPort: aws.Int64(1024), ToPort: aws.Int64(65535)}, protocolUDP, false},
+		{"no ports", &ec2.IpPermission{IpProtocol: aws.String("tcp")}, protocolTCP, true},
+	}
+	for _, tt := range tests {
+		if got := protocolMatches(tt.perm, tt.protocol, dnsPort); got != tt.want {
+			t.Errorf("%s: protocolMatches = %t, want %t", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestMatchPeer(t *testing.T) {
+	e := &sgEvaluator{prefixLists: map[string][]string{"pl-1": {"10.1.0.0/16"}}}
+	other := &SGEndpoint{Node: "node-1", IP: "10.1.2.3", SecurityGroups: []string{"sg-node"}}
+	tests := []struct {
+		name string
+		perm *ec2.IpPermission
+		want string
+	}{
+		{"security group", &ec2.IpPermission{UserIdGroupPairs: []*ec2.UserIdGroupPair{{GroupId: aws.String("sg-node")}}}, "sg-node"},
+		{"other security group", &ec2.IpPermission{UserIdGroupPairs: []*ec2.UserIdGroupPair{{GroupId: aws.String("sg-other")}}}, ""},
+		{"cidr", &ec2.IpPermission{IpRanges: []*ec2.IpRange{{CidrIp: aws.String("10.0.0.0/8")}}}, "10.0.0.0/8"},
+		{"other cidr", &ec2.IpPermission{IpRanges: []*ec2.IpRange{{CidrIp: aws.String("192.168.0.0/16")}}}, ""},
+		{"prefix list", &ec2.IpPermission{PrefixListIds: []*ec2.PrefixListId{{PrefixListId: aws.String("pl-1")}}}, "pl-1 (10.1.0.0/16)"},
+		{"unresolved prefix list", &ec2.IpPermission{PrefixListIds: []*ec2.PrefixListId{{PrefixListId: aws.String("pl-2")}}}, ""},
+	}
+	for _, tt := range tests {
+		if got := e.matchPeer(tt.perm, other); got != tt.want {
+			t.Errorf("%s: matchPeer = %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,123 @@
+package aws
+
+import (
+	"encoding/binary"
+	"fmt"
+	"net"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ec2"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	//AmazonDNSLinkLocalIP is the link-local address of the Amazon provided DNS server
+	AmazonDNSLinkLocalIP  = "169.254.169.253"
+	amazonProvidedDNS     = "AmazonProvidedDNS"
+	dhcpDomainNameServers = "domain-name-servers"
+	dhcpDomainName        = "domain-name"
+)
+
+//VPCDNSCheck stores the DNS attributes and the DHCP options set of the cluster VPC
+type VPCDNSCheck struct {
+	VpcID                 string   `json:"vpcId"`
+	CidrBlocks            []string `json:"cidrBlocks"`
+	EnableDNSSupport      bool     `json:"enableDnsSupport"`
+	EnableDNSHostnames    bool     `json:"enableDnsHostnames"`
+	DhcpOptionsID         string   `json:"dhcpOptionsId"`
+	DomainNameServers     []string `json:"domainNameServers,omitempty"`
+	DomainName            []string `json:"domainName,omitempty"`
+	UsesAmazonProvidedDNS bool     `json:"usesAmazonProvidedDNS"`
+	AmazonDNSIP           string   `json:"amazonDnsIp,omitempty"`
+}
+
+//vpcResolverIP returns the Amazon DNS server address of a VPC CIDR, the base of the CIDR plus two
+func vpcResolverIP(cidr string) string {
+	ip, _, err := net.ParseCIDR(cidr)
+	if err != nil || ip.To4() == nil {
+		return ""
+	}
+	n := binary.BigEndian.Uint32(ip.To4()) + 2
+	res := make(net.IP, 4)
+	binary.BigEndian.PutUint32(res, n)
+	return res.String()
+}
+
+//describeVpcAttribute returns the value of enableDnsSupport or enableDnsHostnames
+func (e *ec2Client) describeVpcAttribute(vpcID, attribute string) (bool, error) {
+	result, err := e.ec2ServiceClient.DescribeVpcAttribute(&ec2.DescribeVpcAttributeInput{
+		VpcId:     aws.String(vpcID),
+		Attribute: aws.String(attribute),
+	})
+	if err != nil {
+		logAWSError(err)
+		return false, fmt.Errorf("Failed to describe VPC attribute %s of %s: %v", attribute, vpcID, err)
+	}
+	if attribute == ec2.VpcAttributeNameEnableDnsSupport {
+		return aws.BoolValue(result.EnableDnsSupport.Value), nil
+	}
+	return aws.BoolValue(result.EnableDnsHostnames.Value), nil
+}
+
+//CheckVPCDNS reads the DNS attributes, CIDRs and the DHCP options set of the VPC
+func CheckVPCDNS(region, vpcID string) (*VPCDNSCheck, error) {
+	check := &VPCDNSCheck{VpcID: vpcID}
+	ec2Client, _ := newEC2Client(region)
+
+	vpcs, err := ec2Client.ec2ServiceClient.DescribeVpcs(&ec2.DescribeVpcsInput{VpcIds: []*string{aws.String(vpcID)}})
+	if err != nil {
+		logAWSError(err)
+		return nil, fmt.Errorf("Failed to describe VPC %s: %v", vpcID, err)
+	}
+	if len(vpcs.Vpcs) == 0 {
+		return nil, fmt.Errorf("VPC %s not found", vpcID)
+	}
+	vpc := vpcs.Vpcs[0]
+	check.DhcpOptionsID = aws.StringValue(vpc.DhcpOptionsId)
+	check.AmazonDNSIP = vpcResolverIP(aws.StringValue(vpc.CidrBlock))
+	for _, assoc := range vpc.CidrBlockAssociationSet {
+		check.CidrBlocks = append(check.CidrBlocks, aws.StringValue(assoc.CidrBlock))
+	}
+
+	//a partial check would report the unknown attributes as disabled, so nothing is returned on errors
+	if check.EnableDNSSupport, err = ec2Client.describeVpcAttribute(vpcID, ec2.VpcAttributeNameEnableDnsSupport); err != nil {
+		return nil, err
+	}
+	if check.EnableDNSHostnames, err = ec2Client.describeVpcAttribute(vpcID, ec2.VpcAttributeNameEnableDnsHostnames); err != nil {
+		return nil, err
+	}
+
+	//a VPC without a DHCP options set has the ID "default" and uses AmazonProvidedDNS
+	if check.DhcpOptionsID == "" || check.DhcpOptionsID == "default" {
+		check.UsesAmazonProvidedDNS = true
+		return check, nil
+	}
+	dhcp, err := ec2Client.ec2ServiceClient.DescribeDhcpOptions(&ec2.DescribeDhcpOptionsInput{
+		DhcpOptionsIds: []*string{aws.String(check.DhcpOptionsID)},
+	})
+	if err != nil {
+		logAWSError(err)
+		return nil, fmt.Errorf("Failed to describe DHCP options set %s: %v", check.DhcpOptionsID, err)
+	}
+	for _, opts := range dhcp.DhcpOptions {
+		for _, conf := range opts.DhcpConfigurations {
+			values := make([]string, 0, len(conf.Values))
+			for _, v := range conf.Values {
+				values = append(values, aws.StringValue(v.Value))
+			}
+			switch aws.StringValue(conf.Key) {
+			case dhcpDomainNameServers:
+				check.DomainNameServers = values
+			case dhcpDomainName:
+				check.DomainName = values
+			}
+		}
+	}
+	for _, server := range check.DomainNameServers {
+		if server == amazonProvidedDNS || server == check.AmazonDNSIP || server == AmazonDNSLinkLocalIP {
+			check.UsesAmazonProvidedDNS = true
+		}
+	}
+	log.Infof("VPC DNS check: %+v", check)
+	return check, nil
+}
@@ -0,0 +1,181 @@
+package aws
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ec2"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	//EndpointAnswerENI means every answer is an IP of the endpoint ENIs
+	EndpointAnswerENI = "endpoint-ips"
+	//EndpointAnswerPublic means at least one answer is a public IP, the endpoint is bypassed
+	EndpointAnswerPublic = "public-ips"
+	//EndpointAnswerOtherPrivate means the answers are private IPs which do not belong to the endpoint
+	EndpointAnswerOtherPrivate = "other-private-ips"
+
+	awsServicePrefix = "com.amazonaws."
+)
+
+//VPCEndpointInfo stores a VPC endpoint of the cluster VPC with its private DNS settings and ENI IPs
+type VPCEndpointInfo struct {
+	ID                string   `json:"id"`
+	ServiceName       string   `json:"serviceName"`
+	Type              string   `json:"type"`
+	State             string   `json:"state"`
+	PrivateDNSEnabled bool     `json:"privateDnsEnabled"`
+	PrivateDNSName    string   `json:"privateDnsName,omitempty"`
+	Hostname          string   `json:"hostname,omitempty"`
+	SubnetIDs         []string `json:"subnetIds,omitempty"`
+	ENIIPs            []string `json:"eniIps,omitempty"`
+}
+
+//VPCEndpointsCheck stores the VPC endpoints of the cluster VPC
+type VPCEndpointsCheck struct {
+	VpcID     string            `json:"vpcId"`
+	Endpoints []VPCEndpointInfo `json:"endpoints,omitempty"`
+	Errors    []string          `json:"errors,omitempty"`
+}
+
+//Classify compares DNS answers for the endpoint hostname with the endpoint ENI IPs
+func (ep *VPCEndpointInfo) Classify(answers []string) string {
+	enis := make(map[string]bool)
+	for _, ip := range ep.ENIIPs {
+		enis[ip] = true
+	}
+	res := EndpointAnswerENI
+	for _, ip := range answers {
+		switch {
+		case enis[ip]:
+		case !isPrivateIP(ip):
+			return EndpointAnswerPublic
+		default:
+			res = EndpointAnswerOtherPrivate
+		}
+	}
+	return res
+}
+
+//serviceHostname derives the regional hostname of an AWS service from the endpoint service name,
+//e.g. com.amazonaws.us-east-1.ecr.api -> api.ecr.us-east-1.amazonaws.com
+func serviceHostname(serviceName, region string) string {
+	prefix := awsServicePrefix + region + "."
+	if !strings.HasPrefix(serviceName, prefix) {
+		return ""
+	}
+	parts := strings.Split(strings.TrimPrefix(serviceName, prefix), ".")
+	for i, j := 0, len(parts)-1; i < j; i, j = i+1, j-1 {
+		parts[i], parts[j] = parts[j], parts[i]
+	}
+	return strings.Join(parts, ".") + "." + region + ".amazonaws.com"
+}
+
+//privateDNSNames returns the private DNS name of every endpoint service
+func (e *ec2Client) privateDNSNames(serviceNames []string) (map[string]string, error) {
+	names := make(map[string]string)
+	input := &ec2.DescribeVpcEndpointServicesInput{}
+	for _, name := range serviceNames {
+		input.ServiceNames = append(input.ServiceNames, aws.String(name))
+	}
+	result, err := e.ec2ServiceClient.DescribeVpcEndpointServices(input)
+	if err != nil {
+		logAWSError(err)
+		return names, fmt.Errorf("Failed to describe VPC endpoint services: %v", err)
+	}
+	for _, svc := range result.ServiceDetails {
+		names[aws.StringValue(svc.ServiceName)] = aws.StringValue(svc.PrivateDnsName)
+	}
+	return names, nil
+}
+
+//eniIPs returns the private IPs of the network interfaces
+func (e *ec2Client) eniIPs(eniIDs []string) (map[string][]string, error) {
+	ips := make(map[string][]string)
+	input := &ec2.DescribeNetworkInterfacesInput{}
+	for _, id := range eniIDs {
+		input.NetworkInterfaceIds = append(input.NetworkInterfaceIds, aws.String(id))
+	}
+	err := e.ec2ServiceClient.DescribeNetworkInterfacesPages(input, func(page *ec2.DescribeNetworkInterfacesOutput, lastPage bool) bool {
+		for _, eni := range page.NetworkInterfaces {
+			id := aws.StringValue(eni.NetworkInterfaceId)
+			for _, addr := range eni.PrivateIpAddresses {
+				ips[id] = append(ips[id], aws.StringValue(addr.PrivateIpAddress))
+			}
+		}
+		return !lastPage
+	})
+	if err != nil {
+		logAWSError(err)
+		return ips, fmt.Errorf("Failed to describe network interfaces of VPC endpoints: %v", err)
+	}
+	return ips, nil
+}
+
+//CheckVPCEndpoints lists the VPC endpoints of the VPC with their private DNS settings,
+//the hostname which pods use for the service and the IPs of the endpoint ENIs
+func CheckVPCEndpoints(region, vpcID string) (*VPCEndpointsCheck, error) {
+	check := &VPCEndpointsCheck{VpcID: vpcID}
+	ec2Client, _ := newEC2Client(region)
+
+	endpoints := make([]*ec2.VpcEndpoint, 0)
+	err := ec2Client.ec2ServiceClient.DescribeVpcEndpointsPages(&ec2.DescribeVpcEndpointsInput{Filters: vpcFilter(vpcID)},
+		func(page *ec2.DescribeVpcEndpointsOutput, lastPage bool) bool {
+			endpoints = append(endpoints, page.VpcEndpoints...)
+			return !lastPage
+		})
+	if err != nil {
+		logAWSError(err)
+		return check, fmt.Errorf("Failed to describe VPC endpoints of VPC %s: %v", vpcID, err)
+	}
+
+	serviceNames, eniIDs := make([]string, 0), make([]string, 0)
+	for _, ep := range endpoints {
+		if aws.StringValue(ep.VpcEndpointType) != ec2.VpcEndpointTypeInterface {
+			continue
+		}
+		serviceNames = append(serviceNames, aws.StringValue(ep.ServiceName))
+		eniIDs = append(eniIDs, aws.StringValueSlice(ep.NetworkInterfaceIds)...)
+	}
+	dnsNames, ips := make(map[string]string), make(map[string][]string)
+	if len(serviceNames) > 0 {
+		if dnsNames, err = ec2Client.privateDNSNames(serviceNames); err != nil {
+			check.Errors = append(check.Errors, err.Error())
+		}
+	}
+	if len(eniIDs) > 0 {
+		if ips, err = ec2Client.eniIPs(eniIDs); err != nil {
+			check.Errors = append(check.Errors, err.Error())
+		}
+	}
+
+	for _, ep := range endpoints {
+		info := VPCEndpointInfo{
+			ID:                aws.StringValue(ep.VpcEndpointId),
+			ServiceName:       aws.StringValue(ep.ServiceName),
+			Type:              aws.StringValue(ep.VpcEndpointType),
+			State:             aws.StringValue(ep.State),
+			PrivateDNSEnabled: aws.BoolValue(ep.PrivateDnsEnabled),
+			SubnetIDs:         aws.StringValueSlice(ep.SubnetIds),
+		}
+		if info.Type == ec2.VpcEndpointTypeInterface {
+			info.PrivateDNSName = dnsNames[info.ServiceName]
+			info.Hostname = info.PrivateDNSName
+			//wildcard names (e.g. *.dkr.ecr.<region>.amazonaws.com) are queried with the account ID like registry hostnames
+			if strings.HasPrefix(info.Hostname, "*.") {
+				info.Hostname = aws.StringValue(ep.OwnerId) + strings.TrimPrefix(info.Hostname, "*")
+			}
+			if info.Hostname == "" {
+				info.Hostname = serviceHostname(info.ServiceName, region)
+			}
+			for _, id := range aws.StringValueSlice(ep.NetworkInterfaceIds) {
+				info.ENIIPs = append(info.ENIIPs, ips[id]...)
+			}
+		}
+		check.Endpoints = append(check.Endpoints, info)
+	}
+	log.Infof("VPC endpoints check: %+v", check)
+	return check, nil
+}
@@ -0,0 +1,20 @@
+package aws
+
+import "testing"
+
+func TestServiceHostname(t *testing.T) {
+	tests := []struct {
+		service, region, want string
+	}{
+		{"com.amazonaws.us-east-1.ecr.api", "us-east-1", "api.ecr.us-east-1.amazonaws.com"},
+		{"com.amazonaws.us-east-1.ecr.dkr", "us-east-1", "dkr.ecr.us-east-1.amazonaws.com"},
+		{"com.amazonaws.eu-west-1.sts", "eu-west-1", "sts.eu-west-1.amazonaws.com"},
+		{"com.amazonaws.eu-west-1.sts", "us-east-1", ""},
+		{"com.amazonaws.vpce.us-east-1.vpce-svc-0123", "us-east-1", ""},
+	}
+	for _, tt := range tests {
+		if got := serviceHostname(tt.service, tt.region); got != tt.want {
+			t.Errorf("serviceHostname(%q, %q) = %q, want %q", tt.service, tt.region, got, tt.want)
+		}
+	}
+}
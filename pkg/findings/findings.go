@@ -0,0 +1,36 @@
+package findings
+
+// Severity describes how serious a finding is
+type Severity string
+
+const (
+	// SeverityInfo is used for observations which do not break DNS but are worth knowing
+	SeverityInfo Severity = "info"
+	// SeverityWarning is used for misconfigurations which can break DNS under some conditions
+	SeverityWarning Severity = "warning"
+	// SeverityCritical is used for problems which are breaking (or will break) DNS resolution
+	SeverityCritical Severity = "critical"
+)
+
+// Finding describes a single problem detected during the diagnosis along with its likely root cause and fix
+// Example: {"id": "COREDNS-LOG-LOOP", "severity": "critical", "explanation": "...", "remediation": "...", "evidence": ["..."]}
+type Finding struct {
+	ID          string   `json:"id"`
+	Severity    Severity `json:"severity"`
+	Source      string   `json:"source"`
+	Explanation string   `json:"explanation"`
+	Remediation string   `json:"remediation,omitempty"`
+	Evidence    []string `json:"evidence,omitempty"`
+}
+
+// New returns a Finding for the given check (source)
+func New(id string, severity Severity, source, explanation, remediation string, evidence ...string) Finding {
+	return Finding{
+		ID:          id,
+		Severity:    severity,
+		Source:      source,
+		Explanation: explanation,
+		Remediation: remediation,
+		Evidence:    evidence,
+	}
+}